@@ -0,0 +1,302 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BatchPredictionSpec defines the desired state of BatchPrediction
+type BatchPredictionSpec struct {
+	// ModelRef names the Model in the namespace whose artifact, image and
+	// columns run the prediction. Exactly one of modelRef and model is set.
+	// +optional
+	ModelRef *corev1.LocalObjectReference `json:"modelRef,omitempty"`
+
+	// Model describes the model inline, with the fields of a Model spec,
+	// for predictions with a model that is not served online.
+	// +optional
+	Model *BatchModel `json:"model,omitempty"`
+
+	// Input is the dataset the predictions are made for. It is downloaded
+	// like a model artifact, before the predictor starts.
+	Input ModelStorage `json:"input"`
+
+	// Output is where the predictions are written.
+	Output BatchOutput `json:"output"`
+
+	// Shards splits the input between this many pods of an indexed Job.
+	// Each pod is told its shard and predicts its share of the rows.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+	// +optional
+	Shards *int32 `json:"shards,omitempty"`
+
+	// Parallelism is the number of shards running at once. Defaults to all
+	// of them.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Parallelism *int32 `json:"parallelism,omitempty"`
+
+	// BackoffLimit is the number of pod failures tolerated before the
+	// prediction fails.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=3
+	// +optional
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+
+	// ActiveDeadline is how long the prediction may run before it fails.
+	// +optional
+	ActiveDeadline *metav1.Duration `json:"activeDeadline,omitempty"`
+
+	// Command and Args replace those of the serving container, for images
+	// with a separate batch entrypoint.
+	// +optional
+	Command []string `json:"command,omitempty"`
+	// +optional
+	Args []string `json:"args,omitempty"`
+
+	// Resources of the predictor container. They replace the resources of
+	// the model.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Schedule runs the prediction as a CronJob, in the cron format of
+	// CronJobs, e.g. "0 2 * * *" or "@daily". Without it the prediction
+	// runs once. Each run predicts the input as it is when the run starts,
+	// so output.path usually contains $(BATCH_RUN).
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+
+	// Suspend stops scheduling new runs. Runs already started continue.
+	// +optional
+	Suspend *bool `json:"suspend,omitempty"`
+
+	// ConcurrencyPolicy tells what to do when a run is due while the last
+	// one is still running. Defaults to Forbid, which skips the new run.
+	// +kubebuilder:validation:Enum=Allow;Forbid;Replace
+	// +optional
+	ConcurrencyPolicy batchv1.ConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+
+	// StartingDeadline is how late a run may start after its scheduled
+	// time. Missed runs count as failed.
+	// +optional
+	StartingDeadline *metav1.Duration `json:"startingDeadline,omitempty"`
+
+	// SuccessfulRunsHistoryLimit is the number of successful runs whose Job
+	// is kept. Defaults to 3.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	SuccessfulRunsHistoryLimit *int32 `json:"successfulRunsHistoryLimit,omitempty"`
+
+	// FailedRunsHistoryLimit is the number of failed runs whose Job is
+	// kept. Defaults to 1.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	FailedRunsHistoryLimit *int32 `json:"failedRunsHistoryLimit,omitempty"`
+}
+
+// BatchModel is a model given inline on a BatchPrediction.
+type BatchModel struct {
+	// Storage is where the model artifact is read from.
+	Storage ModelStorage `json:"storage"`
+
+	// Framework the model is written in, see the Model spec.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9._]*[a-z0-9])?$`
+	// +kubebuilder:default=sklearn
+	// +optional
+	Framework string `json:"framework,omitempty"`
+
+	// Runtime names the ServingRuntime or ClusterServingRuntime whose
+	// container runs the prediction, see the Model spec.
+	// +optional
+	Runtime string `json:"runtime,omitempty"`
+
+	// ImageRepository of the image; Version is used as the tag.
+	// +optional
+	ImageRepository string `json:"imageRepository,omitempty"`
+
+	Version string `json:"version"`
+
+	Columns string `json:"columns"`
+}
+
+// BatchOutput is a volume claim the predictions are written to.
+type BatchOutput struct {
+	// ClaimName of a volume claim in the namespace of the prediction. It
+	// must be writable by every shard at once for more than one shard.
+	ClaimName string `json:"claimName"`
+
+	// Path of the predictions relative to the root of the volume. Each
+	// shard writes its own file under it. $(BATCH_RUN) is replaced with the
+	// name of the Job of the run which, for scheduled runs, ends in the
+	// scheduled time in minutes since the Unix epoch.
+	Path string `json:"path"`
+}
+
+// BatchPredictionPhase sums up the state of a prediction.
+type BatchPredictionPhase string
+
+const (
+	// BatchPending means the Job was not created yet, e.g. the Model is
+	// missing.
+	BatchPending BatchPredictionPhase = "Pending"
+	// BatchRunning means shards of the Job are still to complete.
+	BatchRunning BatchPredictionPhase = "Running"
+	// BatchSucceeded means every shard completed.
+	BatchSucceeded BatchPredictionPhase = "Succeeded"
+	// BatchFailed means the Job failed or could not be created.
+	BatchFailed BatchPredictionPhase = "Failed"
+	// BatchScheduled means the CronJob of a scheduled prediction runs it.
+	BatchScheduled BatchPredictionPhase = "Scheduled"
+	// BatchSuspended means a scheduled prediction is suspended.
+	BatchSuspended BatchPredictionPhase = "Suspended"
+)
+
+// Condition types reported in BatchPredictionStatus.
+const (
+	// ConditionComplete is True once every shard completed.
+	ConditionComplete = "Complete"
+	// ConditionFailed is True once the prediction failed.
+	ConditionFailed = "Failed"
+)
+
+// BatchPredictionStatus defines the observed state of BatchPrediction
+type BatchPredictionStatus struct {
+	// Conditions describe the latest observations of the prediction.
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// ObservedGeneration is the most recent generation reconciled by the operator.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Phase of the prediction.
+	// +optional
+	Phase BatchPredictionPhase `json:"phase,omitempty"`
+
+	// Message explains the phase.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// JobName is the Job running the prediction, or the last run of a
+	// scheduled prediction. The counts below are those of this Job.
+	// +optional
+	JobName string `json:"jobName,omitempty"`
+
+	// Progress is the number of completed shards out of all of them, e.g. 3/4.
+	// +optional
+	Progress string `json:"progress,omitempty"`
+
+	// SucceededShards is the number of shards that completed.
+	// +optional
+	SucceededShards int32 `json:"succeededShards,omitempty"`
+
+	// ActiveShards is the number of shards running.
+	// +optional
+	ActiveShards int32 `json:"activeShards,omitempty"`
+
+	// FailedPods is the number of pods that failed, retries included.
+	// +optional
+	FailedPods int32 `json:"failedPods,omitempty"`
+
+	// RowsProcessed is the number of rows the completed shards predicted.
+	// +optional
+	RowsProcessed int64 `json:"rowsProcessed,omitempty"`
+
+	// RowsFailed is the number of rows the completed shards could not
+	// predict.
+	// +optional
+	RowsFailed int64 `json:"rowsFailed,omitempty"`
+
+	// StartTime is when the Job started.
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when the prediction succeeded or failed.
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// ActiveRuns is the number of runs of a scheduled prediction running.
+	// +optional
+	ActiveRuns int32 `json:"activeRuns,omitempty"`
+
+	// LastScheduleTime is when a scheduled prediction last started a run.
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// LastSuccessfulTime is when a run of a scheduled prediction last
+	// succeeded.
+	// +optional
+	LastSuccessfulTime *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+
+	// NextScheduleTime is when the next run of a scheduled prediction is
+	// due, unset while it is suspended.
+	// +optional
+	NextScheduleTime *metav1.Time `json:"nextScheduleTime,omitempty"`
+}
+
+// Scheduled reports whether the prediction runs on a schedule.
+func (s *BatchPredictionSpec) Scheduled() bool {
+	return s.Schedule != ""
+}
+
+// Finished reports whether the prediction succeeded or failed. Scheduled
+// predictions never finish.
+func (s *BatchPredictionStatus) Finished() bool {
+	return s.Phase == BatchSucceeded || s.Phase == BatchFailed
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:shortName=bp
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Progress",type=string,JSONPath=`.status.progress`
+//+kubebuilder:printcolumn:name="Rows",type=integer,JSONPath=`.status.rowsProcessed`
+//+kubebuilder:printcolumn:name="Failed Rows",type=integer,JSONPath=`.status.rowsFailed`,priority=1
+//+kubebuilder:printcolumn:name="Schedule",type=string,JSONPath=`.spec.schedule`,priority=1
+//+kubebuilder:printcolumn:name="Last Success",type=date,JSONPath=`.status.lastSuccessfulTime`,priority=1
+//+kubebuilder:printcolumn:name="Next Run",type=string,JSONPath=`.status.nextScheduleTime`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// BatchPrediction runs a model over a dataset as a Kubernetes Job.
+type BatchPrediction struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BatchPredictionSpec   `json:"spec,omitempty"`
+	Status BatchPredictionStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// BatchPredictionList contains a list of BatchPrediction
+type BatchPredictionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BatchPrediction `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BatchPrediction{}, &BatchPredictionList{})
+}
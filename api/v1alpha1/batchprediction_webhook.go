@@ -0,0 +1,145 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"path"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// maxScheduledNameLength is the longest name of a scheduled prediction. Its
+// CronJob is named bp-<name>, and CronJob names may not exceed 52 characters
+// so the names of their Jobs fit a label value.
+const maxScheduledNameLength = 52 - len("bp-")
+
+// log is for logging in this package.
+var batchpredictionlog = logf.Log.WithName("batchprediction-resource")
+
+// SetupWebhookWithManager registers the validating webhook.
+func (r *BatchPrediction) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-ml-kalkyai-com-v1alpha1-batchprediction,mutating=false,failurePolicy=fail,sideEffects=None,groups=ml.kalkyai.com,resources=batchpredictions,verbs=create;update,versions=v1alpha1,name=vbatchprediction.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &BatchPrediction{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *BatchPrediction) ValidateCreate() error {
+	batchpredictionlog.Info("validate create", "name", r.Name)
+
+	return r.toError(r.Validate())
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *BatchPrediction) ValidateUpdate(old runtime.Object) error {
+	batchpredictionlog.Info("validate update", "name", r.Name)
+
+	allErrs := r.Validate()
+	if oldBatch, ok := old.(*BatchPrediction); ok {
+		allErrs = append(allErrs, r.validateImmutable(oldBatch)...)
+	}
+	return r.toError(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *BatchPrediction) ValidateDelete() error {
+	return nil
+}
+
+func (r *BatchPrediction) toError(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("BatchPrediction").GroupKind(), r.Name, allErrs)
+}
+
+// validateImmutable rejects changes the Job cannot follow. The Job of a
+// prediction is created once, while the CronJob of a scheduled prediction
+// is updated for the runs to come.
+func (r *BatchPrediction) validateImmutable(old *BatchPrediction) field.ErrorList {
+	specPath := field.NewPath("spec")
+
+	if r.Spec.Scheduled() != old.Spec.Scheduled() {
+		return field.ErrorList{field.Forbidden(specPath.Child("schedule"), "may not be added or removed, create a new BatchPrediction instead")}
+	}
+	if !r.Spec.Scheduled() && !apiequality.Semantic.DeepEqual(r.Spec, old.Spec) {
+		return field.ErrorList{field.Forbidden(specPath, "is immutable, create a new BatchPrediction instead")}
+	}
+	return nil
+}
+
+// Validate checks the spec. The operator runs it as well, so predictions
+// created while the webhook was not running fail instead of running an
+// incomplete Job.
+func (r *BatchPrediction) Validate() field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	switch {
+	case r.Spec.ModelRef != nil && r.Spec.Model != nil:
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("model"), "may not be combined with modelRef"))
+	case r.Spec.ModelRef != nil:
+		if r.Spec.ModelRef.Name == "" {
+			allErrs = append(allErrs, field.Required(specPath.Child("modelRef", "name"), ""))
+		}
+	case r.Spec.Model != nil:
+		modelPath := specPath.Child("model")
+		allErrs = append(allErrs, validateStorage(&r.Spec.Model.Storage, modelPath.Child("storage"))...)
+		if !imageTagPattern.MatchString(r.Spec.Model.Version) {
+			allErrs = append(allErrs, field.Invalid(modelPath.Child("version"), r.Spec.Model.Version, "must be a valid image tag"))
+		}
+	default:
+		allErrs = append(allErrs, field.Required(specPath.Child("modelRef"), "either modelRef or model is required"))
+	}
+
+	allErrs = append(allErrs, validateStorage(&r.Spec.Input, specPath.Child("input"))...)
+
+	outputPath := specPath.Child("output")
+	for _, msg := range apivalidation.NameIsDNSSubdomain(r.Spec.Output.ClaimName, false) {
+		allErrs = append(allErrs, field.Invalid(outputPath.Child("claimName"), r.Spec.Output.ClaimName, msg))
+	}
+	if p := r.Spec.Output.Path; p == "" || path.IsAbs(p) || strings.HasPrefix(path.Clean(p), "..") {
+		allErrs = append(allErrs, field.Invalid(outputPath.Child("path"), p, "must be a relative path inside the volume"))
+	}
+
+	if r.Spec.Resources != nil {
+		allErrs = append(allErrs, validateResources(r.Spec.Resources, specPath.Child("resources"))...)
+	}
+
+	if r.Spec.Scheduled() {
+		if _, err := cron.ParseStandard(r.Spec.Schedule); err != nil {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("schedule"), r.Spec.Schedule, err.Error()))
+		}
+		if len(r.Name) > maxScheduledNameLength {
+			allErrs = append(allErrs, field.TooLong(field.NewPath("metadata", "name"), r.Name, maxScheduledNameLength))
+		}
+	}
+	return allErrs
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("BatchPrediction Webhook", func() {
+
+	var batch *BatchPrediction
+
+	BeforeEach(func() {
+		batch = &BatchPrediction{
+			ObjectMeta: metav1.ObjectMeta{Name: "june", Namespace: "test"},
+			Spec: BatchPredictionSpec{
+				ModelRef: &corev1.LocalObjectReference{Name: "iris"},
+				Input:    ModelStorage{HTTP: &HTTPStorage{URL: "https://example.com/iris/june.csv"}},
+				Output:   BatchOutput{ClaimName: "predictions", Path: "iris/june"},
+			},
+		}
+	})
+
+	It("should accept a prediction with a referenced model", func() {
+		Expect(batch.ValidateCreate()).To(Succeed())
+	})
+
+	It("should accept a prediction with an inline model", func() {
+		batch.Spec.ModelRef = nil
+		batch.Spec.Model = &BatchModel{
+			Storage: ModelStorage{PVC: &PVCStorage{ClaimName: "models", Path: "iris.sav"}},
+			Version: "0.6",
+			Columns: "a,b",
+		}
+		Expect(batch.ValidateCreate()).To(Succeed())
+	})
+
+	It("should require exactly one model", func() {
+		batch.Spec.Model = &BatchModel{Version: "0.6"}
+		Expect(causes(batch.ValidateCreate())).To(ConsistOf("spec.model"))
+
+		batch.Spec.ModelRef, batch.Spec.Model = nil, nil
+		Expect(causes(batch.ValidateCreate())).To(ConsistOf("spec.modelRef"))
+	})
+
+	It("should reject incomplete input and an output outside the volume", func() {
+		batch.Spec.Input = ModelStorage{}
+		batch.Spec.Output.Path = "../etc"
+
+		Expect(causes(batch.ValidateCreate())).To(ConsistOf("spec.input", "spec.output.path"))
+	})
+
+	It("should reject an invalid schedule and names too long for a CronJob", func() {
+		batch.Spec.Schedule = "every night"
+		batch.Name = strings.Repeat("a", 50)
+
+		Expect(causes(batch.ValidateCreate())).To(ConsistOf("spec.schedule", "metadata.name"))
+	})
+
+	It("should allow changes to a scheduled prediction", func() {
+		batch.Spec.Schedule = "0 2 * * *"
+		updated := batch.DeepCopy()
+		suspend := true
+		updated.Spec.Suspend = &suspend
+		updated.Spec.Output.Path = "iris/$(BATCH_RUN)"
+
+		Expect(updated.ValidateUpdate(batch)).To(Succeed())
+	})
+
+	It("should reject adding a schedule", func() {
+		updated := batch.DeepCopy()
+		updated.Spec.Schedule = "@daily"
+
+		Expect(causes(updated.ValidateUpdate(batch))).To(ConsistOf("spec.schedule"))
+	})
+
+	It("should reject changes to the spec", func() {
+		updated := batch.DeepCopy()
+		updated.Spec.Output.Path = "iris/july"
+
+		Expect(causes(updated.ValidateUpdate(batch))).To(ConsistOf("spec"))
+	})
+})
@@ -17,7 +17,10 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -28,25 +31,838 @@ type ModelSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// Foo is an example field of Model. Edit model_types.go to remove/update
-	Location  string `json:"location"`
-	Replicas  int32  `json:"replicas"`
-	Accesskey string `json:"access_key"`
-	SecretKey string `json:"secret_key"`
-	Endpoint  string `json:"endpoint"`
-	Columns   string `json:"columns"`
-	Version   string `json:"version"`
-	Bucket    string `json:"bucket"`
+	// Location is the key of the model in the bucket.
+	// Deprecated: use Storage.
+	// +optional
+	Location string `json:"location,omitempty"`
+	// Replicas is the number of serving pods. It is also the replica count
+	// of the scale subresource.
+	// +kubebuilder:validation:Minimum=0
+	Replicas int32 `json:"replicas"`
+
+	// Autoscaling hands the replica count to a HorizontalPodAutoscaler.
+	// Replicas is ignored while it is set.
+	// +optional
+	Autoscaling *Autoscaling `json:"autoscaling,omitempty"`
+
+	// IdleTimeout scales the model to zero after this long without
+	// requests. While no serving pod is ready, requests to the model
+	// Service go through the activator, which holds them while the model
+	// scales back up. Ready pods get the requests directly, and the ones
+	// they served are read from their metrics endpoint, see Monitoring.
+	// Models whose pods publish no such metrics stay behind the activator.
+	// +optional
+	IdleTimeout *metav1.Duration `json:"idleTimeout,omitempty"`
+
+	// StorageSize is the size of the volume the model is downloaded to.
+	// It cannot be changed once the model is created.
+	// Defaults to the operator configuration.
+	// +optional
+	StorageSize *resource.Quantity `json:"storageSize,omitempty"`
+
+	// StorageClassName of the volume the model is downloaded to. It cannot
+	// be changed once the model is created. Defaults to the operator
+	// configuration, or the cluster default storage class.
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// Framework the model is written in. It selects the serving runtime:
+	// image, arguments, port and health endpoints. The operator has built-in
+	// runtimes for sklearn, xgboost, lightgbm, onnx, pmml and custom; other
+	// formats need a ServingRuntime supporting them.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9._]*[a-z0-9])?$`
+	// +kubebuilder:default=sklearn
+	// +optional
+	Framework string `json:"framework,omitempty"`
+
+	// Runtime names the ServingRuntime in the namespace of the model, or
+	// else the ClusterServingRuntime, that serves the model. When empty a
+	// runtime auto selecting the framework is used, or else the built-in
+	// runtime of the framework.
+	// +optional
+	Runtime string `json:"runtime,omitempty"`
+
+	// ImageRepository of the serving image; Version is used as the tag.
+	// It replaces the image of a built-in runtime and is required for
+	// custom models. Defaults to the operator configuration for sklearn.
+	// Serving runtimes bring their own image.
+	// +optional
+	ImageRepository string `json:"imageRepository,omitempty"`
+
+	// Port the serving container listens on and the service exposes.
+	// Defaults to the port of the framework runtime, or the operator
+	// configuration for sklearn and custom models. The port of a serving
+	// runtime container wins.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port *int32 `json:"port,omitempty"`
+
+	// Accesskey is the object storage access key in plain text.
+	// Deprecated: use CredentialsSecretRef. Inline credentials are copied
+	// into an operator-managed Secret and never exposed in a ConfigMap.
+	// +optional
+	Accesskey string `json:"access_key,omitempty"`
+	// SecretKey is the object storage secret key in plain text.
+	// Deprecated: use CredentialsSecretRef.
+	// +optional
+	SecretKey string `json:"secret_key,omitempty"`
+
+	// CredentialsSecretRef points at a Secret in the model's namespace that
+	// holds the object storage credentials. Takes precedence over the
+	// deprecated inline access_key and secret_key.
+	// +optional
+	CredentialsSecretRef *CredentialsSecretRef `json:"credentialsSecretRef,omitempty"`
+
+	// Endpoint of the S3 compatible object store.
+	// Deprecated: use Storage.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+	Columns  string `json:"columns"`
+	Version  string `json:"version"`
+	// Bucket holding the model.
+	// Deprecated: use Storage.
+	// +optional
+	Bucket string `json:"bucket,omitempty"`
+
+	// Storage is where the model artifact is read from. It replaces the
+	// location, endpoint, bucket and credential fields, which describe an
+	// S3 compatible store.
+	// +optional
+	Storage *ModelStorage `json:"storage,omitempty"`
+
+	// Artifact holds what the operator verifies about the artifact it
+	// downloads before the serving container starts.
+	// +optional
+	Artifact *ArtifactVerification `json:"artifact,omitempty"`
+
+	// Cleanup decides what is removed when the Model is deleted.
+	// +optional
+	Cleanup *CleanupPolicy `json:"cleanup,omitempty"`
+
+	// Rollout runs a new version or location as a canary next to the
+	// current one and shifts traffic to it in steps. Without it a new
+	// revision replaces the pods in place.
+	// +optional
+	Rollout *RolloutStrategy `json:"rollout,omitempty"`
+
+	// ProgressDeadline is how long a new revision may take to become
+	// ready before the operator rolls back to the last revision that
+	// served.
+	// +kubebuilder:default="10m"
+	// +optional
+	ProgressDeadline *metav1.Duration `json:"progressDeadline,omitempty"`
+
+	// RevisionHistoryLimit is the number of served revisions kept in the
+	// status for rollbacks.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=10
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+
+	// Resources of the serving container. They replace the resources of
+	// the serving runtime.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// NodeSelector restricts the serving pods to nodes with these labels.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations of the serving pods.
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// Affinity of the serving pods.
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// TopologySpreadConstraints of the serving pods. When unset and the
+	// model may run more than one replica, the replicas are spread across
+	// zones where possible. An empty list turns the default off.
+	// +optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+
+	// PriorityClassName of the serving pods.
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// PodTemplateOverrides is a partial pod template merged onto the
+	// template of the serving pods with strategic merge patch semantics:
+	// containers, volumes and env are merged by name, so a container named
+	// serving changes the serving container and other names add sidecars.
+	// The labels selecting the serving pods cannot be changed.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Type=object
+	// +optional
+	PodTemplateOverrides *runtime.RawExtension `json:"podTemplateOverrides,omitempty"`
+
+	// Probes of the serving container. Unset probes keep the defaults of
+	// the runtime: its health endpoints, or a TCP check of the serving port.
+	// +optional
+	Probes *ModelProbes `json:"probes,omitempty"`
+
+	// Exposure publishes the model outside the cluster through an Ingress
+	// or a Gateway API HTTPRoute owned by the model.
+	// +optional
+	Exposure *Exposure `json:"exposure,omitempty"`
+
+	// Monitoring configures the ServiceMonitor scraping the serving pods
+	// and the PrometheusRule alerting on them. Both are created for every
+	// model while the Prometheus Operator CRDs are installed.
+	// +optional
+	Monitoring *Monitoring `json:"monitoring,omitempty"`
+}
+
+// ExposureKind is the kind of object routing external traffic to a model.
+// +kubebuilder:validation:Enum=Ingress;HTTPRoute
+type ExposureKind string
+
+const (
+	// ExposureIngress generates a networking.k8s.io/v1 Ingress.
+	ExposureIngress ExposureKind = "Ingress"
+	// ExposureHTTPRoute generates a gateway.networking.k8s.io HTTPRoute.
+	ExposureHTTPRoute ExposureKind = "HTTPRoute"
+)
+
+// Exposure describes how a model is reached from outside the cluster.
+type Exposure struct {
+	// Kind of the generated route.
+	// +kubebuilder:default=Ingress
+	// +optional
+	Kind ExposureKind `json:"kind,omitempty"`
+
+	// Host the model is served at.
+	Host string `json:"host"`
+
+	// Path prefix the model is served under.
+	// +kubebuilder:default="/"
+	// +kubebuilder:validation:Pattern=`^/`
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// TLSSecretName is the Secret holding the certificate of host. An
+	// Ingress terminates TLS with it. For an HTTPRoute the Gateway listener
+	// terminates TLS and is expected to reference it. Either way the
+	// external URL becomes https.
+	// +optional
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+
+	// ClassName is the IngressClass of an Ingress. Empty uses the cluster
+	// default class.
+	// +optional
+	ClassName string `json:"className,omitempty"`
+
+	// Gateway the HTTPRoute attaches to. Required for HTTPRoute.
+	// +optional
+	Gateway *GatewayReference `json:"gateway,omitempty"`
+
+	// Annotations added to the Ingress or HTTPRoute.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// GatewayReference identifies a Gateway and optionally one of its listeners.
+type GatewayReference struct {
+	// Name of the Gateway.
+	Name string `json:"name"`
+
+	// Namespace of the Gateway. Defaults to the namespace of the model.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// SectionName is the listener of the Gateway to attach to.
+	// +optional
+	SectionName string `json:"sectionName,omitempty"`
+}
+
+// Monitoring configures how Prometheus scrapes and alerts on a model.
+type Monitoring struct {
+	// Disabled skips the ServiceMonitor and PrometheusRule of the model.
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Port the serving pods publish metrics on. Defaults to the serving
+	// port.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port *int32 `json:"port,omitempty"`
+
+	// Path the metrics are published at. Defaults to /metrics.
+	// +kubebuilder:validation:Pattern=`^/`
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// Interval between scrapes. Defaults to the interval of Prometheus.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Labels added to the ServiceMonitor and PrometheusRule, for the
+	// selectors of the Prometheus instance that should pick them up.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Alerts are the thresholds of the default alerts.
+	// +optional
+	Alerts *ModelAlerts `json:"alerts,omitempty"`
+}
+
+// ModelAlerts holds the thresholds of the alerts generated for a model:
+// ModelDown, ModelHighErrorRate and ModelHighLatency.
+type ModelAlerts struct {
+	// Disabled skips the PrometheusRule of the model.
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// For is how long a threshold is crossed before the alert fires.
+	// Defaults to 5m.
+	// +optional
+	For *metav1.Duration `json:"for,omitempty"`
+
+	// MaxErrorRate is the percentage of requests answered with a 5xx
+	// status above which ModelHighErrorRate fires. Defaults to 5.
+	// +optional
+	MaxErrorRate *resource.Quantity `json:"maxErrorRate,omitempty"`
+
+	// MaxLatency is the 99th percentile request latency above which
+	// ModelHighLatency fires. Defaults to 1s.
+	// +optional
+	MaxLatency *metav1.Duration `json:"maxLatency,omitempty"`
+
+	// RequestsMetric is the counter of requests served, with the HTTP
+	// status in a code label. Defaults to http_requests_total.
+	// +optional
+	RequestsMetric string `json:"requestsMetric,omitempty"`
+
+	// LatencyMetric is the histogram of request durations in seconds,
+	// without the _bucket suffix. Defaults to
+	// http_request_duration_seconds.
+	// +optional
+	LatencyMetric string `json:"latencyMetric,omitempty"`
+}
+
+// ModelProbes configures the health checks of the serving container.
+type ModelProbes struct {
+	// Readiness decides when a pod receives requests.
+	// +optional
+	Readiness *ModelProbe `json:"readiness,omitempty"`
+
+	// Liveness restarts the serving container when it fails.
+	// +optional
+	Liveness *ModelProbe `json:"liveness,omitempty"`
+
+	// Startup holds the other probes off while the model loads. Its
+	// default budget grows with the size of the artifact.
+	// +optional
+	Startup *ModelProbe `json:"startup,omitempty"`
+}
+
+// ModelProbe is an HTTP or TCP check of the serving port. Unset fields keep
+// the default of the probe.
+type ModelProbe struct {
+	// HTTPPath is checked with an HTTP GET on the serving port. {name} is
+	// replaced with the model name.
+	// +kubebuilder:validation:Pattern=`^/`
+	// +optional
+	HTTPPath string `json:"httpPath,omitempty"`
+
+	// TCP only checks that the serving port accepts connections.
+	// +optional
+	TCP bool `json:"tcp,omitempty"`
+
+	// Disabled removes the probe.
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	InitialDelaySeconds *int32 `json:"initialDelaySeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	PeriodSeconds *int32 `json:"periodSeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	FailureThreshold *int32 `json:"failureThreshold,omitempty"`
+}
+
+// Autoscaling describes the HorizontalPodAutoscaler of a model. Without a
+// target the autoscaler aims at 80% CPU utilization.
+type Autoscaling struct {
+	// MinReplicas is the lower limit of replicas.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// MaxReplicas is the upper limit of replicas.
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// TargetCPUUtilization is the average CPU utilization of the serving
+	// pods to aim at, in percent of their requests.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TargetCPUUtilization *int32 `json:"targetCPUUtilization,omitempty"`
+
+	// TargetMemoryUtilization is the average memory utilization of the
+	// serving pods to aim at, in percent of their requests.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TargetMemoryUtilization *int32 `json:"targetMemoryUtilization,omitempty"`
+
+	// Metrics are custom per-pod metrics to aim at, such as requests per
+	// second, served by a metrics adapter.
+	// +optional
+	Metrics []PodMetricTarget `json:"metrics,omitempty"`
+}
+
+// PodMetricTarget is the average value of a custom pod metric to aim at.
+type PodMetricTarget struct {
+	// Name of the metric, e.g. requests_per_second.
+	Name string `json:"name"`
+
+	// TargetAverageValue is the value to aim at, averaged over the pods.
+	TargetAverageValue resource.Quantity `json:"targetAverageValue"`
+}
+
+// ArtifactVerification describes the expected model artifact.
+type ArtifactVerification struct {
+	// SHA256 is the hex encoded SHA-256 checksum of the artifact. Pods
+	// fail to start when the downloaded artifact does not match it.
+	// +kubebuilder:validation:Pattern=`^[a-f0-9]{64}$`
+	// +optional
+	SHA256 string `json:"sha256,omitempty"`
+
+	// Size of the artifact. It sets the default budget of the startup
+	// probe and defaults to storageSize.
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
+}
+
+// RolloutStrategy describes a canary rollout.
+type RolloutStrategy struct {
+	// Steps are the traffic weights the canary goes through, in order.
+	// The canary is promoted once the last step has passed.
+	// +kubebuilder:validation:MinItems=1
+	Steps []RolloutStep `json:"steps"`
+
+	// Analysis gates every step on the health of the canary.
+	// +optional
+	Analysis *RolloutAnalysis `json:"analysis,omitempty"`
+}
+
+// RolloutStep is one weight of a canary rollout.
+type RolloutStep struct {
+	// Weight is the percentage of traffic sent to the canary. The Service
+	// balances over pods, so the canary gets the share of replicas closest
+	// to the weight, with at least one canary and one stable replica: a 10%
+	// step on a model with two replicas sends half of the traffic to the
+	// canary. status.rollout.weight reports the actual share.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	Weight int32 `json:"weight"`
+
+	// Pause is how long the step runs before the next one starts. A step
+	// never ends before its canary replicas are ready.
+	// +optional
+	Pause *metav1.Duration `json:"pause,omitempty"`
+}
+
+// RolloutAnalysis holds the thresholds that abort a canary rollout. They
+// are checked until the canary is promoted.
+type RolloutAnalysis struct {
+	// ReadyTimeout is how long the canary replicas of a step may take to
+	// become ready.
+	// +kubebuilder:default="10m"
+	// +optional
+	ReadyTimeout *metav1.Duration `json:"readyTimeout,omitempty"`
+
+	// MaxRestarts is the number of serving container restarts tolerated
+	// across the canary pods.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxRestarts int32 `json:"maxRestarts,omitempty"`
+
+	// MaxErrorRate is the percentage of requests the canary pods may answer
+	// with a 5xx status. It is read from the requests metric of
+	// spec.monitoring.alerts on the metrics endpoint of every canary pod,
+	// and checked while the canary has served requests. Unset, the error
+	// rate is not checked.
+	// +optional
+	MaxErrorRate *resource.Quantity `json:"maxErrorRate,omitempty"`
+}
+
+// ModelStorage is where the model artifact is read from. Exactly one
+// backend is set.
+type ModelStorage struct {
+	// +optional
+	S3 *S3Storage `json:"s3,omitempty"`
+	// +optional
+	GCS *GCSStorage `json:"gcs,omitempty"`
+	// +optional
+	AzureBlob *AzureBlobStorage `json:"azureBlob,omitempty"`
+	// +optional
+	HTTP *HTTPStorage `json:"http,omitempty"`
+	// +optional
+	PVC *PVCStorage `json:"pvc,omitempty"`
+	// +optional
+	OCI *OCIStorage `json:"oci,omitempty"`
+}
+
+// S3Storage reads the model from an S3 compatible object store.
+type S3Storage struct {
+	// Endpoint of the store, e.g. https://sgp1.digitaloceanspaces.com.
+	Endpoint string `json:"endpoint"`
+
+	// Region used to sign requests. Defaults to us-east-1.
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	Bucket string `json:"bucket"`
+
+	// Key of the model in the bucket.
+	Key string `json:"key"`
+
+	// CredentialsSecretRef selects the access and secret keys. Requests
+	// are anonymous without it.
+	// +optional
+	CredentialsSecretRef *CredentialsSecretRef `json:"credentialsSecretRef,omitempty"`
+}
+
+// GCSStorage reads the model from Google Cloud Storage.
+type GCSStorage struct {
+	Bucket string `json:"bucket"`
+
+	// Object name of the model in the bucket.
+	Object string `json:"object"`
+
+	// ServiceAccountKeySecretRef selects a service account JSON key.
+	// Requests are anonymous without it.
+	// +optional
+	ServiceAccountKeySecretRef *corev1.SecretKeySelector `json:"serviceAccountKeySecretRef,omitempty"`
+}
+
+// AzureBlobStorage reads the model from Azure Blob Storage.
+type AzureBlobStorage struct {
+	// AccountName of the storage account.
+	AccountName string `json:"accountName"`
+
+	Container string `json:"container"`
+
+	// Blob name of the model in the container.
+	Blob string `json:"blob"`
+
+	// Endpoint overrides https://<accountName>.blob.core.windows.net,
+	// for sovereign clouds and emulators.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// SASTokenSecretRef selects a shared access signature. Requests are
+	// anonymous without it.
+	// +optional
+	SASTokenSecretRef *corev1.SecretKeySelector `json:"sasTokenSecretRef,omitempty"`
+}
+
+// HTTPStorage downloads the model from a URL.
+type HTTPStorage struct {
+	// URL of the model, http or https.
+	URL string `json:"url"`
+
+	// AuthorizationSecretRef selects the value of the Authorization
+	// header sent with the request.
+	// +optional
+	AuthorizationSecretRef *corev1.SecretKeySelector `json:"authorizationSecretRef,omitempty"`
+}
+
+// PVCStorage reads the model from an existing volume claim in the model's
+// namespace.
+type PVCStorage struct {
+	ClaimName string `json:"claimName"`
+
+	// Path of the model relative to the root of the volume.
+	Path string `json:"path"`
+}
+
+// OCIStorage pulls the model from an OCI registry, as the single layer of
+// an artifact.
+type OCIStorage struct {
+	// Reference of the artifact, e.g. registry.example.com/models/iris:1.0
+	// or registry.example.com/models/iris@sha256:<digest>.
+	Reference string `json:"reference"`
+
+	// PullSecretRef names a kubernetes.io/dockerconfigjson Secret with
+	// the registry credentials.
+	// +optional
+	PullSecretRef *corev1.LocalObjectReference `json:"pullSecretRef,omitempty"`
+
+	// PlainHTTP talks to the registry over http instead of https.
+	// +optional
+	PlainHTTP bool `json:"plainHTTP,omitempty"`
+}
+
+// VolumeRetentionPolicy decides the fate of the downloaded-model volumes.
+// +kubebuilder:validation:Enum=Retain;Delete
+type VolumeRetentionPolicy string
+
+const (
+	// RetainVolumes keeps the volumes after the Model is deleted.
+	RetainVolumes VolumeRetentionPolicy = "Retain"
+	// DeleteVolumes deletes the volumes together with the Model.
+	DeleteVolumes VolumeRetentionPolicy = "Delete"
+)
+
+// CleanupPolicy is run by the operator before a deleted Model goes away.
+type CleanupPolicy struct {
+	// Volumes is the retention policy of the downloaded-model volumes.
+	// +kubebuilder:default=Delete
+	// +optional
+	Volumes VolumeRetentionPolicy `json:"volumes,omitempty"`
+
+	// DeleteArtifact also deletes the model object from the bucket. An
+	// artifact that cannot be deleted, e.g. because its credentials are
+	// gone, is retained and reported in an Event.
+	// +optional
+	DeleteArtifact bool `json:"deleteArtifact,omitempty"`
+}
+
+// CredentialsSecretRef selects the object storage credentials in a Secret.
+type CredentialsSecretRef struct {
+	// Name of the Secret.
+	Name string `json:"name"`
+
+	// AccessKeyKey is the key of the Secret holding the access key.
+	// +kubebuilder:default=access_key
+	// +optional
+	AccessKeyKey string `json:"accessKeyKey,omitempty"`
+
+	// SecretKeyKey is the key of the Secret holding the secret key.
+	// +kubebuilder:default=secret_key
+	// +optional
+	SecretKeyKey string `json:"secretKeyKey,omitempty"`
+}
+
+// Condition types reported in ModelStatus.
+const (
+	// ConditionReady is True once every desired replica serves the current spec.
+	ConditionReady = "Ready"
+	// ConditionArtifactAvailable reports whether the serving pods could load the model artifact.
+	ConditionArtifactAvailable = "ArtifactAvailable"
+	// ConditionProgressing is True while replicas are being created, updated or scaled.
+	ConditionProgressing = "Progressing"
+	// ConditionDegraded is True when pods are failing or the owned resources could not be reconciled.
+	ConditionDegraded = "Degraded"
+	// ConditionRolledBack is True while an earlier revision is served in place of the spec.
+	ConditionRolledBack = "RolledBack"
+	// ConditionArtifactVerified reports whether the downloaded artifact matches the expected checksum.
+	ConditionArtifactVerified = "ArtifactVerified"
+	// ConditionIdle is True while the model is scaled to zero for lack of requests.
+	ConditionIdle = "Idle"
+	// ConditionOverridesApplied reports whether spec.podTemplateOverrides merged onto the pod template.
+	ConditionOverridesApplied = "OverridesApplied"
+)
+
+// RollbackAnnotation asks for a rollback to the revision number it holds.
+// The operator removes it once the rollback is applied.
+const RollbackAnnotation = "ml.kalkyai.com/rollback-to"
+
+// LastRequestAnnotation holds the RFC 3339 time the activator last saw a
+// request for the model. Idle models are woken up by moving it forward.
+const LastRequestAnnotation = "ml.kalkyai.com/last-request"
+
+// RequestCountAnnotation holds the requests the ready serving pods had
+// served when the operator last read their metrics. The operator moves
+// LastRequestAnnotation forward when the count changes.
+const RequestCountAnnotation = "ml.kalkyai.com/request-count"
+
+// ModelRevision identifies what a set of serving pods runs.
+type ModelRevision struct {
+	// Version of the serving image.
+	Version string `json:"version"`
+	// Location of the model artifact.
+	Location string `json:"location"`
+}
+
+// RevisionRecord is a revision that served all replicas of the model.
+type RevisionRecord struct {
+	// Revision numbers the records, starting at 1.
+	Revision int64 `json:"revision"`
+
+	ModelRevision `json:",inline"`
+
+	// Digest of the artifact, when known.
+	// +optional
+	Digest string `json:"digest,omitempty"`
+
+	// ServedAt is when the revision was first served by all replicas.
+	ServedAt metav1.Time `json:"servedAt"`
+}
+
+// RevisionProgress is a revision being rolled out to all replicas.
+type RevisionProgress struct {
+	ModelRevision `json:",inline"`
+
+	// StartedAt is when the revision started rolling out.
+	StartedAt metav1.Time `json:"startedAt"`
+}
+
+// RollbackStatus describes a revision served in place of the spec.
+type RollbackStatus struct {
+	// From is the revision of the spec that was rolled back. The rollback
+	// ends when the spec asks for another revision.
+	From ModelRevision `json:"from"`
+
+	// To is the revision served instead.
+	To RevisionRecord `json:"to"`
+
+	// Reason is Automatic or Manual.
+	Reason string `json:"reason"`
+
+	// Message explains the rollback.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// RolloutPhase is the state of a canary rollout.
+type RolloutPhase string
+
+const (
+	// RolloutProgressing means the canary is going through its steps.
+	RolloutProgressing RolloutPhase = "Progressing"
+	// RolloutPromoting means the stable pods are being moved to the canary revision.
+	RolloutPromoting RolloutPhase = "Promoting"
+	// RolloutSucceeded means the last rollout was promoted.
+	RolloutSucceeded RolloutPhase = "Succeeded"
+	// RolloutAborted means the canary failed its analysis and was removed.
+	RolloutAborted RolloutPhase = "Aborted"
+)
+
+// RolloutStatus is the progress of a canary rollout.
+type RolloutStatus struct {
+	// Phase of the rollout.
+	Phase RolloutPhase `json:"phase"`
+
+	// Stable is the revision served outside of a rollout.
+	Stable ModelRevision `json:"stable"`
+
+	// Canary is the revision being rolled out.
+	// +optional
+	Canary *ModelRevision `json:"canary,omitempty"`
+
+	// Step is the index of the current step.
+	// +optional
+	Step int32 `json:"step,omitempty"`
+
+	// Weight is the share of replicas, in percent, serving the canary,
+	// which is the share of traffic it gets. It is off the weight of the
+	// step when the replicas cannot be split at that weight.
+	// +optional
+	Weight int32 `json:"weight,omitempty"`
+
+	// StepStartedAt is when the current step started.
+	// +optional
+	StepStartedAt *metav1.Time `json:"stepStartedAt,omitempty"`
+
+	// Message explains the phase.
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 // ModelStatus defines the observed state of Model
 type ModelStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Conditions describe the latest observations of the model's state.
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// ObservedGeneration is the most recent generation reconciled by the operator.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// ReadyReplicas is the number of serving pods that are ready.
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+
+	// Replicas is the number of serving pods of the stable revision.
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// DesiredReplicas is the number of serving pods asked for by the spec
+	// or, when autoscaling, by the HorizontalPodAutoscaler.
+	// +optional
+	DesiredReplicas int32 `json:"desiredReplicas,omitempty"`
+
+	// Selector is the label selector of the serving pods, used by the scale
+	// subresource.
+	// +optional
+	Selector string `json:"selector,omitempty"`
+
+	// URL is the in-cluster address of the model service.
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// ExternalURL is the address of the model outside the cluster, set
+	// while spec.exposure is.
+	// +optional
+	ExternalURL string `json:"externalURL,omitempty"`
+
+	// Version is the model version served by all replicas.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Runtime is the serving runtime in use, as ServingRuntime/<name> or
+	// ClusterServingRuntime/<name>. Empty for built-in runtimes.
+	// +optional
+	Runtime string `json:"runtime,omitempty"`
+
+	// ArtifactDigest is the digest of the artifact the serving pods
+	// downloaded for the desired revision, e.g. sha256:<hex>.
+	// +optional
+	ArtifactDigest string `json:"artifactDigest,omitempty"`
+
+	// Rollout is the progress of the canary rollout, for models with a
+	// rollout strategy.
+	// +optional
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+
+	// RollingOut is the revision being rolled out to all replicas outside of
+	// a canary rollout, until it serves them. Its progress deadline counts
+	// from StartedAt.
+	// +optional
+	RollingOut *RevisionProgress `json:"rollingOut,omitempty"`
+
+	// History lists the revisions that served all replicas, oldest first.
+	// +optional
+	History []RevisionRecord `json:"history,omitempty"`
+
+	// RolledBack is set while an earlier revision is served in place of
+	// the one in the spec.
+	// +optional
+	RolledBack *RollbackStatus `json:"rolledBack,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.status.readyReplicas`
+//+kubebuilder:printcolumn:name="Desired",type=integer,JSONPath=`.status.desiredReplicas`,priority=1
+//+kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.version`
+//+kubebuilder:printcolumn:name="Runtime",type=string,JSONPath=`.status.runtime`,priority=1
+//+kubebuilder:printcolumn:name="Digest",type=string,JSONPath=`.status.artifactDigest`,priority=1
+//+kubebuilder:printcolumn:name="Canary",type=integer,JSONPath=`.status.rollout.weight`,priority=1
+//+kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.status.url`,priority=1
+//+kubebuilder:printcolumn:name="External",type=string,JSONPath=`.status.externalURL`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Model is the Schema for the models API
 type Model struct {
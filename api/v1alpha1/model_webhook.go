@@ -0,0 +1,584 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/kalkyai/model-serving-operator/pkg/config"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+	"github.com/kalkyai/model-serving-operator/pkg/runtimes"
+)
+
+// log is for logging in this package.
+var modellog = logf.Log.WithName("model-resource")
+
+// MinIdleTimeout is the shortest idleTimeout accepted. The activator records
+// requests on a model at most every 30 seconds, shorter timeouts would scale
+// busy models down.
+const MinIdleTimeout = time.Minute
+
+// imageTagPattern is the grammar of a container image tag.
+var imageTagPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// ociReferencePattern loosely matches registry/repository followed by a tag
+// or a digest.
+var ociReferencePattern = regexp.MustCompile(`^[a-zA-Z0-9.-]+(:[0-9]+)?(/[a-z0-9]+([._-][a-z0-9]+)*)+(:[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}|@sha256:[a-f0-9]{64})$`)
+
+// metricNamePattern is the grammar of a Prometheus metric name.
+var metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks.
+// Unset fields are filled from defaults, the operator-wide configuration.
+func (r *Model) SetupWebhookWithManager(mgr ctrl.Manager, defaults config.Defaults) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithDefaulter(&modelDefaulter{defaults: defaults}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-ml-kalkyai-com-v1alpha1-model,mutating=true,failurePolicy=fail,sideEffects=None,groups=ml.kalkyai.com,resources=models,verbs=create;update,versions=v1alpha1,name=mmodel.kb.io,admissionReviewVersions=v1
+
+// modelDefaulter fills unset Model fields from the operator configuration.
+type modelDefaulter struct {
+	defaults config.Defaults
+}
+
+var _ admission.CustomDefaulter = &modelDefaulter{}
+
+// Default implements admission.CustomDefaulter so a webhook will be registered for the type
+func (d *modelDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	r, ok := obj.(*Model)
+	if !ok {
+		return fmt.Errorf("expected a Model but got a %T", obj)
+	}
+	modellog.Info("default", "name", r.Name)
+
+	if r.Spec.Framework == "" {
+		r.Spec.Framework = runtimes.SKLearn
+	}
+	// The image and port are left unset: they depend on the framework and
+	// its runtime, and the operator fills them in when it renders the
+	// model, so they follow a later change of framework.
+
+	// The volume claim template of the StatefulSet is immutable, so storage
+	// defaults are only filled in when the Model is created. The UID is
+	// assigned by the API server after admission.
+	if r.UID == "" {
+		if r.Spec.StorageSize == nil && d.defaults.StorageSize != nil {
+			size := d.defaults.StorageSize.DeepCopy()
+			r.Spec.StorageSize = &size
+		}
+		if r.Spec.StorageClassName == nil && d.defaults.StorageClassName != "" {
+			storageClassName := d.defaults.StorageClassName
+			r.Spec.StorageClassName = &storageClassName
+		}
+	}
+
+	return nil
+}
+
+//+kubebuilder:webhook:path=/validate-ml-kalkyai-com-v1alpha1-model,mutating=false,failurePolicy=fail,sideEffects=None,groups=ml.kalkyai.com,resources=models,verbs=create;update,versions=v1alpha1,name=vmodel.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Model{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Model) ValidateCreate() error {
+	modellog.Info("validate create", "name", r.Name)
+
+	return r.toError(r.validateSpec())
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Model) ValidateUpdate(old runtime.Object) error {
+	modellog.Info("validate update", "name", r.Name)
+
+	allErrs := r.validateSpec()
+	if oldModel, ok := old.(*Model); ok {
+		allErrs = append(allErrs, r.validateImmutable(oldModel)...)
+	}
+	return r.toError(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Model) ValidateDelete() error {
+	return nil
+}
+
+func (r *Model) toError(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Model").GroupKind(), r.Name, allErrs)
+}
+
+func (r *Model) validateSpec() field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if r.Spec.Replicas < 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("replicas"), r.Spec.Replicas, "must be greater than or equal to 0"))
+	}
+
+	if r.Spec.Storage != nil {
+		allErrs = append(allErrs, validateStorage(r.Spec.Storage, specPath.Child("storage"))...)
+		for name, value := range map[string]string{
+			"location":   r.Spec.Location,
+			"bucket":     r.Spec.Bucket,
+			"endpoint":   r.Spec.Endpoint,
+			"access_key": r.Spec.Accesskey,
+			"secret_key": r.Spec.SecretKey,
+		} {
+			if value != "" {
+				allErrs = append(allErrs, field.Forbidden(specPath.Child(name), "may not be combined with storage"))
+			}
+		}
+		if r.Spec.CredentialsSecretRef != nil {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("credentialsSecretRef"), "may not be combined with storage"))
+		}
+	} else {
+		if r.Spec.Location == "" {
+			allErrs = append(allErrs, field.Required(specPath.Child("location"), "path of the model in the bucket is required"))
+		}
+
+		if r.Spec.Bucket == "" {
+			allErrs = append(allErrs, field.Required(specPath.Child("bucket"), ""))
+		}
+
+		if r.Spec.Endpoint == "" {
+			allErrs = append(allErrs, field.Required(specPath.Child("endpoint"), ""))
+		} else {
+			allErrs = append(allErrs, validateURL(r.Spec.Endpoint, specPath.Child("endpoint"))...)
+		}
+	}
+
+	if !imageTagPattern.MatchString(r.Spec.Version) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("version"), r.Spec.Version, "must be a valid image tag"))
+	}
+
+	if ref := r.Spec.CredentialsSecretRef; ref != nil {
+		refPath := specPath.Child("credentialsSecretRef")
+		allErrs = append(allErrs, validateSecretName(ref.Name, refPath.Child("name"))...)
+		if r.Spec.Accesskey != "" || r.Spec.SecretKey != "" {
+			allErrs = append(allErrs, field.Forbidden(refPath, "may not be combined with inline access_key and secret_key"))
+		}
+	}
+
+	if r.Spec.Port != nil && (*r.Spec.Port < 1 || *r.Spec.Port > 65535) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("port"), *r.Spec.Port, "must be between 1 and 65535"))
+	}
+
+	// Frameworks without a built-in runtime need a ServingRuntime, which
+	// the reconciler looks up.
+	if r.Spec.Framework == runtimes.Custom && r.Spec.Runtime == "" && r.Spec.ImageRepository == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("imageRepository"), "custom models are served by their own image or a runtime"))
+	}
+	if autoscaling := r.Spec.Autoscaling; autoscaling != nil {
+		allErrs = append(allErrs, validateAutoscaling(autoscaling, specPath.Child("autoscaling"))...)
+	}
+	if resources := r.Spec.Resources; resources != nil {
+		allErrs = append(allErrs, validateResources(resources, specPath.Child("resources"))...)
+	}
+	if probes := r.Spec.Probes; probes != nil {
+		allErrs = append(allErrs, validateProbes(probes, specPath.Child("probes"))...)
+	}
+	if exposure := r.Spec.Exposure; exposure != nil {
+		allErrs = append(allErrs, validateExposure(exposure, specPath.Child("exposure"))...)
+	}
+	if monitoring := r.Spec.Monitoring; monitoring != nil {
+		allErrs = append(allErrs, validateMonitoring(monitoring, specPath.Child("monitoring"))...)
+	}
+	if overrides := r.Spec.PodTemplateOverrides; overrides != nil && len(overrides.Raw) > 0 {
+		allErrs = append(allErrs, validatePodTemplateOverrides(r.Name, overrides.Raw, specPath.Child("podTemplateOverrides"))...)
+	}
+
+	if r.Spec.Runtime != "" {
+		for _, msg := range apivalidation.NameIsDNSSubdomain(r.Spec.Runtime, false) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("runtime"), r.Spec.Runtime, msg))
+		}
+	}
+
+	if r.Spec.StorageClassName != nil {
+		for _, msg := range apivalidation.NameIsDNSSubdomain(*r.Spec.StorageClassName, false) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("storageClassName"), *r.Spec.StorageClassName, msg))
+		}
+	}
+
+	if r.Spec.StorageSize != nil && r.Spec.StorageSize.Sign() <= 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("storageSize"), r.Spec.StorageSize.String(), "must be greater than 0"))
+	}
+
+	if r.Spec.Rollout != nil {
+		allErrs = append(allErrs, validateRollout(r.Spec.Rollout, specPath.Child("rollout"))...)
+	}
+
+	if r.Spec.ProgressDeadline != nil && r.Spec.ProgressDeadline.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("progressDeadline"), r.Spec.ProgressDeadline.Duration.String(), "must be greater than 0"))
+	}
+
+	if r.Spec.IdleTimeout != nil && r.Spec.IdleTimeout.Duration < MinIdleTimeout {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("idleTimeout"), r.Spec.IdleTimeout.Duration.String(), fmt.Sprintf("must be at least %s", MinIdleTimeout)))
+	}
+
+	if r.Spec.RevisionHistoryLimit != nil && *r.Spec.RevisionHistoryLimit < 1 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("revisionHistoryLimit"), *r.Spec.RevisionHistoryLimit, "must be greater than or equal to 1"))
+	}
+
+	if value, ok := r.Annotations[RollbackAnnotation]; ok {
+		if revision, err := strconv.ParseInt(value, 10, 64); err != nil || revision < 1 {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("metadata", "annotations").Key(RollbackAnnotation), value, "must be a revision number from status.history"))
+		}
+	}
+
+	return allErrs
+}
+
+// validatePodTemplateOverrides merges the overrides onto an empty template of
+// the serving pods, as the reconciler does onto the rendered one, so
+// overrides that cannot merge are rejected before they reach it.
+func validatePodTemplateOverrides(name string, overrides []byte, fldPath *field.Path) field.ErrorList {
+	selector := map[string]string{"serving": name}
+	template := &corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: selector}}
+	if err := model.MergePodTemplate(template, overrides, selector); err != nil {
+		return field.ErrorList{field.Invalid(fldPath, string(overrides), err.Error())}
+	}
+	return nil
+}
+
+func validateURL(value string, fldPath *field.Path) field.ErrorList {
+	if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return field.ErrorList{field.Invalid(fldPath, value, "must be an absolute http or https URL")}
+	}
+	return nil
+}
+
+func validateSecretName(name string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for _, msg := range apivalidation.NameIsDNSSubdomain(name, false) {
+		allErrs = append(allErrs, field.Invalid(fldPath, name, msg))
+	}
+	return allErrs
+}
+
+func validateSecretKeySelector(selector *corev1.SecretKeySelector, fldPath *field.Path) field.ErrorList {
+	if selector == nil {
+		return nil
+	}
+	allErrs := validateSecretName(selector.Name, fldPath.Child("name"))
+	if selector.Key == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("key"), ""))
+	}
+	return allErrs
+}
+
+func requireFields(fldPath *field.Path, fields map[string]string) field.ErrorList {
+	var allErrs field.ErrorList
+	for name, value := range fields {
+		if value == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child(name), ""))
+		}
+	}
+	return allErrs
+}
+
+// validateStorage checks that exactly one backend is set and that it is complete.
+func validateStorage(storage *ModelStorage, storagePath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	var backends []string
+	if s := storage.S3; s != nil {
+		backends = append(backends, "s3")
+		fldPath := storagePath.Child("s3")
+		allErrs = append(allErrs, requireFields(fldPath, map[string]string{"endpoint": s.Endpoint, "bucket": s.Bucket, "key": s.Key})...)
+		if s.Endpoint != "" {
+			allErrs = append(allErrs, validateURL(s.Endpoint, fldPath.Child("endpoint"))...)
+		}
+		if s.CredentialsSecretRef != nil {
+			allErrs = append(allErrs, validateSecretName(s.CredentialsSecretRef.Name, fldPath.Child("credentialsSecretRef", "name"))...)
+		}
+	}
+	if s := storage.GCS; s != nil {
+		backends = append(backends, "gcs")
+		fldPath := storagePath.Child("gcs")
+		allErrs = append(allErrs, requireFields(fldPath, map[string]string{"bucket": s.Bucket, "object": s.Object})...)
+		allErrs = append(allErrs, validateSecretKeySelector(s.ServiceAccountKeySecretRef, fldPath.Child("serviceAccountKeySecretRef"))...)
+	}
+	if s := storage.AzureBlob; s != nil {
+		backends = append(backends, "azureBlob")
+		fldPath := storagePath.Child("azureBlob")
+		allErrs = append(allErrs, requireFields(fldPath, map[string]string{"accountName": s.AccountName, "container": s.Container, "blob": s.Blob})...)
+		if s.Endpoint != "" {
+			allErrs = append(allErrs, validateURL(s.Endpoint, fldPath.Child("endpoint"))...)
+		}
+		allErrs = append(allErrs, validateSecretKeySelector(s.SASTokenSecretRef, fldPath.Child("sasTokenSecretRef"))...)
+	}
+	if s := storage.HTTP; s != nil {
+		backends = append(backends, "http")
+		fldPath := storagePath.Child("http")
+		if s.URL == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("url"), ""))
+		} else {
+			allErrs = append(allErrs, validateURL(s.URL, fldPath.Child("url"))...)
+		}
+		allErrs = append(allErrs, validateSecretKeySelector(s.AuthorizationSecretRef, fldPath.Child("authorizationSecretRef"))...)
+	}
+	if s := storage.PVC; s != nil {
+		backends = append(backends, "pvc")
+		fldPath := storagePath.Child("pvc")
+		allErrs = append(allErrs, validateSecretName(s.ClaimName, fldPath.Child("claimName"))...)
+		if s.Path == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("path"), ""))
+		} else if path.IsAbs(s.Path) || strings.HasPrefix(path.Clean(s.Path), "..") {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("path"), s.Path, "must be a relative path inside the volume"))
+		}
+	}
+	if s := storage.OCI; s != nil {
+		backends = append(backends, "oci")
+		fldPath := storagePath.Child("oci")
+		if !ociReferencePattern.MatchString(s.Reference) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("reference"), s.Reference, "must be a reference like registry/repository:tag or registry/repository@sha256:digest"))
+		}
+		if s.PullSecretRef != nil {
+			allErrs = append(allErrs, validateSecretName(s.PullSecretRef.Name, fldPath.Child("pullSecretRef", "name"))...)
+		}
+	}
+
+	switch len(backends) {
+	case 0:
+		allErrs = append(allErrs, field.Required(storagePath, "one of s3, gcs, azureBlob, http, pvc or oci is required"))
+	case 1:
+	default:
+		allErrs = append(allErrs, field.Forbidden(storagePath, fmt.Sprintf("only one backend may be set, got %s", strings.Join(backends, ", "))))
+	}
+
+	return allErrs
+}
+
+func validateRollout(rollout *RolloutStrategy, rolloutPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	stepsPath := rolloutPath.Child("steps")
+	if len(rollout.Steps) == 0 {
+		allErrs = append(allErrs, field.Required(stepsPath, "at least one step is required"))
+	}
+	var previous int32
+	for i, step := range rollout.Steps {
+		if step.Weight < 1 || step.Weight > 100 {
+			allErrs = append(allErrs, field.Invalid(stepsPath.Index(i).Child("weight"), step.Weight, "must be between 1 and 100"))
+		} else if step.Weight <= previous {
+			allErrs = append(allErrs, field.Invalid(stepsPath.Index(i).Child("weight"), step.Weight, "must be greater than the weight of the previous step"))
+		}
+		if step.Pause != nil && step.Pause.Duration < 0 {
+			allErrs = append(allErrs, field.Invalid(stepsPath.Index(i).Child("pause"), step.Pause.Duration.String(), "must not be negative"))
+		}
+		previous = step.Weight
+	}
+
+	if analysis := rollout.Analysis; analysis != nil {
+		if analysis.ReadyTimeout != nil && analysis.ReadyTimeout.Duration <= 0 {
+			allErrs = append(allErrs, field.Invalid(rolloutPath.Child("analysis", "readyTimeout"), analysis.ReadyTimeout.Duration.String(), "must be greater than 0"))
+		}
+		if analysis.MaxRestarts < 0 {
+			allErrs = append(allErrs, field.Invalid(rolloutPath.Child("analysis", "maxRestarts"), analysis.MaxRestarts, "must be greater than or equal to 0"))
+		}
+		if rate := analysis.MaxErrorRate; rate != nil && (rate.Sign() < 0 || rate.Cmp(resource.MustParse("100")) > 0) {
+			allErrs = append(allErrs, field.Invalid(rolloutPath.Child("analysis", "maxErrorRate"), rate.String(), "must be a percentage"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateImmutable rejects changes the owned StatefulSet cannot follow.
+func (r *Model) validateImmutable(old *Model) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	allErrs = append(allErrs, apivalidation.ValidateImmutableField(r.Spec.StorageSize, old.Spec.StorageSize, specPath.Child("storageSize"))...)
+	allErrs = append(allErrs, apivalidation.ValidateImmutableField(r.Spec.StorageClassName, old.Spec.StorageClassName, specPath.Child("storageClassName"))...)
+	return allErrs
+}
+
+func validateResources(resources *corev1.ResourceRequirements, resourcesPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	for name, request := range resources.Requests {
+		if limit, ok := resources.Limits[name]; ok && request.Cmp(limit) > 0 {
+			allErrs = append(allErrs, field.Invalid(resourcesPath.Child("requests").Key(string(name)), request.String(),
+				fmt.Sprintf("must be less than or equal to the %s limit", name)))
+		}
+	}
+	return allErrs
+}
+
+func validateExposure(exposure *Exposure, exposurePath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if exposure.Host == "" {
+		allErrs = append(allErrs, field.Required(exposurePath.Child("host"), ""))
+	} else {
+		host := strings.TrimPrefix(exposure.Host, "*.")
+		for _, msg := range apivalidation.NameIsDNSSubdomain(host, false) {
+			allErrs = append(allErrs, field.Invalid(exposurePath.Child("host"), exposure.Host, msg))
+		}
+	}
+	if exposure.Path != "" && !strings.HasPrefix(exposure.Path, "/") {
+		allErrs = append(allErrs, field.Invalid(exposurePath.Child("path"), exposure.Path, "must start with /"))
+	}
+	if exposure.TLSSecretName != "" {
+		allErrs = append(allErrs, validateSecretName(exposure.TLSSecretName, exposurePath.Child("tlsSecretName"))...)
+	}
+
+	switch exposure.Kind {
+	case ExposureHTTPRoute:
+		if exposure.Gateway == nil || exposure.Gateway.Name == "" {
+			allErrs = append(allErrs, field.Required(exposurePath.Child("gateway"), "an HTTPRoute attaches to a Gateway"))
+		}
+		if exposure.ClassName != "" {
+			allErrs = append(allErrs, field.Forbidden(exposurePath.Child("className"), "only applies to an Ingress"))
+		}
+	case ExposureIngress, "":
+		if exposure.Gateway != nil {
+			allErrs = append(allErrs, field.Forbidden(exposurePath.Child("gateway"), "only applies to an HTTPRoute"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(exposurePath.Child("kind"), exposure.Kind,
+			[]string{string(ExposureIngress), string(ExposureHTTPRoute)}))
+	}
+	return allErrs
+}
+
+func validateMonitoring(monitoring *Monitoring, monitoringPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if monitoring.Port != nil && (*monitoring.Port < 1 || *monitoring.Port > 65535) {
+		allErrs = append(allErrs, field.Invalid(monitoringPath.Child("port"), *monitoring.Port, "must be between 1 and 65535"))
+	}
+	if monitoring.Path != "" && !strings.HasPrefix(monitoring.Path, "/") {
+		allErrs = append(allErrs, field.Invalid(monitoringPath.Child("path"), monitoring.Path, "must start with /"))
+	}
+	if monitoring.Interval != nil && monitoring.Interval.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(monitoringPath.Child("interval"), monitoring.Interval.Duration.String(), "must be greater than 0"))
+	}
+	allErrs = append(allErrs, metav1validation.ValidateLabels(monitoring.Labels, monitoringPath.Child("labels"))...)
+
+	alerts := monitoring.Alerts
+	if alerts == nil {
+		return allErrs
+	}
+	alertsPath := monitoringPath.Child("alerts")
+	if alerts.For != nil && alerts.For.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(alertsPath.Child("for"), alerts.For.Duration.String(), "must not be negative"))
+	}
+	if rate := alerts.MaxErrorRate; rate != nil && (rate.Sign() <= 0 || rate.Cmp(resource.MustParse("100")) > 0) {
+		allErrs = append(allErrs, field.Invalid(alertsPath.Child("maxErrorRate"), rate.String(), "must be a percentage greater than 0"))
+	}
+	if alerts.MaxLatency != nil && alerts.MaxLatency.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(alertsPath.Child("maxLatency"), alerts.MaxLatency.Duration.String(), "must be greater than 0"))
+	}
+	for name, metric := range map[string]string{
+		"requestsMetric": alerts.RequestsMetric,
+		"latencyMetric":  alerts.LatencyMetric,
+	} {
+		if metric != "" && !metricNamePattern.MatchString(metric) {
+			allErrs = append(allErrs, field.Invalid(alertsPath.Child(name), metric, "must be a Prometheus metric name"))
+		}
+	}
+	return allErrs
+}
+
+func validateProbes(probes *ModelProbes, probesPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	for name, probe := range map[string]*ModelProbe{
+		"readiness": probes.Readiness,
+		"liveness":  probes.Liveness,
+		"startup":   probes.Startup,
+	} {
+		if probe == nil {
+			continue
+		}
+		probePath := probesPath.Child(name)
+		if probe.HTTPPath != "" && probe.TCP {
+			allErrs = append(allErrs, field.Forbidden(probePath.Child("tcp"), "may not be combined with httpPath"))
+		}
+		if probe.HTTPPath != "" && !strings.HasPrefix(probe.HTTPPath, "/") {
+			allErrs = append(allErrs, field.Invalid(probePath.Child("httpPath"), probe.HTTPPath, "must start with /"))
+		}
+	}
+	return allErrs
+}
+
+func validateAutoscaling(autoscaling *Autoscaling, autoscalingPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	minReplicas := int32(1)
+	if autoscaling.MinReplicas != nil {
+		minReplicas = *autoscaling.MinReplicas
+		if minReplicas < 1 {
+			allErrs = append(allErrs, field.Invalid(autoscalingPath.Child("minReplicas"), minReplicas, "must be at least 1"))
+		}
+	}
+	if autoscaling.MaxReplicas < minReplicas {
+		allErrs = append(allErrs, field.Invalid(autoscalingPath.Child("maxReplicas"), autoscaling.MaxReplicas, "must be greater than or equal to minReplicas"))
+	}
+
+	for name, target := range map[string]*int32{
+		"targetCPUUtilization":    autoscaling.TargetCPUUtilization,
+		"targetMemoryUtilization": autoscaling.TargetMemoryUtilization,
+	} {
+		if target != nil && *target < 1 {
+			allErrs = append(allErrs, field.Invalid(autoscalingPath.Child(name), *target, "must be at least 1"))
+		}
+	}
+
+	seen := map[string]bool{}
+	for i, metric := range autoscaling.Metrics {
+		metricPath := autoscalingPath.Child("metrics").Index(i)
+		if metric.Name == "" {
+			allErrs = append(allErrs, field.Required(metricPath.Child("name"), ""))
+		} else if seen[metric.Name] {
+			allErrs = append(allErrs, field.Duplicate(metricPath.Child("name"), metric.Name))
+		}
+		seen[metric.Name] = true
+		if metric.TargetAverageValue.Sign() <= 0 {
+			allErrs = append(allErrs, field.Invalid(metricPath.Child("targetAverageValue"), metric.TargetAverageValue.String(), "must be greater than 0"))
+		}
+	}
+
+	return allErrs
+}
@@ -0,0 +1,304 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/kalkyai/model-serving-operator/pkg/config"
+	"github.com/kalkyai/model-serving-operator/pkg/runtimes"
+)
+
+func TestAPIs(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Webhook Suite")
+}
+
+// causes returns the field paths of the errors in an Invalid status error.
+func causes(err error) []string {
+	statusErr, ok := err.(*apierrors.StatusError)
+	Expect(ok).To(BeTrue())
+	fields := []string{}
+	for _, cause := range statusErr.ErrStatus.Details.Causes {
+		fields = append(fields, cause.Field)
+	}
+	return fields
+}
+
+var _ = Describe("Model Webhook", func() {
+
+	var modelObject *Model
+
+	BeforeEach(func() {
+		modelObject = &Model{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test",
+				Namespace: "test",
+			},
+			Spec: ModelSpec{
+				Location: "iris.sav",
+				Replicas: 1,
+				Endpoint: "https://sgp1.digitaloceanspaces.com",
+				Columns:  "sepal.length,sepal.width,petal.length,petal.width",
+				Version:  "0.6",
+				Bucket:   "test",
+			},
+		}
+	})
+
+	Context("Defaulting a model", func() {
+
+		defaults := config.Default().Defaults
+		defaults.StorageClassName = "standard"
+		defaulter := &modelDefaulter{defaults: defaults}
+
+		It("should fill unset fields from the operator configuration", func() {
+			Expect(defaulter.Default(context.Background(), modelObject)).To(Succeed())
+
+			Expect(modelObject.Spec.Framework).To(Equal(runtimes.SKLearn))
+			Expect(modelObject.Spec.StorageSize.String()).To(Equal("5Gi"))
+			Expect(*modelObject.Spec.StorageClassName).To(Equal("standard"))
+		})
+
+		It("should keep fields set on the model", func() {
+			port := int32(8080)
+			modelObject.Spec.Port = &port
+			modelObject.Spec.ImageRepository = "registry.example.com/serving"
+
+			Expect(defaulter.Default(context.Background(), modelObject)).To(Succeed())
+
+			Expect(modelObject.Spec.ImageRepository).To(Equal("registry.example.com/serving"))
+			Expect(*modelObject.Spec.Port).To(Equal(int32(8080)))
+		})
+
+		It("should leave the image and port to the runtime of the framework", func() {
+			Expect(defaulter.Default(context.Background(), modelObject)).To(Succeed())
+
+			Expect(modelObject.Spec.ImageRepository).To(BeEmpty())
+			Expect(modelObject.Spec.Port).To(BeNil())
+		})
+
+		It("should not default storage on existing models", func() {
+			modelObject.UID = "8a6f4c5e-2a8e-4b61-9d0e-6f3c2b1a0e9d"
+
+			Expect(defaulter.Default(context.Background(), modelObject)).To(Succeed())
+
+			Expect(modelObject.Spec.StorageSize).To(BeNil())
+			Expect(modelObject.Spec.StorageClassName).To(BeNil())
+		})
+	})
+
+	Context("Validating a new model", func() {
+
+		It("should accept a valid spec", func() {
+			Expect(modelObject.ValidateCreate()).To(Succeed())
+		})
+
+		It("should require an image for custom models", func() {
+			modelObject.Spec.Framework = runtimes.Custom
+			Expect(modelObject.ValidateCreate()).NotTo(Succeed())
+
+			modelObject.Spec.ImageRepository = "registry.example.com/serving"
+			Expect(modelObject.ValidateCreate()).To(Succeed())
+		})
+
+		It("should reject autoscaling below its minimum", func() {
+			minReplicas := int32(3)
+			modelObject.Spec.Autoscaling = &Autoscaling{MinReplicas: &minReplicas, MaxReplicas: 2}
+			Expect(modelObject.ValidateCreate()).NotTo(Succeed())
+
+			modelObject.Spec.Autoscaling.MaxReplicas = 5
+			modelObject.Spec.Autoscaling.Metrics = []PodMetricTarget{{Name: "requests_per_second", TargetAverageValue: resource.MustParse("10")}}
+			Expect(modelObject.ValidateCreate()).To(Succeed())
+		})
+
+		It("should reject resource requests above their limits", func() {
+			modelObject.Spec.Resources = &corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("8Gi")},
+				Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("4Gi")},
+			}
+			Expect(causes(modelObject.ValidateCreate())).To(ConsistOf("spec.resources.requests[memory]"))
+
+			modelObject.Spec.Resources.Limits[corev1.ResourceMemory] = resource.MustParse("8Gi")
+			Expect(modelObject.ValidateCreate()).To(Succeed())
+		})
+
+		It("should require a gateway for an HTTPRoute", func() {
+			modelObject.Spec.Exposure = &Exposure{Kind: ExposureHTTPRoute, Host: "iris.example.com", ClassName: "nginx"}
+			Expect(causes(modelObject.ValidateCreate())).To(ConsistOf("spec.exposure.gateway", "spec.exposure.className"))
+
+			modelObject.Spec.Exposure.ClassName = ""
+			modelObject.Spec.Exposure.Gateway = &GatewayReference{Name: "public"}
+			Expect(modelObject.ValidateCreate()).To(Succeed())
+		})
+
+		It("should reject an invalid exposure host", func() {
+			modelObject.Spec.Exposure = &Exposure{Host: "Iris_Model"}
+			Expect(causes(modelObject.ValidateCreate())).To(ConsistOf("spec.exposure.host"))
+		})
+
+		It("should reject alert thresholds out of range", func() {
+			rate := resource.MustParse("150")
+			modelObject.Spec.Monitoring = &Monitoring{Alerts: &ModelAlerts{MaxErrorRate: &rate, LatencyMetric: "request-latency"}}
+			Expect(causes(modelObject.ValidateCreate())).To(ConsistOf("spec.monitoring.alerts.maxErrorRate", "spec.monitoring.alerts.latencyMetric"))
+
+			rate = resource.MustParse("0.5")
+			modelObject.Spec.Monitoring.Alerts.LatencyMetric = "request_latency_seconds"
+			Expect(modelObject.ValidateCreate()).To(Succeed())
+		})
+
+		It("should reject a probe that is both HTTP and TCP", func() {
+			modelObject.Spec.Probes = &ModelProbes{Readiness: &ModelProbe{HTTPPath: "/ready", TCP: true}}
+			Expect(causes(modelObject.ValidateCreate())).To(ConsistOf("spec.probes.readiness.tcp"))
+
+			modelObject.Spec.Probes.Readiness.TCP = false
+			Expect(modelObject.ValidateCreate()).To(Succeed())
+		})
+
+		It("should reject pod template overrides that do not merge", func() {
+			modelObject.Spec.PodTemplateOverrides = &runtime.RawExtension{Raw: []byte(`{"spec": {"containers": {"name": "serving"}}}`)}
+			Expect(causes(modelObject.ValidateCreate())).To(ConsistOf("spec.podTemplateOverrides"))
+
+			modelObject.Spec.PodTemplateOverrides.Raw = []byte(`{"metadata": {"labels": {"serving": "other"}}}`)
+			Expect(causes(modelObject.ValidateCreate())).To(ConsistOf("spec.podTemplateOverrides"))
+
+			modelObject.Spec.PodTemplateOverrides.Raw = []byte(`{"spec": {"containers": [{"name": "proxy", "image": "envoyproxy/envoy:v1.22"}]}}`)
+			Expect(modelObject.ValidateCreate()).To(Succeed())
+		})
+
+		It("should reject an idle timeout shorter than a minute", func() {
+			modelObject.Spec.IdleTimeout = &metav1.Duration{Duration: 10 * time.Second}
+			Expect(causes(modelObject.ValidateCreate())).To(ConsistOf("spec.idleTimeout"))
+
+			modelObject.Spec.IdleTimeout.Duration = 15 * time.Minute
+			Expect(modelObject.ValidateCreate()).To(Succeed())
+		})
+
+		It("should accept formats served by a serving runtime", func() {
+			modelObject.Spec.Framework = "tensorrt"
+			modelObject.Spec.Runtime = "triton"
+			Expect(modelObject.ValidateCreate()).To(Succeed())
+
+			modelObject.Spec.Runtime = "Triton"
+			Expect(modelObject.ValidateCreate()).NotTo(Succeed())
+		})
+
+		It("should reject invalid fields with field level errors", func() {
+			modelObject.Spec.Replicas = -1
+			modelObject.Spec.Location = ""
+			modelObject.Spec.Endpoint = "sgp1.digitaloceanspaces.com"
+			modelObject.Spec.Version = "0.6:latest"
+
+			err := modelObject.ValidateCreate()
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(causes(err)).To(ConsistOf("spec.replicas", "spec.location", "spec.endpoint", "spec.version"))
+		})
+
+		It("should reject a credentials reference combined with inline keys", func() {
+			modelObject.Spec.Accesskey = "access"
+			modelObject.Spec.CredentialsSecretRef = &CredentialsSecretRef{Name: "credentials"}
+
+			err := modelObject.ValidateCreate()
+			Expect(causes(err)).To(ConsistOf("spec.credentialsSecretRef"))
+		})
+
+		It("should reject rollout steps that do not increase the weight", func() {
+			modelObject.Spec.Rollout = &RolloutStrategy{
+				Steps: []RolloutStep{{Weight: 20}, {Weight: 20}, {Weight: 101}},
+			}
+
+			err := modelObject.ValidateCreate()
+			Expect(causes(err)).To(ConsistOf("spec.rollout.steps[1].weight", "spec.rollout.steps[2].weight"))
+		})
+
+		It("should reject a rollout error rate that is not a percentage", func() {
+			rate := resource.MustParse("150")
+			modelObject.Spec.Rollout = &RolloutStrategy{
+				Steps:    []RolloutStep{{Weight: 20}},
+				Analysis: &RolloutAnalysis{MaxErrorRate: &rate},
+			}
+
+			err := modelObject.ValidateCreate()
+			Expect(causes(err)).To(ConsistOf("spec.rollout.analysis.maxErrorRate"))
+		})
+
+		It("should accept a storage backend in place of the bucket fields", func() {
+			modelObject.Spec.Location = ""
+			modelObject.Spec.Bucket = ""
+			modelObject.Spec.Endpoint = ""
+			modelObject.Spec.Storage = &ModelStorage{
+				OCI: &OCIStorage{Reference: "registry.example.com/models/iris:1.0"},
+			}
+
+			Expect(modelObject.ValidateCreate()).To(Succeed())
+		})
+
+		It("should reject storage combined with the bucket fields or several backends", func() {
+			modelObject.Spec.Storage = &ModelStorage{
+				HTTP: &HTTPStorage{URL: "ftp://models.example.com/iris.sav"},
+				PVC:  &PVCStorage{ClaimName: "models", Path: "../iris.sav"},
+			}
+
+			err := modelObject.ValidateCreate()
+			Expect(causes(err)).To(ConsistOf(
+				"spec.storage", "spec.storage.http.url", "spec.storage.pvc.path",
+				"spec.location", "spec.bucket", "spec.endpoint",
+			))
+		})
+
+		It("should reject a rollback annotation that is not a revision number", func() {
+			modelObject.Annotations = map[string]string{RollbackAnnotation: "0.6"}
+
+			err := modelObject.ValidateCreate()
+			Expect(causes(err)).To(ConsistOf("metadata.annotations[ml.kalkyai.com/rollback-to]"))
+		})
+	})
+
+	Context("Validating an update", func() {
+
+		It("should reject a change to the storage size", func() {
+			size := resource.MustParse("5Gi")
+			modelObject.Spec.StorageSize = &size
+
+			updated := modelObject.DeepCopy()
+			bigger := resource.MustParse("10Gi")
+			updated.Spec.StorageSize = &bigger
+
+			err := updated.ValidateUpdate(modelObject)
+			Expect(causes(err)).To(ConsistOf("spec.storageSize"))
+		})
+
+		It("should allow scaling and version changes", func() {
+			updated := modelObject.DeepCopy()
+			updated.Spec.Replicas = 3
+			updated.Spec.Version = "0.7"
+
+			Expect(updated.ValidateUpdate(modelObject)).To(Succeed())
+		})
+	})
+})
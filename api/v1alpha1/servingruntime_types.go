@@ -0,0 +1,113 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServingProtocol is the inference protocol a runtime speaks.
+// +kubebuilder:validation:Enum=v1;v2;custom
+type ServingProtocol string
+
+const (
+	// ProtocolV1 is the KServe V1 (TensorFlow Serving style) REST protocol.
+	ProtocolV1 ServingProtocol = "v1"
+	// ProtocolV2 is the KServe V2 open inference protocol.
+	ProtocolV2 ServingProtocol = "v2"
+	// ProtocolCustom is any other protocol.
+	ProtocolCustom ServingProtocol = "custom"
+)
+
+// SupportedModelFormat is a model format, matched against Model frameworks.
+type SupportedModelFormat struct {
+	// Name of the format, e.g. sklearn or onnx.
+	Name string `json:"name"`
+
+	// AutoSelect lets Models of this format that name no runtime use this one.
+	// +optional
+	AutoSelect bool `json:"autoSelect,omitempty"`
+
+	// Priority orders the runtimes auto selected for a format, highest first.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+}
+
+// ServingRuntimeSpec describes a serving stack.
+type ServingRuntimeSpec struct {
+	// SupportedModelFormats lists the formats the runtime can serve.
+	// +kubebuilder:validation:MinItems=1
+	SupportedModelFormats []SupportedModelFormat `json:"supportedModelFormats"`
+
+	// Protocol the runtime speaks.
+	// +kubebuilder:default=v1
+	// +optional
+	Protocol ServingProtocol `json:"protocol,omitempty"`
+
+	// Container is the template of the serving container. Its name is
+	// replaced, and the operator adds the model volume and the MODEL_NAME,
+	// MODEL_DIR, MODEL_PATH and PORT variables, which args may refer to as
+	// $(VAR). The first container port is the serving port.
+	Container corev1.Container `json:"container"`
+
+	// Resources are applied when the container template sets none.
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Disabled runtimes are neither selected nor used by Models naming them.
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// Supports reports whether the runtime serves format.
+func (s *ServingRuntimeSpec) Supports(format string) (SupportedModelFormat, bool) {
+	for _, supported := range s.SupportedModelFormats {
+		if supported.Name == format {
+			return supported, true
+		}
+	}
+	return SupportedModelFormat{}, false
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:shortName=sr
+//+kubebuilder:printcolumn:name="Protocol",type=string,JSONPath=`.spec.protocol`
+//+kubebuilder:printcolumn:name="Disabled",type=boolean,JSONPath=`.spec.disabled`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ServingRuntime is a serving stack Models in its namespace can use.
+type ServingRuntime struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ServingRuntimeSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ServingRuntimeList contains a list of ServingRuntime
+type ServingRuntimeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ServingRuntime `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ServingRuntime{}, &ServingRuntimeList{})
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The fetcher is the init container of serving pods. It downloads the model
+// artifact into the model volume, verifies its checksum and writes a
+// manifest, then reports the digest in its termination message.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	"github.com/kalkyai/model-serving-operator/pkg/fetcher"
+	"github.com/kalkyai/model-serving-operator/pkg/storage"
+)
+
+func main() {
+	var spec, digest, output, manifest, secretsDir, mountPath, terminationLog string
+	flag.StringVar(&spec, "storage", "", "The storage of the artifact, as the JSON of a Model spec.storage.")
+	flag.StringVar(&digest, "digest", "", "The expected digest of the artifact, sha256:<hex>. Empty skips the check.")
+	flag.StringVar(&output, "output", "", "The file the artifact is written to.")
+	flag.StringVar(&manifest, "manifest", "", "The file the manifest is written to.")
+	flag.StringVar(&secretsDir, "secrets-dir", "", "The directory the Secrets of the storage are mounted in, one directory per Secret.")
+	flag.StringVar(&mountPath, "mount-path", storage.DefaultMountPath, "Where the claim of a pvc storage is mounted.")
+	flag.StringVar(&terminationLog, "termination-log", "/dev/termination-log", "The file the report is written to.")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store := &mlv1alpha1.ModelStorage{}
+	if err := json.Unmarshal([]byte(spec), store); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid storage: %s\n", err)
+		os.Exit(2)
+	}
+	uri := storage.URI(store)
+
+	result, err := fetch(ctx, store, fetcher.Options{
+		URI:          uri,
+		Path:         output,
+		ManifestPath: manifest,
+		Digest:       digest,
+	}, secretsDir, mountPath)
+
+	report, _ := json.Marshal(fetcher.NewReport(uri, result, err))
+	if writeErr := os.WriteFile(terminationLog, report, 0o644); writeErr != nil {
+		fmt.Fprintf(os.Stderr, "writing termination log: %s\n", writeErr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fetching %s: %s\n", uri, err)
+		os.Exit(1)
+	}
+	fmt.Printf("fetched %s (%s, %d bytes) to %s\n", uri, result.Digest, result.Size, output)
+}
+
+func fetch(ctx context.Context, store *mlv1alpha1.ModelStorage, opts fetcher.Options, secretsDir string, mountPath string) (fetcher.Manifest, error) {
+	backend, err := storage.New(ctx, store, mountedSecrets(secretsDir), storage.Options{MountPath: mountPath})
+	if err != nil {
+		return fetcher.Manifest{}, err
+	}
+	return fetcher.Fetch(ctx, backend, opts)
+}
+
+// mountedSecrets reads Secrets mounted as volumes under dir.
+func mountedSecrets(dir string) storage.Secrets {
+	return func(ctx context.Context, name string) (map[string][]byte, error) {
+		root := filepath.Join(dir, name)
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s is not mounted: %w", name, err)
+		}
+		data := map[string][]byte{}
+		for _, entry := range entries {
+			// Secret volumes link every key to a hidden timestamped directory.
+			if entry.IsDir() || entry.Name()[0] == '.' {
+				continue
+			}
+			value, err := os.ReadFile(filepath.Join(root, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			data[entry.Name()] = value
+		}
+		return data, nil
+	}
+}
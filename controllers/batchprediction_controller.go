@@ -0,0 +1,431 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	"github.com/kalkyai/model-serving-operator/pkg/config"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+	"github.com/kalkyai/model-serving-operator/pkg/storage"
+)
+
+// Reasons of the Events and conditions of a BatchPrediction.
+const (
+	reasonModelNotFound = "ModelNotFound"
+	reasonInvalidSpec   = "InvalidSpec"
+	reasonJobNotStarted = "JobNotStarted"
+	reasonShardsRunning = "ShardsRunning"
+	reasonJobSucceeded  = "JobSucceeded"
+	reasonJobFailed     = "JobFailed"
+)
+
+// Defaults of a BatchPrediction spec.
+const (
+	defaultBatchShards       = int32(1)
+	defaultBatchBackoffLimit = int32(3)
+)
+
+// batchModelRefField indexes BatchPredictions by the Model they reference.
+const batchModelRefField = ".spec.modelRef.name"
+
+// BatchPredictionReconciler reconciles a BatchPrediction object
+type BatchPredictionReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// Defaults fill in the fields the model leaves unset, as for Models.
+	Defaults config.Defaults
+}
+
+// batchModel is the Model a prediction runs: the referenced one, or one
+// made up from the inline model. A missing referenced Model is nil.
+func (r *BatchPredictionReconciler) batchModel(ctx context.Context, batch *mlv1alpha1.BatchPrediction) (*mlv1alpha1.Model, error) {
+	if inline := batch.Spec.Model; inline != nil {
+		return &mlv1alpha1.Model{
+			ObjectMeta: metav1.ObjectMeta{Name: batch.Name, Namespace: batch.Namespace},
+			Spec: mlv1alpha1.ModelSpec{
+				Storage:         inline.Storage.DeepCopy(),
+				Framework:       inline.Framework,
+				Runtime:         inline.Runtime,
+				ImageRepository: inline.ImageRepository,
+				Version:         inline.Version,
+				Columns:         inline.Columns,
+			},
+		}, nil
+	}
+
+	model_serving := &mlv1alpha1.Model{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: batch.Namespace, Name: batch.Spec.ModelRef.Name}, model_serving)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	return model_serving, err
+}
+
+// newBatch maps a BatchPrediction onto the Job builder.
+func newBatch(batch *mlv1alpha1.BatchPrediction) (*model.Batch, error) {
+	input, err := json.Marshal(batch.Spec.Input)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &model.Batch{
+		Name:         batch.Name,
+		Input:        string(input),
+		InputPath:    path.Join(model.InputDir, artifactFile(storage.URI(&batch.Spec.Input))),
+		OutputClaim:  batch.Spec.Output.ClaimName,
+		OutputPath:   batch.Spec.Output.Path,
+		Shards:       defaultBatchShards,
+		Parallelism:  batch.Spec.Parallelism,
+		BackoffLimit: batch.Spec.BackoffLimit,
+		Command:      batch.Spec.Command,
+		Args:         batch.Spec.Args,
+	}
+	if batch.Spec.Shards != nil {
+		b.Shards = *batch.Spec.Shards
+	}
+	if b.BackoffLimit == nil {
+		backoffLimit := defaultBatchBackoffLimit
+		b.BackoffLimit = &backoffLimit
+	}
+	if deadline := batch.Spec.ActiveDeadline; deadline != nil {
+		seconds := int64(deadline.Seconds())
+		b.ActiveDeadline = &seconds
+	}
+	if batch.Spec.Input.PVC != nil {
+		b.InputClaim = batch.Spec.Input.PVC.ClaimName
+	}
+	for _, ref := range storageSecrets(&batch.Spec.Input) {
+		b.InputSecrets = append(b.InputSecrets, ref.name)
+	}
+	return b, nil
+}
+
+// render maps the prediction and its model onto the Job builder.
+func (r *BatchPredictionReconciler) render(ctx context.Context, batch *mlv1alpha1.BatchPrediction, model_serving *mlv1alpha1.Model) (*model.ModelServing, *model.Batch, error) {
+	runtime, err := resolveRuntime(ctx, r, model_serving)
+	if err != nil {
+		return nil, nil, err
+	}
+	mod, err := newModelServing(model_serving, r.Defaults, runtime)
+	if err != nil {
+		return nil, nil, err
+	}
+	if batch.Spec.Resources != nil {
+		mod.Resources = batch.Spec.Resources
+	}
+	if err := locateArtifact(model_serving, mod); err != nil {
+		return nil, nil, err
+	}
+
+	b, err := newBatch(batch)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mod, b, nil
+}
+
+// setBatchPhase records the phase of the prediction and the conditions it
+// implies.
+func setBatchPhase(batch *mlv1alpha1.BatchPrediction, phase mlv1alpha1.BatchPredictionPhase, reason string, message string) {
+	status := &batch.Status
+	status.Phase = phase
+	status.Message = message
+
+	complete, failed := metav1.ConditionFalse, metav1.ConditionFalse
+	switch phase {
+	case mlv1alpha1.BatchSucceeded:
+		complete = metav1.ConditionTrue
+	case mlv1alpha1.BatchFailed:
+		failed = metav1.ConditionTrue
+	}
+	for conditionType, conditionStatus := range map[string]metav1.ConditionStatus{
+		mlv1alpha1.ConditionComplete: complete,
+		mlv1alpha1.ConditionFailed:   failed,
+	} {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               conditionType,
+			Status:             conditionStatus,
+			ObservedGeneration: batch.Generation,
+			Reason:             reason,
+			Message:            message,
+		})
+	}
+	if status.Finished() && status.CompletionTime == nil {
+		now := metav1.Now()
+		status.CompletionTime = &now
+	}
+}
+
+// observeRun fills the counts of the status of the prediction in from a
+// Job and the reports of its shards that completed. It returns the number
+// of shards of the Job.
+func (r *BatchPredictionReconciler) observeRun(ctx context.Context, batch *mlv1alpha1.BatchPrediction, job *batchv1.Job) (int32, error) {
+	status := &batch.Status
+	status.JobName = job.Name
+	status.StartTime = job.Status.StartTime
+	status.SucceededShards = job.Status.Succeeded
+	status.ActiveShards = job.Status.Active
+	status.FailedPods = job.Status.Failed
+	shards := defaultBatchShards
+	if job.Spec.Completions != nil {
+		shards = *job.Spec.Completions
+	}
+	status.Progress = fmt.Sprintf("%d/%d", status.SucceededShards, shards)
+
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(batch.Namespace), client.MatchingLabels{
+		model.BatchLabel:   batch.Name,
+		model.JobNameLabel: job.Name,
+	}); err != nil {
+		return 0, err
+	}
+	// A shard counts once, however often it was retried.
+	reports := map[string]model.BatchReport{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		index, ok := model.CompletionIndex(pod)
+		if !ok || pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Name != model.PredictorContainer || cs.State.Terminated == nil {
+				continue
+			}
+			if report, ok := model.ParseBatchReport(cs.State.Terminated.Message); ok {
+				reports[index] = report
+			}
+		}
+	}
+	status.RowsProcessed, status.RowsFailed = 0, 0
+	for _, report := range reports {
+		status.RowsProcessed += report.Rows
+		status.RowsFailed += report.Failed
+	}
+	return shards, nil
+}
+
+// observeJob fills the status of a prediction that runs once in from its
+// Job, until the Job finishes.
+func (r *BatchPredictionReconciler) observeJob(ctx context.Context, batch *mlv1alpha1.BatchPrediction, job *batchv1.Job) error {
+	status := &batch.Status
+	shards, err := r.observeRun(ctx, batch, job)
+	if err != nil {
+		return err
+	}
+
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			setBatchPhase(batch, mlv1alpha1.BatchSucceeded, reasonJobSucceeded,
+				fmt.Sprintf("Predicted %d rows, %d failed", status.RowsProcessed, status.RowsFailed))
+			r.Recorder.Event(batch, corev1.EventTypeNormal, reasonJobSucceeded, status.Message)
+			return nil
+		case batchv1.JobFailed:
+			setBatchPhase(batch, mlv1alpha1.BatchFailed, reasonJobFailed, condition.Message)
+			r.Recorder.Event(batch, corev1.EventTypeWarning, reasonJobFailed, condition.Message)
+			return nil
+		}
+	}
+	setBatchPhase(batch, mlv1alpha1.BatchRunning, reasonShardsRunning,
+		fmt.Sprintf("%d of %d shards completed", status.SucceededShards, shards))
+	return nil
+}
+
+//+kubebuilder:rbac:groups=ml.kalkyai.com,resources=batchpredictions,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=ml.kalkyai.com,resources=batchpredictions/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=ml.kalkyai.com,resources=batchpredictions/finalizers,verbs=update
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile creates the Job of a BatchPrediction once its model can be
+// resolved, and reports the progress of the Job until it finishes. The Job
+// is built from the pods of the model, so a prediction runs what the model
+// serves. Finished predictions are left alone. Scheduled predictions get a
+// CronJob instead, kept in sync with the prediction and its model.
+func (r *BatchPredictionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ctrllog := log.FromContext(ctx).WithValues("batchpredictions", req.NamespacedName)
+	ctx = log.IntoContext(ctx, ctrllog)
+
+	batch := &mlv1alpha1.BatchPrediction{}
+	if err := r.Get(ctx, req.NamespacedName, batch); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !batch.Spec.Scheduled() && batch.Status.Finished() {
+		return ctrl.Result{}, nil
+	}
+	batch.Status.ObservedGeneration = batch.Generation
+
+	var result ctrl.Result
+	var err error
+	if batch.Spec.Scheduled() {
+		result, err = r.reconcileSchedule(ctx, batch)
+	} else {
+		err = r.reconcileJob(ctx, batch)
+	}
+
+	if statusErr := r.Status().Update(ctx, batch); statusErr != nil {
+		ctrllog.Error(statusErr, "Failed to update batch prediction status")
+		if err == nil {
+			return ctrl.Result{}, statusErr
+		}
+	}
+	return result, err
+}
+
+// reconcileJob starts the Job of a prediction that runs once, or observes
+// it once started.
+func (r *BatchPredictionReconciler) reconcileJob(ctx context.Context, batch *mlv1alpha1.BatchPrediction) error {
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: batch.Namespace, Name: model.BatchJobName(batch.Name)}, job)
+	switch {
+	case err == nil:
+		return r.observeJob(ctx, batch, job)
+	case apierrors.IsNotFound(err):
+		return r.startJob(ctx, batch)
+	default:
+		return err
+	}
+}
+
+// startJob creates the Job of the prediction, or records why it cannot.
+func (r *BatchPredictionReconciler) startJob(ctx context.Context, batch *mlv1alpha1.BatchPrediction) error {
+	if errs := batch.Validate(); len(errs) > 0 {
+		setBatchPhase(batch, mlv1alpha1.BatchFailed, reasonInvalidSpec, errs.ToAggregate().Error())
+		r.Recorder.Event(batch, corev1.EventTypeWarning, reasonInvalidSpec, batch.Status.Message)
+		return nil
+	}
+
+	model_serving, err := r.batchModel(ctx, batch)
+	if err != nil {
+		return err
+	}
+	if model_serving == nil {
+		// Retried when the Model is created.
+		setBatchPhase(batch, mlv1alpha1.BatchPending, reasonModelNotFound,
+			fmt.Sprintf("Model %s not found", batch.Spec.ModelRef.Name))
+		return nil
+	}
+
+	mod, b, err := r.render(ctx, batch, model_serving)
+	if err != nil {
+		setBatchPhase(batch, mlv1alpha1.BatchPending, reasonJobNotStarted, err.Error())
+		r.Recorder.Event(batch, corev1.EventTypeWarning, reasonJobNotStarted, err.Error())
+		return err
+	}
+	job := mod.CreateBatchJob(ctx, b)
+	if err := ctrl.SetControllerReference(batch, job, r.Scheme); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("Creating Job", "name", job.Name)
+	if err := r.Create(ctx, job); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(batch, corev1.EventTypeNormal, reasonCreated, "Created Job %s", job.Name)
+
+	batch.Status.JobName = job.Name
+	batch.Status.Progress = fmt.Sprintf("0/%d", *job.Spec.Completions)
+	setBatchPhase(batch, mlv1alpha1.BatchRunning, reasonShardsRunning, "Job created")
+	return nil
+}
+
+func indexBatchModelRef(obj client.Object) []string {
+	batch := obj.(*mlv1alpha1.BatchPrediction)
+	if batch.Spec.ModelRef == nil {
+		return nil
+	}
+	return []string{batch.Spec.ModelRef.Name}
+}
+
+// predictionsForModel maps a Model to the BatchPredictions waiting for it,
+// and the scheduled ones whose next runs use it.
+func (r *BatchPredictionReconciler) predictionsForModel(obj client.Object) []reconcile.Request {
+	ctx := context.Background()
+
+	batches := &mlv1alpha1.BatchPredictionList{}
+	if err := r.List(ctx, batches,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{batchModelRefField: obj.GetName()},
+	); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list batch predictions for model", "model", obj.GetName())
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, item := range batches.Items {
+		if !item.Spec.Scheduled() && (item.Status.JobName != "" || item.Status.Finished()) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: item.Namespace, Name: item.Name},
+		})
+	}
+	return requests
+}
+
+// predictionForJob maps a Job back to its BatchPrediction through the batch
+// label.
+func predictionForJob(obj client.Object) []reconcile.Request {
+	name, ok := obj.GetLabels()[model.BatchLabel]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: name},
+	}}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *BatchPredictionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &mlv1alpha1.BatchPrediction{}, batchModelRefField, indexBatchModelRef); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&mlv1alpha1.BatchPrediction{}).
+		Owns(&batchv1.CronJob{}).
+		// Jobs of scheduled predictions are owned by their CronJob.
+		Watches(&source.Kind{Type: &batchv1.Job{}},
+			handler.EnqueueRequestsFromMapFunc(predictionForJob)).
+		Watches(&source.Kind{Type: &mlv1alpha1.Model{}},
+			handler.EnqueueRequestsFromMapFunc(r.predictionsForModel)).
+		Complete(r)
+}
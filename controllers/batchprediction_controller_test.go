@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+)
+
+// newBatchReconciler returns a BatchPredictionReconciler whose client holds
+// objs.
+func newBatchReconciler(t *testing.T, objs ...client.Object) *BatchPredictionReconciler {
+	t.Helper()
+	scheme := newTestScheme(t)
+	return &BatchPredictionReconciler{
+		Client:   newTestClient(scheme, objs...),
+		Scheme:   scheme,
+		Recorder: record.NewFakeRecorder(100),
+	}
+}
+
+// newTestBatch returns a prediction of the test model over a CSV file, in
+// two shards.
+func newTestBatch() *mlv1alpha1.BatchPrediction {
+	shards := int32(2)
+	return &mlv1alpha1.BatchPrediction{
+		ObjectMeta: metav1.ObjectMeta{Name: "june", Namespace: "team", Generation: 1},
+		Spec: mlv1alpha1.BatchPredictionSpec{
+			ModelRef: &corev1.LocalObjectReference{Name: "iris"},
+			Input:    mlv1alpha1.ModelStorage{HTTP: &mlv1alpha1.HTTPStorage{URL: "https://example.com/iris/june.csv"}},
+			Output:   mlv1alpha1.BatchOutput{ClaimName: "predictions", Path: "iris/june"},
+			Shards:   &shards,
+		},
+	}
+}
+
+// reconcileBatch runs one reconcile of the prediction, failing the test on
+// error, and reads the prediction back.
+func reconcileBatch(t *testing.T, r *BatchPredictionReconciler, batch *mlv1alpha1.BatchPrediction) ctrl.Result {
+	t.Helper()
+	result, err := r.Reconcile(context.Background(), requestFor(batch))
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	getObject(t, r, batch)
+	return result
+}
+
+// expectBatchPhase checks the phase of the prediction and the reason of its
+// Complete condition.
+func expectBatchPhase(t *testing.T, batch *mlv1alpha1.BatchPrediction, phase mlv1alpha1.BatchPredictionPhase, reason string) {
+	t.Helper()
+	if batch.Status.Phase != phase {
+		t.Errorf("phase = %s (%s), want %s", batch.Status.Phase, batch.Status.Message, phase)
+	}
+	condition := meta.FindStatusCondition(batch.Status.Conditions, mlv1alpha1.ConditionComplete)
+	if condition == nil || condition.Reason != reason {
+		t.Errorf("complete condition = %+v, want reason %s", condition, reason)
+	}
+}
+
+// batchPod returns a pod of the Job of the prediction that predicted the
+// shard index in phase, terminating with message.
+func batchPod(name, index string, phase corev1.PodPhase, message string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "team",
+			Labels: map[string]string{
+				model.BatchLabel:   "june",
+				model.JobNameLabel: model.BatchJobName("june"),
+			},
+			Annotations: map[string]string{"batch.kubernetes.io/job-completion-index": index},
+		},
+		Status: corev1.PodStatus{
+			Phase: phase,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  model.PredictorContainer,
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: message}},
+			}},
+		},
+	}
+}
+
+func TestBatchPredictionCreatesJob(t *testing.T) {
+	inline := newTestBatch()
+	inline.Spec.ModelRef = nil
+	inline.Spec.Model = &mlv1alpha1.BatchModel{
+		Storage: mlv1alpha1.ModelStorage{PVC: &mlv1alpha1.PVCStorage{ClaimName: "models", Path: "iris.sav"}},
+		Version: "0.7",
+		Columns: "a,b",
+	}
+
+	tests := []struct {
+		name    string
+		batch   *mlv1alpha1.BatchPrediction
+		version string
+	}{
+		{name: "model reference", batch: newTestBatch(), version: "0.6"},
+		{name: "inline model", batch: inline, version: "0.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newBatchReconciler(t, newTestModel(), tt.batch)
+			reconcileBatch(t, r, tt.batch)
+
+			job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "bp-june", Namespace: "team"}}
+			getObject(t, r, job)
+			if !metav1.IsControlledBy(job, tt.batch) {
+				t.Error("job is not controlled by the prediction")
+			}
+			if *job.Spec.Completions != 2 {
+				t.Errorf("completions = %d, want a pod per shard", *job.Spec.Completions)
+			}
+			if got := job.Spec.Template.Annotations[model.VersionAnnotation]; got != tt.version {
+				t.Errorf("version = %s, want %s", got, tt.version)
+			}
+
+			expectBatchPhase(t, tt.batch, mlv1alpha1.BatchRunning, reasonShardsRunning)
+			if tt.batch.Status.JobName != job.Name || tt.batch.Status.Progress != "0/2" {
+				t.Errorf("job = %s, progress = %s", tt.batch.Status.JobName, tt.batch.Status.Progress)
+			}
+		})
+	}
+}
+
+func TestBatchPredictionPhases(t *testing.T) {
+	batch := newTestBatch()
+	r := newBatchReconciler(t, newTestModel(), batch,
+		batchPod("june-0", "0", corev1.PodSucceeded, `{"rows":100,"failed":2}`),
+		// A retried shard counts once.
+		batchPod("june-0-retry", "0", corev1.PodSucceeded, `{"rows":100,"failed":2}`),
+		batchPod("june-1-failed", "1", corev1.PodFailed, `{"rows":10}`),
+	)
+	ctx := context.Background()
+	reconcileBatch(t, r, batch)
+
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "bp-june", Namespace: "team"}}
+	getObject(t, r, job)
+	job.Status.Active, job.Status.Succeeded = 1, 1
+	if err := r.Update(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	reconcileBatch(t, r, batch)
+	expectBatchPhase(t, batch, mlv1alpha1.BatchRunning, reasonShardsRunning)
+	if batch.Status.Progress != "1/2" || batch.Status.ActiveShards != 1 {
+		t.Errorf("progress = %s, active = %d", batch.Status.Progress, batch.Status.ActiveShards)
+	}
+	if batch.Status.RowsProcessed != 100 || batch.Status.RowsFailed != 2 {
+		t.Errorf("rows = %d, failed = %d, want the report of the succeeded shard", batch.Status.RowsProcessed, batch.Status.RowsFailed)
+	}
+
+	if err := r.Create(ctx, batchPod("june-1", "1", corev1.PodSucceeded, `{"rows":50}`)); err != nil {
+		t.Fatal(err)
+	}
+	job.Status.Active, job.Status.Succeeded = 0, 2
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	if err := r.Update(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	reconcileBatch(t, r, batch)
+	expectBatchPhase(t, batch, mlv1alpha1.BatchSucceeded, reasonJobSucceeded)
+	if batch.Status.RowsProcessed != 150 || batch.Status.RowsFailed != 2 {
+		t.Errorf("rows = %d, failed = %d, want the reports of both shards", batch.Status.RowsProcessed, batch.Status.RowsFailed)
+	}
+	if batch.Status.CompletionTime == nil {
+		t.Error("no completion time")
+	}
+
+	// Finished predictions are left alone.
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+	if err := r.Update(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	reconcileBatch(t, r, batch)
+	expectBatchPhase(t, batch, mlv1alpha1.BatchSucceeded, reasonJobSucceeded)
+}
+
+func TestBatchPredictionFails(t *testing.T) {
+	batch := newTestBatch()
+	r := newBatchReconciler(t, newTestModel(), batch)
+	reconcileBatch(t, r, batch)
+
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "bp-june", Namespace: "team"}}
+	getObject(t, r, job)
+	job.Status.Failed = 4
+	job.Status.Conditions = []batchv1.JobCondition{{
+		Type:    batchv1.JobFailed,
+		Status:  corev1.ConditionTrue,
+		Reason:  "BackoffLimitExceeded",
+		Message: "Job has reached the specified backoff limit",
+	}}
+	if err := r.Update(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	reconcileBatch(t, r, batch)
+
+	expectBatchPhase(t, batch, mlv1alpha1.BatchFailed, reasonJobFailed)
+	failed := meta.FindStatusCondition(batch.Status.Conditions, mlv1alpha1.ConditionFailed)
+	if failed == nil || failed.Status != metav1.ConditionTrue || failed.Message != job.Status.Conditions[0].Message {
+		t.Errorf("failed condition = %+v", failed)
+	}
+	if batch.Status.FailedPods != 4 {
+		t.Errorf("failed pods = %d", batch.Status.FailedPods)
+	}
+}
+
+func TestBatchPredictionWaitsForModel(t *testing.T) {
+	batch := newTestBatch()
+	r := newBatchReconciler(t, batch)
+	reconcileBatch(t, r, batch)
+
+	expectBatchPhase(t, batch, mlv1alpha1.BatchPending, reasonModelNotFound)
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "bp-june", Namespace: "team"}}
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(job), job); !apierrors.IsNotFound(err) {
+		t.Fatalf("get job = %v, want not found", err)
+	}
+
+	// Creating the Model runs the prediction.
+	model_serving := newTestModel()
+	if err := r.Create(context.Background(), model_serving); err != nil {
+		t.Fatal(err)
+	}
+	if got := requestedModels(r.predictionsForModel(model_serving)); got != "team/june" {
+		t.Fatalf("predictions for the model = %q, want team/june", got)
+	}
+	reconcileBatch(t, r, batch)
+	expectBatchPhase(t, batch, mlv1alpha1.BatchRunning, reasonShardsRunning)
+	getObject(t, r, job)
+}
+
+func TestPredictionsForModel(t *testing.T) {
+	batch := func(name, modelName string) *mlv1alpha1.BatchPrediction {
+		batch := newTestBatch()
+		batch.Name = name
+		batch.Spec.ModelRef.Name = modelName
+		return batch
+	}
+	waiting := batch("june", "iris")
+	started := batch("may", "iris")
+	started.Status.JobName = "bp-may"
+	finished := batch("april", "iris")
+	finished.Status.Phase = mlv1alpha1.BatchFailed
+	scheduled := batch("nightly", "iris")
+	scheduled.Spec.Schedule = "0 2 * * *"
+	scheduled.Status.JobName = "bp-nightly-27600000"
+	other := batch("july", "mnist")
+	r := newBatchReconciler(t, waiting, started, finished, scheduled, other)
+
+	if got, want := requestedModels(r.predictionsForModel(newTestModel())), "team/june,team/nightly"; got != want {
+		t.Errorf("predictions = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+)
+
+// Reasons of the phases of a scheduled BatchPrediction.
+const (
+	reasonScheduled = "Scheduled"
+	reasonSuspended = "Suspended"
+)
+
+// Defaults of the schedule of a BatchPrediction.
+const (
+	defaultConcurrencyPolicy          = batchv1.ForbidConcurrent
+	defaultSuccessfulRunsHistoryLimit = int32(3)
+	defaultFailedRunsHistoryLimit     = int32(1)
+)
+
+// batchSchedule maps the schedule of a prediction onto the Job builder,
+// filling in the defaults.
+func batchSchedule(batch *mlv1alpha1.BatchPrediction) *model.BatchSchedule {
+	spec := batch.Spec
+	schedule := &model.BatchSchedule{
+		Schedule:                   spec.Schedule,
+		Suspend:                    spec.Suspend != nil && *spec.Suspend,
+		ConcurrencyPolicy:          spec.ConcurrencyPolicy,
+		SuccessfulJobsHistoryLimit: spec.SuccessfulRunsHistoryLimit,
+		FailedJobsHistoryLimit:     spec.FailedRunsHistoryLimit,
+	}
+	if schedule.ConcurrencyPolicy == "" {
+		schedule.ConcurrencyPolicy = defaultConcurrencyPolicy
+	}
+	if schedule.SuccessfulJobsHistoryLimit == nil {
+		limit := defaultSuccessfulRunsHistoryLimit
+		schedule.SuccessfulJobsHistoryLimit = &limit
+	}
+	if schedule.FailedJobsHistoryLimit == nil {
+		limit := defaultFailedRunsHistoryLimit
+		schedule.FailedJobsHistoryLimit = &limit
+	}
+	if deadline := spec.StartingDeadline; deadline != nil {
+		seconds := int64(deadline.Seconds())
+		schedule.StartingDeadline = &seconds
+	}
+	return schedule
+}
+
+// nextScheduleTime is when schedule is next due after now. CronJobs are
+// scheduled in the time zone of the controller manager, which is UTC on
+// most clusters.
+func nextScheduleTime(schedule string, now time.Time) (time.Time, error) {
+	parsed, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parsed.Next(now.UTC()), nil
+}
+
+// reconcileSchedule creates or updates the CronJob of a scheduled
+// prediction, and reports its schedule and its last run. It requeues for
+// the next run, so the next schedule time moves on.
+func (r *BatchPredictionReconciler) reconcileSchedule(ctx context.Context, batch *mlv1alpha1.BatchPrediction) (ctrl.Result, error) {
+	if errs := batch.Validate(); len(errs) > 0 {
+		setBatchPhase(batch, mlv1alpha1.BatchFailed, reasonInvalidSpec, errs.ToAggregate().Error())
+		r.Recorder.Event(batch, corev1.EventTypeWarning, reasonInvalidSpec, batch.Status.Message)
+		return ctrl.Result{}, nil
+	}
+
+	model_serving, err := r.batchModel(ctx, batch)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if model_serving == nil {
+		// The CronJob, if any, keeps running the model it last had.
+		setBatchPhase(batch, mlv1alpha1.BatchPending, reasonModelNotFound,
+			fmt.Sprintf("Model %s not found", batch.Spec.ModelRef.Name))
+		return ctrl.Result{}, nil
+	}
+
+	mod, b, err := r.render(ctx, batch, model_serving)
+	if err != nil {
+		setBatchPhase(batch, mlv1alpha1.BatchPending, reasonJobNotStarted, err.Error())
+		r.Recorder.Event(batch, corev1.EventTypeWarning, reasonJobNotStarted, err.Error())
+		return ctrl.Result{}, err
+	}
+	b.Schedule = batchSchedule(batch)
+	desired := mod.CreateBatchCronJob(ctx, b)
+	if err := ctrl.SetControllerReference(batch, desired, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+	cronJob, err := r.reconcileCronJob(ctx, batch, desired)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	status := &batch.Status
+	status.ActiveRuns = int32(len(cronJob.Status.Active))
+	status.LastScheduleTime = cronJob.Status.LastScheduleTime
+	status.LastSuccessfulTime = cronJob.Status.LastSuccessfulTime
+
+	run, err := r.lastRun(ctx, batch)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if run != nil {
+		if _, err := r.observeRun(ctx, batch, run); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if b.Schedule.Suspend {
+		status.NextScheduleTime = nil
+		setBatchPhase(batch, mlv1alpha1.BatchSuspended, reasonSuspended, "Schedule suspended")
+		return ctrl.Result{}, nil
+	}
+	now := time.Now()
+	next, err := nextScheduleTime(batch.Spec.Schedule, now)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	status.NextScheduleTime = &metav1.Time{Time: next}
+	setBatchPhase(batch, mlv1alpha1.BatchScheduled, reasonScheduled,
+		fmt.Sprintf("Next run at %s", next.Format(time.RFC3339)))
+	return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+}
+
+// reconcileCronJob creates or updates the CronJob of the prediction, and
+// returns it as it is in the cluster.
+func (r *BatchPredictionReconciler) reconcileCronJob(ctx context.Context, batch *mlv1alpha1.BatchPrediction, desired *batchv1.CronJob) (*batchv1.CronJob, error) {
+	hash := model.Hash(desired.Spec)
+	setHashAnnotation(desired, hash)
+
+	found := &batchv1.CronJob{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), found)
+	if apierrors.IsNotFound(err) {
+		log.FromContext(ctx).Info("Creating CronJob", "name", desired.Name)
+		if err := r.Create(ctx, desired); err != nil {
+			return nil, err
+		}
+		r.Recorder.Eventf(batch, corev1.EventTypeNormal, reasonCreated, "Created CronJob %s", desired.Name)
+		return desired, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if found.Annotations[model.HashAnnotation] == hash &&
+		equality.Semantic.DeepDerivative(desired.Spec, found.Spec) {
+		return found, nil
+	}
+
+	log.FromContext(ctx).Info("Updating CronJob", "name", found.Name)
+	setHashAnnotation(found, hash)
+	found.Spec = desired.Spec
+	if err := r.Update(ctx, found); err != nil {
+		return nil, err
+	}
+	r.Recorder.Eventf(batch, corev1.EventTypeNormal, reasonUpdated, "Updated CronJob %s", found.Name)
+	return found, nil
+}
+
+// lastRun is the most recent Job the CronJob of the prediction started, if
+// it is still kept.
+func (r *BatchPredictionReconciler) lastRun(ctx context.Context, batch *mlv1alpha1.BatchPrediction) (*batchv1.Job, error) {
+	jobs := &batchv1.JobList{}
+	if err := r.List(ctx, jobs, client.InNamespace(batch.Namespace), client.MatchingLabels{model.BatchLabel: batch.Name}); err != nil {
+		return nil, err
+	}
+	var last *batchv1.Job
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if last == nil || last.CreationTimestamp.Before(&job.CreationTimestamp) {
+			last = job
+		}
+	}
+	return last, nil
+}
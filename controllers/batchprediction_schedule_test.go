@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+)
+
+// newScheduledBatch returns the test prediction, run every night at 2am.
+func newScheduledBatch() *mlv1alpha1.BatchPrediction {
+	batch := newTestBatch()
+	batch.Spec.Schedule = "0 2 * * *"
+	return batch
+}
+
+// getCronJob reads the CronJob of the test prediction.
+func getCronJob(t *testing.T, r client.Reader) *batchv1.CronJob {
+	t.Helper()
+	cronJob := &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: "bp-june", Namespace: "team"}}
+	getObject(t, r, cronJob)
+	return cronJob
+}
+
+// updateObject writes obj, failing the test on error.
+func updateObject(t *testing.T, c client.Writer, obj client.Object) {
+	t.Helper()
+	if err := c.Update(context.Background(), obj); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScheduledBatchPredictionCreatesCronJob(t *testing.T) {
+	batch := newScheduledBatch()
+	r := newBatchReconciler(t, newTestModel(), batch)
+	before := time.Now()
+	result := reconcileBatch(t, r, batch)
+
+	cronJob := getCronJob(t, r)
+	if !metav1.IsControlledBy(cronJob, batch) {
+		t.Error("cron job is not controlled by the prediction")
+	}
+	if cronJob.Spec.Schedule != "0 2 * * *" || cronJob.Spec.ConcurrencyPolicy != batchv1.ForbidConcurrent {
+		t.Errorf("schedule = %s, concurrency = %s", cronJob.Spec.Schedule, cronJob.Spec.ConcurrencyPolicy)
+	}
+	if *cronJob.Spec.Suspend {
+		t.Error("cron job is suspended")
+	}
+	if err := r.Get(context.Background(), client.ObjectKey{Namespace: "team", Name: "bp-june"}, &batchv1.Job{}); !apierrors.IsNotFound(err) {
+		t.Errorf("get job = %v, want only the CronJob to start Jobs", err)
+	}
+
+	expectBatchPhase(t, batch, mlv1alpha1.BatchScheduled, reasonScheduled)
+	next, err := nextScheduleTime("0 2 * * *", before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.Status.NextScheduleTime == nil || !batch.Status.NextScheduleTime.Time.Equal(next) {
+		t.Errorf("next schedule time = %v, want %v", batch.Status.NextScheduleTime, next)
+	}
+	if wait := time.Until(next); result.RequeueAfter <= 0 || result.RequeueAfter > wait+time.Minute || result.RequeueAfter < wait-time.Minute {
+		t.Errorf("requeue after %s, want about %s", result.RequeueAfter, wait)
+	}
+}
+
+func TestScheduledBatchPredictionUpdatesCronJob(t *testing.T) {
+	batch := newScheduledBatch()
+	model_serving := newTestModel()
+	r := newBatchReconciler(t, model_serving, batch)
+	reconcileBatch(t, r, batch)
+
+	// Changes made to the CronJob are reverted.
+	cronJob := getCronJob(t, r)
+	cronJob.Spec.Schedule = "* * * * *"
+	updateObject(t, r, cronJob)
+	reconcileBatch(t, r, batch)
+	if cronJob = getCronJob(t, r); cronJob.Spec.Schedule != "0 2 * * *" {
+		t.Errorf("schedule = %s, want the one of the prediction", cronJob.Spec.Schedule)
+	}
+
+	// The next runs use the new version of the Model.
+	getObject(t, r, model_serving)
+	model_serving.Spec.Version = "0.7"
+	updateObject(t, r, model_serving)
+	if got := requestedModels(r.predictionsForModel(model_serving)); got != "team/june" {
+		t.Errorf("predictions for the model = %q, want team/june", got)
+	}
+	reconcileBatch(t, r, batch)
+	cronJob = getCronJob(t, r)
+	if got := cronJob.Spec.JobTemplate.Spec.Template.Annotations[model.VersionAnnotation]; got != "0.7" {
+		t.Errorf("version = %s, want the new version of the model", got)
+	}
+}
+
+func TestScheduledBatchPredictionSuspend(t *testing.T) {
+	batch := newScheduledBatch()
+	suspend := true
+	batch.Spec.Suspend = &suspend
+	r := newBatchReconciler(t, newTestModel(), batch)
+	result := reconcileBatch(t, r, batch)
+
+	if cronJob := getCronJob(t, r); !*cronJob.Spec.Suspend {
+		t.Error("cron job is not suspended")
+	}
+	expectBatchPhase(t, batch, mlv1alpha1.BatchSuspended, reasonSuspended)
+	if batch.Status.NextScheduleTime != nil || result.RequeueAfter != 0 {
+		t.Errorf("next schedule time = %v, requeue after %s, want none", batch.Status.NextScheduleTime, result.RequeueAfter)
+	}
+
+	batch.Spec.Suspend = nil
+	updateObject(t, r, batch)
+	reconcileBatch(t, r, batch)
+	if cronJob := getCronJob(t, r); *cronJob.Spec.Suspend {
+		t.Error("cron job is still suspended")
+	}
+	expectBatchPhase(t, batch, mlv1alpha1.BatchScheduled, reasonScheduled)
+}
+
+func TestScheduledBatchPredictionReportsLastRun(t *testing.T) {
+	batch := newScheduledBatch()
+	run := func(name string, created time.Time) *batchv1.Job {
+		completions := int32(2)
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "team",
+				Labels:            map[string]string{model.BatchLabel: "june"},
+				CreationTimestamp: metav1.Time{Time: created},
+			},
+			Spec: batchv1.JobSpec{Completions: &completions},
+		}
+	}
+	now := time.Now()
+	earlier := run("bp-june-100", now.Add(-48*time.Hour))
+	earlier.Status.Succeeded = 2
+	last := run("bp-june-200", now.Add(-24*time.Hour))
+	last.Status.Succeeded, last.Status.Active = 1, 1
+	report := batchPod("bp-june-200-0", "0", corev1.PodSucceeded, `{"rows":70,"failed":1}`)
+	report.Labels[model.JobNameLabel] = last.Name
+	stale := batchPod("bp-june-100-0", "0", corev1.PodSucceeded, `{"rows":500}`)
+	stale.Labels[model.JobNameLabel] = earlier.Name
+	r := newBatchReconciler(t, newTestModel(), batch, earlier, last, report, stale)
+	reconcileBatch(t, r, batch)
+
+	cronJob := getCronJob(t, r)
+	scheduled := metav1.NewTime(now.Add(-24 * time.Hour).Truncate(time.Second))
+	cronJob.Status.LastScheduleTime = &scheduled
+	cronJob.Status.Active = []corev1.ObjectReference{{Name: last.Name}}
+	updateObject(t, r, cronJob)
+	reconcileBatch(t, r, batch)
+
+	status := batch.Status
+	if status.JobName != last.Name || status.Progress != "1/2" || status.ActiveRuns != 1 {
+		t.Errorf("job = %s, progress = %s, active runs = %d, want the last run", status.JobName, status.Progress, status.ActiveRuns)
+	}
+	if status.RowsProcessed != 70 || status.RowsFailed != 1 {
+		t.Errorf("rows = %d, failed = %d, want the report of the last run", status.RowsProcessed, status.RowsFailed)
+	}
+	if status.LastScheduleTime == nil || !status.LastScheduleTime.Equal(&scheduled) {
+		t.Errorf("last schedule time = %v, want %v", status.LastScheduleTime, scheduled)
+	}
+	expectBatchPhase(t, batch, mlv1alpha1.BatchScheduled, reasonScheduled)
+}
+
+func TestScheduledBatchPredictionInvalidSchedule(t *testing.T) {
+	batch := newScheduledBatch()
+	batch.Spec.Schedule = "every night"
+	r := newBatchReconciler(t, newTestModel(), batch)
+	result := reconcileBatch(t, r, batch)
+
+	expectBatchPhase(t, batch, mlv1alpha1.BatchFailed, reasonInvalidSpec)
+	if result.RequeueAfter != 0 {
+		t.Errorf("requeue after %s, want to wait for a new spec", result.RequeueAfter)
+	}
+	cronJob := &batchv1.CronJob{}
+	if err := r.Get(context.Background(), client.ObjectKey{Namespace: "team", Name: "bp-june"}, cronJob); !apierrors.IsNotFound(err) {
+		t.Errorf("get cron job = %v, want not found", err)
+	}
+
+	// Fixing the schedule schedules the prediction again.
+	batch.Spec.Schedule = "0 2 * * *"
+	updateObject(t, r, batch)
+	reconcileBatch(t, r, batch)
+	expectBatchPhase(t, batch, mlv1alpha1.BatchScheduled, reasonScheduled)
+	getCronJob(t, r)
+}
@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+// The tests in this package outside the Ginkgo suite run the reconcilers
+// against a fake client, so they need no API server.
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := mlv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+// indexedClient filters List by the field indexes the manager registers,
+// which the fake client ignores.
+type indexedClient struct {
+	client.Client
+	indexes map[string]client.IndexerFunc
+}
+
+func (c indexedClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	if listOpts.FieldSelector == nil || listOpts.FieldSelector.Empty() {
+		return c.Client.List(ctx, list, opts...)
+	}
+	requirements := listOpts.FieldSelector.Requirements()
+	listOpts.FieldSelector = nil
+	if err := c.Client.List(ctx, list, listOpts); err != nil {
+		return err
+	}
+
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	matching := []runtime.Object{}
+	for _, item := range items {
+		matches := true
+		for _, requirement := range requirements {
+			index, ok := c.indexes[requirement.Field]
+			if !ok || requirement.Operator != selection.Equals {
+				return fmt.Errorf("no index for field selector %s", requirement.Field)
+			}
+			matches = matches && containsString(index(item.(client.Object)), requirement.Value)
+		}
+		if matches {
+			matching = append(matching, item)
+		}
+	}
+	return meta.SetList(list, matching)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// newTestClient returns a fake client holding objs, with the field indexes
+// of the controllers.
+func newTestClient(scheme *runtime.Scheme, objs ...client.Object) client.Client {
+	return indexedClient{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		indexes: map[string]client.IndexerFunc{
+			credentialsSecretField: indexCredentialsSecret,
+			batchModelRefField:     indexBatchModelRef,
+		},
+	}
+}
+
+// newTestReconciler returns a ModelReconciler whose client holds objs.
+func newTestReconciler(t *testing.T, objs ...client.Object) *ModelReconciler {
+	t.Helper()
+	scheme := newTestScheme(t)
+	return &ModelReconciler{
+		Client:   newTestClient(scheme, objs...),
+		Scheme:   scheme,
+		Recorder: record.NewFakeRecorder(100),
+	}
+}
+
+// newTestModel returns a model reading iris.sav from a bucket.
+func newTestModel() *mlv1alpha1.Model {
+	return &mlv1alpha1.Model{
+		ObjectMeta: metav1.ObjectMeta{Name: "iris", Namespace: "team", Generation: 1},
+		Spec: mlv1alpha1.ModelSpec{
+			Location: "iris.sav",
+			Replicas: 1,
+			Endpoint: "https://sgp1.digitaloceanspaces.com",
+			Bucket:   "models",
+			Columns:  "sepal.length,sepal.width,petal.length,petal.width",
+			Version:  "0.6",
+		},
+	}
+}
+
+func requestFor(obj client.Object) ctrl.Request {
+	return ctrl.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+}
+
+// reconcileModel runs one reconcile of the model, failing the test on error.
+func reconcileModel(t *testing.T, r *ModelReconciler, model_serving *mlv1alpha1.Model) ctrl.Result {
+	t.Helper()
+	result, err := r.Reconcile(context.Background(), requestFor(model_serving))
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	return result
+}
+
+// getObject reads obj back from the client, failing the test if it is missing.
+func getObject(t *testing.T, r client.Reader, obj client.Object) {
+	t.Helper()
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(obj), obj); err != nil {
+		t.Fatalf("get %s: %v", obj.GetName(), err)
+	}
+}
+
+// recordedEvents drains the Events recorded so far.
+func recordedEvents(r *ModelReconciler) []string {
+	recorder := r.Recorder.(*record.FakeRecorder)
+	events := []string{}
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	return events
+}
+
+// hasEvent reports whether one of events starts with the type and reason.
+func hasEvent(events []string, eventType, reason string) bool {
+	for _, event := range events {
+		if strings.HasPrefix(event, eventType+" "+reason+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// servingContainer returns the container named serving of pod.
+func servingContainer(t *testing.T, pod corev1.PodSpec) corev1.Container {
+	t.Helper()
+	for _, container := range pod.Containers {
+		if container.Name == "serving" {
+			return container
+		}
+	}
+	t.Fatalf("no serving container in %v", pod.Containers)
+	return corev1.Container{}
+}
+
+// metricsServer serves metrics, in the Prometheus text format, on /metrics
+// of 127.0.0.1 and returns its port.
+func metricsServer(t *testing.T, metrics string) int32 {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/metrics" {
+			http.NotFound(w, req)
+			return
+		}
+		fmt.Fprint(w, metrics)
+	}))
+	t.Cleanup(server.Close)
+	port, err := strconv.Atoi(server.URL[strings.LastIndex(server.URL, ":")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int32(port)
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	"github.com/kalkyai/model-serving-operator/pkg/fetcher"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+)
+
+// Phases models are counted in, from their conditions.
+const (
+	phasePending     = "Pending"
+	phaseProgressing = "Progressing"
+	phaseReady       = "Ready"
+	phaseIdle        = "Idle"
+	phaseFailed      = "Failed"
+	phaseDeleting    = "Deleting"
+)
+
+var phases = []string{phasePending, phaseProgressing, phaseReady, phaseIdle, phaseFailed, phaseDeleting}
+
+var (
+	artifactDownloadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "model_serving_artifact_download_duration_seconds",
+		Help:    "Time the fetcher init containers took to download and verify a model artifact, by storage scheme.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"scheme"})
+	artifactDownloadFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "model_serving_artifact_download_failures_total",
+		Help: "Artifact downloads that failed, by storage scheme and reason.",
+	}, []string{"scheme", "reason"})
+	rolloutDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "model_serving_rollout_duration_seconds",
+		Help:    "Time from a model starting to progress to all replicas serving the new revision.",
+		Buckets: prometheus.ExponentialBuckets(5, 2, 12),
+	})
+	timeToReady = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "model_serving_time_to_ready_seconds",
+		Help:    "Time from the creation of a model to it first being ready.",
+		Buckets: prometheus.ExponentialBuckets(5, 2, 12),
+	})
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "model_serving_reconcile_errors_total",
+		Help: "Errors reconciling the resources of models, by resource kind.",
+	}, []string{"kind"})
+)
+
+// metricsSince is when the operator started, older downloads were observed
+// by an earlier run.
+var metricsSince = time.Now()
+
+func init() {
+	metrics.Registry.MustRegister(artifactDownloadDuration, artifactDownloadFailures, rolloutDuration, timeToReady, reconcileErrors)
+}
+
+// modelPhase sums up the conditions of a model.
+func modelPhase(model_serving *mlv1alpha1.Model) string {
+	conditions := model_serving.Status.Conditions
+	switch {
+	case !model_serving.DeletionTimestamp.IsZero():
+		return phaseDeleting
+	case meta.IsStatusConditionTrue(conditions, mlv1alpha1.ConditionDegraded):
+		return phaseFailed
+	case meta.IsStatusConditionTrue(conditions, mlv1alpha1.ConditionIdle):
+		return phaseIdle
+	case meta.IsStatusConditionTrue(conditions, mlv1alpha1.ConditionProgressing):
+		return phaseProgressing
+	case meta.IsStatusConditionTrue(conditions, mlv1alpha1.ConditionReady):
+		return phaseReady
+	default:
+		return phasePending
+	}
+}
+
+// modelCollector counts the models per namespace and phase when scraped.
+type modelCollector struct {
+	client client.Reader
+	desc   *prometheus.Desc
+}
+
+func newModelCollector(c client.Reader) *modelCollector {
+	return &modelCollector{
+		client: c,
+		desc: prometheus.NewDesc("model_serving_models",
+			"Models by namespace and phase.", []string{"namespace", "phase"}, nil),
+	}
+}
+
+func (c *modelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *modelCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	models := &mlv1alpha1.ModelList{}
+	if err := c.client.List(ctx, models); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list models for metrics")
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	counts := map[string]map[string]int{}
+	for i := range models.Items {
+		item := &models.Items[i]
+		if counts[item.Namespace] == nil {
+			counts[item.Namespace] = map[string]int{}
+		}
+		counts[item.Namespace][modelPhase(item)]++
+	}
+	for namespace, byPhase := range counts {
+		for _, phase := range phases {
+			ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(byPhase[phase]), namespace, phase)
+		}
+	}
+}
+
+// registerModelCollector registers the model collector on the metrics
+// endpoint of the manager, once.
+func registerModelCollector(c client.Reader) error {
+	err := metrics.Registry.Register(newModelCollector(c))
+	if errors.As(err, &prometheus.AlreadyRegisteredError{}) {
+		return nil
+	}
+	return err
+}
+
+// downloadObserver observes every fetcher run once. It remembers the runs
+// seen per model, which are the fetcher containers of its current pods.
+type downloadObserver struct {
+	mu   sync.Mutex
+	seen map[types.NamespacedName]map[string]bool
+}
+
+// observe records the fetcher runs of the pods of a model that finished
+// since they were last observed.
+func (o *downloadObserver) observe(key types.NamespacedName, pods []corev1.Pod) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.seen == nil {
+		o.seen = map[types.NamespacedName]map[string]bool{}
+	}
+
+	seen := map[string]bool{}
+	for _, pod := range pods {
+		for _, cs := range pod.Status.InitContainerStatuses {
+			if cs.Name != model.FetcherContainer {
+				continue
+			}
+			for _, terminated := range []*corev1.ContainerStateTerminated{cs.State.Terminated, cs.LastTerminationState.Terminated} {
+				if terminated == nil || terminated.ContainerID == "" || terminated.FinishedAt.Time.Before(metricsSince) {
+					continue
+				}
+				seen[terminated.ContainerID] = true
+				if o.seen[key][terminated.ContainerID] {
+					continue
+				}
+				report, ok := fetcher.ParseReport(terminated.Message)
+				if !ok {
+					continue
+				}
+				scheme := uriScheme(report.URI)
+				if report.Reason != "" {
+					artifactDownloadFailures.WithLabelValues(scheme, report.Reason).Inc()
+					continue
+				}
+				artifactDownloadDuration.WithLabelValues(scheme).Observe(terminated.FinishedAt.Sub(terminated.StartedAt.Time).Seconds())
+			}
+		}
+	}
+	o.seen[key] = seen
+}
+
+// forget drops what was seen of a deleted model.
+func (o *downloadObserver) forget(key types.NamespacedName) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.seen, key)
+}
+
+// uriScheme is the storage scheme of an artifact URI, e.g. s3 or gs.
+func uriScheme(uri string) string {
+	if i := strings.Index(uri, "://"); i > 0 {
+		return uri[:i]
+	}
+	return "unknown"
+}
@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	"github.com/kalkyai/model-serving-operator/pkg/fetcher"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+)
+
+// sampleCount is the number of observations of the histogram registered on
+// the metrics endpoint of the operator under name.
+func sampleCount(t *testing.T, name string) uint64 {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		var count uint64
+		for _, metric := range family.GetMetric() {
+			count += metric.GetHistogram().GetSampleCount()
+		}
+		return count
+	}
+	return 0
+}
+
+func TestReconcileObservesReadiness(t *testing.T) {
+	model_serving := newTestModel()
+	model_serving.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Minute))
+	r := newTestReconciler(t, model_serving)
+	readyBefore := sampleCount(t, "model_serving_time_to_ready_seconds")
+	rolloutBefore := sampleCount(t, "model_serving_rollout_duration_seconds")
+
+	reconcileModel(t, r, model_serving)
+	markRolledOut(t, r, &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "iris", Namespace: "team"}})
+	reconcileModel(t, r, model_serving)
+	reconcileModel(t, r, model_serving)
+
+	getObject(t, r, model_serving)
+	if phase := modelPhase(model_serving); phase != phaseReady {
+		t.Fatalf("phase = %s, want %s", phase, phaseReady)
+	}
+	if got := sampleCount(t, "model_serving_time_to_ready_seconds") - readyBefore; got != 1 {
+		t.Errorf("time to ready observed %d times, want once", got)
+	}
+	if got := sampleCount(t, "model_serving_rollout_duration_seconds") - rolloutBefore; got != 1 {
+		t.Errorf("rollout duration observed %d times, want once", got)
+	}
+}
+
+func TestReconcileCountsErrors(t *testing.T) {
+	model_serving := newTestModel()
+	model_serving.Spec.Exposure = &mlv1alpha1.Exposure{Host: "models.example.com"}
+	r := newTestReconciler(t, model_serving, ingressOf(model_serving))
+	before := testutil.ToFloat64(reconcileErrors.WithLabelValues("Ingress"))
+
+	if _, err := r.Reconcile(context.Background(), requestFor(model_serving)); err == nil {
+		t.Fatal("took over an Ingress the model does not own")
+	}
+	if got := testutil.ToFloat64(reconcileErrors.WithLabelValues("Ingress")) - before; got != 1 {
+		t.Errorf("Ingress errors counted %v times, want once", got)
+	}
+}
+
+func TestModelCollector(t *testing.T) {
+	withCondition := func(name, namespace, condition string) *mlv1alpha1.Model {
+		model_serving := newTestModel()
+		model_serving.Name, model_serving.Namespace = name, namespace
+		if condition != "" {
+			model_serving.Status.Conditions = []metav1.Condition{{Type: condition, Status: metav1.ConditionTrue}}
+		}
+		return model_serving
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(
+		withCondition("iris", "team", mlv1alpha1.ConditionReady),
+		withCondition("wine", "team", mlv1alpha1.ConditionReady),
+		withCondition("digits", "team", mlv1alpha1.ConditionDegraded),
+		withCondition("iris", "research", mlv1alpha1.ConditionIdle),
+		withCondition("mnist", "research", ""),
+	).Build()
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(newModelCollector(c))
+
+	expected := `# HELP model_serving_models Models by namespace and phase.
+# TYPE model_serving_models gauge
+model_serving_models{namespace="research",phase="Deleting"} 0
+model_serving_models{namespace="research",phase="Failed"} 0
+model_serving_models{namespace="research",phase="Idle"} 1
+model_serving_models{namespace="research",phase="Pending"} 1
+model_serving_models{namespace="research",phase="Progressing"} 0
+model_serving_models{namespace="research",phase="Ready"} 0
+model_serving_models{namespace="team",phase="Deleting"} 0
+model_serving_models{namespace="team",phase="Failed"} 1
+model_serving_models{namespace="team",phase="Idle"} 0
+model_serving_models{namespace="team",phase="Pending"} 0
+model_serving_models{namespace="team",phase="Progressing"} 0
+model_serving_models{namespace="team",phase="Ready"} 2
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "model_serving_models"); err != nil {
+		t.Error(err)
+	}
+}
+
+// fetcherPod is a pod whose fetcher init container terminated with report.
+func fetcherPod(t *testing.T, containerID string, report fetcher.Report) corev1.Pod {
+	t.Helper()
+	message, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := metav1.NewTime(time.Now())
+	return corev1.Pod{Status: corev1.PodStatus{InitContainerStatuses: []corev1.ContainerStatus{{
+		Name: model.FetcherContainer,
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+			ContainerID: containerID,
+			StartedAt:   started,
+			FinishedAt:  metav1.NewTime(started.Add(4 * time.Second)),
+			Message:     string(message),
+		}},
+	}}}}
+}
+
+func TestDownloadObserver(t *testing.T) {
+	key := types.NamespacedName{Namespace: "team", Name: "iris"}
+	downloadsBefore := sampleCount(t, "model_serving_artifact_download_duration_seconds")
+	failuresBefore := testutil.ToFloat64(artifactDownloadFailures.WithLabelValues("gs", fetcher.ReasonChecksumMismatch))
+
+	pods := []corev1.Pod{
+		fetcherPod(t, "containerd://a", fetcher.Report{URI: "s3://models/iris.sav", Digest: "sha256:aa"}),
+		fetcherPod(t, "containerd://b", fetcher.Report{URI: "gs://models/iris.sav", Reason: fetcher.ReasonChecksumMismatch}),
+	}
+	observer := &downloadObserver{}
+	observer.observe(key, pods)
+	// Later reconciles see the same runs.
+	observer.observe(key, pods)
+
+	if got := sampleCount(t, "model_serving_artifact_download_duration_seconds") - downloadsBefore; got != 1 {
+		t.Errorf("downloads observed %d times, want once", got)
+	}
+	if got := testutil.ToFloat64(artifactDownloadFailures.WithLabelValues("gs", fetcher.ReasonChecksumMismatch)) - failuresBefore; got != 1 {
+		t.Errorf("failures counted %v times, want once", got)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+// analysisInterval is how often the error rate of a canary is checked while
+// a step waits for its pause.
+const analysisInterval = 30 * time.Second
+
+// metricsClient reads the metrics endpoints of canary pods.
+var metricsClient = &http.Client{Timeout: 5 * time.Second}
+
+// requestCounts are the requests served by the canary pods since they
+// started, and how many of them failed with a 5xx status.
+type requestCounts struct {
+	total  float64
+	failed float64
+}
+
+// errorRate is the percentage of failed requests.
+func (c requestCounts) errorRate() float64 {
+	if c.total == 0 {
+		return 0
+	}
+	return c.failed * 100 / c.total
+}
+
+// metricsEndpoint is the port and path the serving pods of the model publish
+// their metrics on, and the counter of the requests they served. They are
+// read from spec.monitoring even when no ServiceMonitor is generated, the
+// port defaults to servingPort.
+func metricsEndpoint(model_serving *mlv1alpha1.Model, servingPort int32) (int32, string, string) {
+	port := servingPort
+	path, metric := defaultMetricsPath, defaultRequestsMetric
+
+	if monitoring := model_serving.Spec.Monitoring; monitoring != nil {
+		if monitoring.Port != nil {
+			port = *monitoring.Port
+		}
+		if monitoring.Path != "" {
+			path = monitoring.Path
+		}
+		if alerts := monitoring.Alerts; alerts != nil && alerts.RequestsMetric != "" {
+			metric = alerts.RequestsMetric
+		}
+	}
+	return port, path, metric
+}
+
+// canaryRequests sums the requests metric over the running canary pods.
+func canaryRequests(ctx context.Context, model_serving *mlv1alpha1.Model, canary *appsv1.StatefulSet, pods []corev1.Pod) (requestCounts, error) {
+	var port int32
+	for _, container := range canary.Spec.Template.Spec.Containers {
+		if container.Name == "serving" && len(container.Ports) > 0 {
+			port = container.Ports[0].ContainerPort
+		}
+	}
+	return podRequests(ctx, model_serving, port, pods)
+}
+
+// podRequests sums the requests metric over the running pods, which serve
+// on servingPort.
+func podRequests(ctx context.Context, model_serving *mlv1alpha1.Model, servingPort int32, pods []corev1.Pod) (requestCounts, error) {
+	port, path, metric := metricsEndpoint(model_serving, servingPort)
+
+	counts := requestCounts{}
+	for _, pod := range pods {
+		if pod.Status.PodIP == "" || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		url := fmt.Sprintf("http://%s%s", net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(port))), path)
+		podCounts, err := scrapeRequests(ctx, url, metric)
+		if err != nil {
+			return counts, fmt.Errorf("reading the metrics of pod %s: %w", pod.Name, err)
+		}
+		counts.total += podCounts.total
+		counts.failed += podCounts.failed
+	}
+	return counts, nil
+}
+
+// scrapeRequests reads the counter named metric from the metrics endpoint
+// at url, counting the samples whose code label is a 5xx status as failed.
+func scrapeRequests(ctx context.Context, url string, metric string) (requestCounts, error) {
+	counts := requestCounts{}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return counts, err
+	}
+	req.Header.Set("Accept", string(expfmt.FmtText))
+	resp, err := metricsClient.Do(req)
+	if err != nil {
+		return counts, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return counts, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return counts, err
+	}
+	family, ok := families[metric]
+	if !ok {
+		return counts, nil
+	}
+	for _, sample := range family.GetMetric() {
+		value := sample.GetCounter().GetValue()
+		if sample.Untyped != nil {
+			value = sample.GetUntyped().GetValue()
+		}
+		counts.total += value
+		for _, label := range sample.GetLabel() {
+			if label.GetName() == "code" && strings.HasPrefix(label.GetValue(), "5") {
+				counts.failed += value
+			}
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+)
+
+// autoscalerMetrics maps the targets of the model onto autoscaler metrics.
+func autoscalerMetrics(autoscaling *mlv1alpha1.Autoscaling) []autoscalingv2.MetricSpec {
+	metrics := []autoscalingv2.MetricSpec{}
+
+	utilization := func(name corev1.ResourceName, target int32) autoscalingv2.MetricSpec {
+		return autoscalingv2.MetricSpec{
+			Type: autoscalingv2.ResourceMetricSourceType,
+			Resource: &autoscalingv2.ResourceMetricSource{
+				Name:   name,
+				Target: autoscalingv2.MetricTarget{Type: autoscalingv2.UtilizationMetricType, AverageUtilization: &target},
+			},
+		}
+	}
+	if target := autoscaling.TargetCPUUtilization; target != nil {
+		metrics = append(metrics, utilization(corev1.ResourceCPU, *target))
+	}
+	if target := autoscaling.TargetMemoryUtilization; target != nil {
+		metrics = append(metrics, utilization(corev1.ResourceMemory, *target))
+	}
+
+	for _, metric := range autoscaling.Metrics {
+		value := metric.TargetAverageValue.DeepCopy()
+		metrics = append(metrics, autoscalingv2.MetricSpec{
+			Type: autoscalingv2.PodsMetricSourceType,
+			Pods: &autoscalingv2.PodsMetricSource{
+				Metric: autoscalingv2.MetricIdentifier{Name: metric.Name},
+				Target: autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &value},
+			},
+		})
+	}
+
+	if len(metrics) == 0 {
+		return nil
+	}
+	return metrics
+}
+
+// autoscaledReplicas is the replica count the autoscaler chose for the
+// stable StatefulSet, and whether the autoscaler is in charge of it. Until
+// the StatefulSet runs pods the count is the minimum of the autoscaler,
+// which does not scale up from zero.
+func (r *ModelReconciler) autoscaledReplicas(ctx context.Context, model_serving *mlv1alpha1.Model) (int32, bool, error) {
+	minReplicas := int32(1)
+	if model_serving.Spec.Autoscaling.MinReplicas != nil {
+		minReplicas = *model_serving.Spec.Autoscaling.MinReplicas
+	}
+
+	statefulset := &appsv1.StatefulSet{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(model_serving), statefulset)
+	if apierrors.IsNotFound(err) {
+		return minReplicas, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if replicas := desiredReplicas(statefulset); replicas > 0 {
+		return replicas, true, nil
+	}
+	return minReplicas, false, nil
+}
+
+// reconcileAutoscaler creates or updates the HorizontalPodAutoscaler of an
+// autoscaled model, or deletes it once autoscaling is turned off.
+func (r *ModelReconciler) reconcileAutoscaler(ctx context.Context, model_serving *mlv1alpha1.Model, mod *model.ModelServing) error {
+	autoscaling := model_serving.Spec.Autoscaling
+	if autoscaling == nil {
+		found := &autoscalingv2.HorizontalPodAutoscaler{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: model_serving.Namespace, Name: model_serving.Name}, found)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !metav1.IsControlledBy(found, model_serving) {
+			return nil
+		}
+		log.FromContext(ctx).Info("Deleting HorizontalPodAutoscaler", "name", found.Name)
+		return client.IgnoreNotFound(r.Delete(ctx, found))
+	}
+
+	desired := mod.CreateAutoscaler(ctx, autoscaling.MinReplicas, autoscaling.MaxReplicas, autoscalerMetrics(autoscaling))
+	if err := ctrl.SetControllerReference(model_serving, desired, r.Scheme); err != nil {
+		return err
+	}
+
+	found := &autoscalingv2.HorizontalPodAutoscaler{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), found)
+	if apierrors.IsNotFound(err) {
+		return r.created(ctx, model_serving, "HorizontalPodAutoscaler", desired)
+	}
+	if err != nil {
+		return err
+	}
+
+	if equality.Semantic.DeepDerivative(desired.Spec, found.Spec) {
+		return nil
+	}
+	found.Spec = desired.Spec
+	return r.updated(ctx, model_serving, "HorizontalPodAutoscaler", found)
+}
+
+// autoscalerDesiredReplicas is the replica count the autoscaler of the
+// model last asked for, and whether the autoscaler exists.
+func (r *ModelReconciler) autoscalerDesiredReplicas(ctx context.Context, model_serving *mlv1alpha1.Model) (int32, bool, error) {
+	found := &autoscalingv2.HorizontalPodAutoscaler{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: model_serving.Namespace, Name: model_serving.Name}, found)
+	if apierrors.IsNotFound(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return found.Status.DesiredReplicas, true, nil
+}
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+func autoscalerOf(model_serving *mlv1alpha1.Model) *autoscalingv2.HorizontalPodAutoscaler {
+	return &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Name: model_serving.Name, Namespace: model_serving.Namespace}}
+}
+
+func statefulSetReplicas(t *testing.T, r *ModelReconciler) int32 {
+	t.Helper()
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "iris", Namespace: "team"}}
+	getObject(t, r, statefulset)
+	return *statefulset.Spec.Replicas
+}
+
+func TestAutoscalerLifecycle(t *testing.T) {
+	model_serving := newTestModel()
+	model_serving.Spec.Autoscaling = &mlv1alpha1.Autoscaling{
+		MinReplicas:          pointer.Int32(2),
+		MaxReplicas:          5,
+		TargetCPUUtilization: pointer.Int32(70),
+	}
+	r := newTestReconciler(t, model_serving)
+
+	reconcileModel(t, r, model_serving)
+
+	hpa := autoscalerOf(model_serving)
+	getObject(t, r, hpa)
+	if !metav1.IsControlledBy(hpa, model_serving) {
+		t.Error("autoscaler is not owned by the model")
+	}
+	if ref := hpa.Spec.ScaleTargetRef; ref.Kind != "StatefulSet" || ref.Name != "iris" {
+		t.Errorf("scale target = %+v", ref)
+	}
+	if *hpa.Spec.MinReplicas != 2 || hpa.Spec.MaxReplicas != 5 {
+		t.Errorf("replicas = %d to %d, want 2 to 5", *hpa.Spec.MinReplicas, hpa.Spec.MaxReplicas)
+	}
+	if len(hpa.Spec.Metrics) != 1 || hpa.Spec.Metrics[0].Resource.Name != corev1.ResourceCPU ||
+		*hpa.Spec.Metrics[0].Resource.Target.AverageUtilization != 70 {
+		t.Errorf("metrics = %+v, want 70%% CPU utilization", hpa.Spec.Metrics)
+	}
+	if replicas := statefulSetReplicas(t, r); replicas != 2 {
+		t.Errorf("StatefulSet replicas = %d, want the autoscaler minimum", replicas)
+	}
+	if !hasEvent(recordedEvents(r), corev1.EventTypeNormal, reasonCreated) {
+		t.Errorf("no %s Event", reasonCreated)
+	}
+
+	// The autoscaler scales the StatefulSet, then the targets change.
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "iris", Namespace: "team"}}
+	getObject(t, r, statefulset)
+	statefulset.Spec.Replicas = pointer.Int32(4)
+	if err := r.Update(context.Background(), statefulset); err != nil {
+		t.Fatal(err)
+	}
+	getObject(t, r, model_serving)
+	model_serving.Spec.Autoscaling.MaxReplicas = 8
+	model_serving.Spec.Autoscaling.Metrics = []mlv1alpha1.PodMetricTarget{{Name: "requests_per_second", TargetAverageValue: resource.MustParse("50")}}
+	if err := r.Update(context.Background(), model_serving); err != nil {
+		t.Fatal(err)
+	}
+	reconcileModel(t, r, model_serving)
+
+	getObject(t, r, hpa)
+	if hpa.Spec.MaxReplicas != 8 || len(hpa.Spec.Metrics) != 2 {
+		t.Errorf("autoscaler = %d replicas at most with %d metrics, want 8 with 2", hpa.Spec.MaxReplicas, len(hpa.Spec.Metrics))
+	}
+	if pods := hpa.Spec.Metrics[1].Pods; pods == nil || pods.Metric.Name != "requests_per_second" || pods.Target.AverageValue.String() != "50" {
+		t.Errorf("pods metric = %+v", hpa.Spec.Metrics[1])
+	}
+	if replicas := statefulSetReplicas(t, r); replicas != 4 {
+		t.Errorf("StatefulSet replicas = %d, want the 4 the autoscaler chose", replicas)
+	}
+
+	// Turning autoscaling off hands the replicas back to the spec.
+	getObject(t, r, model_serving)
+	model_serving.Spec.Autoscaling = nil
+	if err := r.Update(context.Background(), model_serving); err != nil {
+		t.Fatal(err)
+	}
+	reconcileModel(t, r, model_serving)
+
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(hpa), hpa); !apierrors.IsNotFound(err) {
+		t.Errorf("autoscaler left after autoscaling was removed: %v", err)
+	}
+	if replicas := statefulSetReplicas(t, r); replicas != 1 {
+		t.Errorf("StatefulSet replicas = %d, want the ones of the spec", replicas)
+	}
+}
+
+func TestAutoscalerNotOwned(t *testing.T) {
+	model_serving := newTestModel()
+	foreign := autoscalerOf(model_serving)
+	foreign.Spec.MaxReplicas = 3
+	r := newTestReconciler(t, model_serving, foreign)
+
+	reconcileModel(t, r, model_serving)
+
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(foreign), foreign); err != nil {
+		t.Errorf("autoscaler the model does not own was deleted: %v", err)
+	}
+}
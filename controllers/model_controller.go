@@ -19,165 +19,637 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	"github.com/kalkyai/model-serving-operator/pkg/config"
 	model "github.com/kalkyai/model-serving-operator/pkg/model"
+	"github.com/kalkyai/model-serving-operator/pkg/runtimes"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
+// Reasons of the Events recorded on a Model.
+const (
+	reasonCreated                    = "Created"
+	reasonUpdated                    = "Updated"
+	reasonScaled                     = "Scaled"
+	reasonRolloutStarted             = "RolloutStarted"
+	reasonRolloutFinished            = "RolloutFinished"
+	reasonArtifactDownloadFailed     = "ArtifactDownloadFailed"
+	reasonArtifactVerificationFailed = "ArtifactVerificationFailed"
+	reasonCredentialsMissing         = "CredentialsMissing"
+	reasonCredentialsConflict        = "CredentialsConflict"
+)
+
 // ModelReconciler reconciles a Model object
 type ModelReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// Defaults fill in the fields a Model leaves unset.
+	Defaults config.Defaults
+	// Activator is where Models with an idle timeout send their requests.
+	Activator config.Activator
+
+	// downloads observes the artifact downloads of the serving pods.
+	downloads downloadObserver
+	// monitoringAPI is set when the Prometheus Operator CRDs were installed
+	// as the controller started.
+	monitoringAPI bool
 }
 
-// called when trying to create new resource.
-func (r *ModelReconciler) reconcileCreate(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-
-	ctrllog := log.FromContext(ctx).WithValues("models", req.NamespacedName)
-
-	model_serving := &mlv1alpha1.Model{}
-	err := r.Get(ctx, req.NamespacedName, model_serving)
+// newModelServing maps a Model onto the builder used to render its resources.
+// Fields the Model leaves unset fall back to the operator defaults, which
+// covers Models created while the defaulting webhook was not running.
+// runtime is the ServingRuntime chosen by resolveRuntime, nil for the
+// built-in runtime of the framework.
+func newModelServing(model_serving *mlv1alpha1.Model, defaults config.Defaults, runtime *servingRuntime) (*model.ModelServing, error) {
+	defaults = defaults.Complete()
+	revision := desiredRevision(model_serving)
 
 	mod := &model.ModelServing{
 		Name:      model_serving.Name,
 		Replicas:  model_serving.Spec.Replicas,
-		ModelURL:  model_serving.Spec.Location,
+		ModelURL:  revision.Location,
 		Columns:   model_serving.Spec.Columns,
 		Namespace: model_serving.Namespace,
-		Version:   model_serving.Spec.Version,
+		Version:   revision.Version,
 		AccessKey: model_serving.Spec.Accesskey,
 		SecretKey: model_serving.Spec.SecretKey,
-		Endpoint:  model_serving.Spec.Endpoint,
-		Bucket:    model_serving.Spec.Bucket,
+
+		ImageRepository:  defaults.ImageRepository,
+		Port:             defaults.Port,
+		StorageSize:      *defaults.StorageSize,
+		StorageClassName: defaults.StorageClassName,
+		FetcherImage:     defaults.FetcherImage,
+		RetainVolumes:    cleanupPolicy(model_serving).Volumes == mlv1alpha1.RetainVolumes,
 	}
 
-	config := mod.CreateConfigMap(ctx,
-		model_serving.Spec.Location,
-		model_serving.Spec.Columns,
-		model_serving.Spec.Accesskey,
-		model_serving.Spec.SecretKey,
-		model_serving.Spec.Endpoint,
-		model_serving.Spec.Bucket,
-	)
+	// A ServingRuntime replaces the built-in runtimes, which only serve
+	// their own framework.
+	if runtime == nil {
+		builtin, ok := runtimes.Lookup(model_serving.Spec.Framework)
+		if !ok {
+			return nil, fmt.Errorf("no built-in runtime serves format %s", modelFormat(model_serving))
+		}
+		mod.Runtime = builtin
+		if builtin.Port != 0 {
+			mod.Port = builtin.Port
+		}
+	}
 
-	ctrllog.Info("Creating new volume")
-	volume := mod.CreateVolume(ctx)
-	deployment := mod.CreateDeployment(ctx, volume)
-	service := mod.CreateService(ctx)
+	if model_serving.Spec.ImageRepository != "" {
+		mod.ImageRepository = model_serving.Spec.ImageRepository
+		mod.Runtime.Image = ""
+	}
+	if model_serving.Spec.Port != nil {
+		mod.Port = *model_serving.Spec.Port
+	}
+	if model_serving.Spec.StorageSize != nil {
+		mod.StorageSize = *model_serving.Spec.StorageSize
+	}
+	if model_serving.Spec.StorageClassName != nil {
+		mod.StorageClassName = *model_serving.Spec.StorageClassName
+	}
+	if runtime != nil {
+		applyRuntime(mod, runtime)
+	}
+
+	mod.Resources = model_serving.Spec.Resources
+	mod.NodeSelector = model_serving.Spec.NodeSelector
+	mod.Tolerations = model_serving.Spec.Tolerations
+	mod.Affinity = model_serving.Spec.Affinity
+	mod.TopologySpreadConstraints = model_serving.Spec.TopologySpreadConstraints
+	mod.PriorityClassName = model_serving.Spec.PriorityClassName
+	// Decided from the spec rather than the running replicas, so scaling
+	// between several replicas does not roll the pods.
+	autoscaling := model_serving.Spec.Autoscaling
+	mod.SpreadZones = model_serving.Spec.Replicas > 1 || (autoscaling != nil && autoscaling.MaxReplicas > 1)
+	mod.StartupBudget = startupBudget(model_serving, mod)
+	mod.Exposure = modelExposure(model_serving)
+	mod.Monitoring = modelMonitoring(model_serving)
+
+	return mod, nil
+}
 
-	ctrl.SetControllerReference(model_serving, volume, r.Scheme)
-	ctrl.SetControllerReference(model_serving, deployment, r.Scheme)
-	ctrl.SetControllerReference(model_serving, service, r.Scheme)
-	ctrl.SetControllerReference(model_serving, config, r.Scheme)
+// hasInlineCredentials reports whether the model still uses the deprecated
+// plain text credential fields instead of a Secret reference.
+func hasInlineCredentials(model_serving *mlv1alpha1.Model) bool {
+	return model_serving.Spec.CredentialsSecretRef == nil &&
+		(model_serving.Spec.Accesskey != "" || model_serving.Spec.SecretKey != "")
+}
 
-	ctrllog.Info("Creating ConfigMap")
-	err = r.Create(ctx, config)
+// reconcileResources renders the desired ConfigMap, StatefulSet and Service
+// for the model and creates or updates them so they match. During a canary
+// rollout a second ConfigMap and StatefulSet run the new revision.
+func (r *ModelReconciler) reconcileResources(ctx context.Context, model_serving *mlv1alpha1.Model) (ctrl.Result, error) {
+	ctrllog := log.FromContext(ctx)
 
+	runtime, err := resolveRuntime(ctx, r, model_serving)
+	if err != nil {
+		r.Recorder.Event(model_serving, corev1.EventTypeWarning, reasonRuntimeUnavailable, err.Error())
+		reconcileErrors.WithLabelValues("ServingRuntime").Inc()
+		return ctrl.Result{}, err
+	}
+	mod, err := newModelServing(model_serving, r.Defaults, runtime)
 	if err != nil {
-		if apierrors.IsBadRequest(err) {
-			ctrllog.Error(err, "Failed to create new configmap")
+		r.Recorder.Event(model_serving, corev1.EventTypeWarning, reasonRuntimeUnavailable, err.Error())
+		reconcileErrors.WithLabelValues("ServingRuntime").Inc()
+		return ctrl.Result{}, err
+	}
+	model_serving.Status.Runtime = ""
+	if runtime != nil {
+		model_serving.Status.Runtime = runtime.String()
+	}
+	model_serving.Status.URL = mod.ServiceURL()
+
+	servingPods, err := r.countRequests(ctx, model_serving, mod)
+	if err != nil {
+		reconcileErrors.WithLabelValues("Pod").Inc()
+		return ctrl.Result{}, err
+	}
+	idle, wakeIn := idleFor(model_serving, time.Now())
+	mod.ViaActivator = model_serving.Spec.IdleTimeout != nil
+	if idle {
+		servingPods = nil
+	}
+	autoscaled := false
+	switch {
+	case idle:
+		mod.Replicas = 0
+	case model_serving.Spec.Autoscaling != nil:
+		// The autoscaler owns the replica count, canary splits start from
+		// what it chose.
+		if mod.Replicas, autoscaled, err = r.autoscaledReplicas(ctx, model_serving); err != nil {
 			return ctrl.Result{}, err
 		}
 	}
 
-	ctrllog.Info("Creating Deployment")
-	err = r.Create(ctx, deployment)
+	canary := r.planRollout(model_serving, mod)
 
+	config, deployment, err := r.renderWorkload(ctx, model_serving, mod)
 	if err != nil {
-		if apierrors.IsBadRequest(err) {
-			ctrllog.Error(err, "Failed to create new deployment")
+		return ctrl.Result{}, err
+	}
+	if autoscaled {
+		deployment.Spec.Replicas = nil
+	}
+	service := mod.CreateService(ctx)
+	if err := ctrl.SetControllerReference(model_serving, service, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var canaryConfig *corev1.ConfigMap
+	var canaryDeployment *appsv1.StatefulSet
+	if canary != nil {
+		canaryConfig, canaryDeployment, err = r.renderWorkload(ctx, model_serving, canary)
+		if err != nil {
 			return ctrl.Result{}, err
 		}
 	}
 
-	ctrllog.Info("Creating Service")
-	err = r.Create(ctx, service)
+	if err := r.reconcileCredentials(ctx, model_serving, mod); err != nil {
+		ctrllog.Error(err, "Failed to reconcile credentials secret")
+		reconcileErrors.WithLabelValues("Secret").Inc()
+		return ctrl.Result{}, err
+	}
+
+	if err := r.checkCredentials(ctx, model_serving); err != nil {
+		reconcileErrors.WithLabelValues("Secret").Inc()
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileConfigMap(ctx, model_serving, config); err != nil {
+		ctrllog.Error(err, "Failed to reconcile configmap")
+		reconcileErrors.WithLabelValues("ConfigMap").Inc()
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileStatefulSet(ctx, model_serving, deployment); err != nil {
+		ctrllog.Error(err, "Failed to reconcile statefulset")
+		reconcileErrors.WithLabelValues("StatefulSet").Inc()
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileAutoscaler(ctx, model_serving, mod); err != nil {
+		ctrllog.Error(err, "Failed to reconcile autoscaler")
+		reconcileErrors.WithLabelValues("HorizontalPodAutoscaler").Inc()
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileCanary(ctx, model_serving, canaryConfig, canaryDeployment); err != nil {
+		ctrllog.Error(err, "Failed to reconcile canary")
+		reconcileErrors.WithLabelValues("StatefulSet").Inc()
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileService(ctx, model_serving, service); err != nil {
+		ctrllog.Error(err, "Failed to reconcile service")
+		reconcileErrors.WithLabelValues("Service").Inc()
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileExposure(ctx, model_serving, mod); err != nil {
+		ctrllog.Error(err, "Failed to reconcile exposure")
+		reconcileErrors.WithLabelValues(string(exposureKind(model_serving))).Inc()
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileMonitoring(ctx, model_serving, mod); err != nil {
+		ctrllog.Error(err, "Failed to reconcile monitoring")
+		reconcileErrors.WithLabelValues("ServiceMonitor").Inc()
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileActivatorEndpoints(ctx, model_serving, mod, servingPods); err != nil {
+		ctrllog.Error(err, "Failed to reconcile activator endpoints")
+		reconcileErrors.WithLabelValues("Endpoints").Inc()
+		return ctrl.Result{}, err
+	}
+
+	result, err := r.advanceRollout(ctx, model_serving, deployment, canaryDeployment)
+	if wakeIn > 0 && (result.RequeueAfter == 0 || wakeIn < result.RequeueAfter) {
+		// Check again once the model may have gone idle.
+		result.RequeueAfter = wakeIn
+	}
+	return result, err
+}
+
+// renderWorkload renders the ConfigMap and StatefulSet of one track of the
+// model, owned by the model.
+func (r *ModelReconciler) renderWorkload(ctx context.Context, model_serving *mlv1alpha1.Model, mod *model.ModelServing) (*corev1.ConfigMap, *appsv1.StatefulSet, error) {
+	if err := locateArtifact(model_serving, mod); err != nil {
+		return nil, nil, err
+	}
+	config := mod.CreateConfigMap(ctx, mod.ArtifactPath, mod.Columns, mod.Endpoint, mod.Bucket)
+	volume := mod.CreateVolume(ctx)
+	deployment := mod.CreateDeployment(ctx, volume)
+	applyProbes(model_serving, mod, deployment)
+	if err := r.overridePodTemplate(model_serving, deployment); err != nil {
+		return nil, nil, err
+	}
+
+	for _, obj := range []client.Object{config, deployment} {
+		if err := ctrl.SetControllerReference(model_serving, obj, r.Scheme); err != nil {
+			return nil, nil, err
+		}
+	}
+	return config, deployment, nil
+}
+
+// created creates obj and records an Event on the model when it succeeds.
+func (r *ModelReconciler) created(ctx context.Context, model_serving *mlv1alpha1.Model, kind string, obj client.Object) error {
+	log.FromContext(ctx).Info("Creating "+kind, "name", obj.GetName())
+	if err := r.Create(ctx, obj); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(model_serving, corev1.EventTypeNormal, reasonCreated, "Created %s %s", kind, obj.GetName())
+	return nil
+}
+
+// updated updates obj and records an Event on the model when it succeeds.
+func (r *ModelReconciler) updated(ctx context.Context, model_serving *mlv1alpha1.Model, kind string, obj client.Object) error {
+	log.FromContext(ctx).Info("Updating "+kind, "name", obj.GetName())
+	if err := r.Update(ctx, obj); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(model_serving, corev1.EventTypeNormal, reasonUpdated, "Updated %s %s", kind, obj.GetName())
+	return nil
+}
+
+// checkCredentials warns when a Secret the model storage references or one
+// of its keys is missing. Pods cannot start without them, but the rest of the
+// model is still reconciled so it comes up as soon as the Secret appears.
+func (r *ModelReconciler) checkCredentials(ctx context.Context, model_serving *mlv1alpha1.Model) error {
+	if hasInlineCredentials(model_serving) {
+		return nil
+	}
+
+	for _, ref := range storageSecrets(artifactStorage(model_serving)) {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: model_serving.Namespace, Name: ref.name}, secret)
+		if apierrors.IsNotFound(err) {
+			r.Recorder.Eventf(model_serving, corev1.EventTypeWarning, reasonCredentialsMissing, "Secret %s not found", ref.name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, key := range ref.keys {
+			if _, ok := secret.Data[key]; !ok {
+				r.Recorder.Eventf(model_serving, corev1.EventTypeWarning, reasonCredentialsMissing, "Secret %s has no key %s", ref.name, key)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *ModelReconciler) reconcileConfigMap(ctx context.Context, model_serving *mlv1alpha1.Model, desired *corev1.ConfigMap) error {
+	found := &corev1.ConfigMap{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), found)
+	if apierrors.IsNotFound(err) {
+		return r.created(ctx, model_serving, "ConfigMap", desired)
+	}
 	if err != nil {
-		if apierrors.IsBadRequest(err) {
-			ctrllog.Error(err, "Failed to create new service")
-			return ctrl.Result{}, err
+		return err
+	}
+
+	if equality.Semantic.DeepEqual(desired.Data, found.Data) {
+		return nil
+	}
+
+	found.Data = desired.Data
+	return r.updated(ctx, model_serving, "ConfigMap", found)
+}
+
+// reconcileCredentials keeps the operator-managed Secret in sync with the
+// deprecated inline credentials, and removes it once the model no longer
+// uses them. A Secret of the same name the model does not own is left as it
+// is and reported instead.
+func (r *ModelReconciler) reconcileCredentials(ctx context.Context, model_serving *mlv1alpha1.Model, mod *model.ModelServing) error {
+	ctrllog := log.FromContext(ctx)
+
+	desired := mod.CreateSecret(ctx)
+
+	found := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), found)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	if !hasInlineCredentials(model_serving) {
+		if exists && metav1.IsControlledBy(found, model_serving) {
+			ctrllog.Info("Deleting Secret for inline credentials", "secret", desired.Name)
+			return client.IgnoreNotFound(r.Delete(ctx, found))
 		}
-		ctrllog.Error(err, "Failed to create new service")
+		return nil
+	}
+
+	if err := ctrl.SetControllerReference(model_serving, desired, r.Scheme); err != nil {
+		return err
+	}
+
+	if !exists {
+		return r.created(ctx, model_serving, "Secret", desired)
+	}
+	if err := r.checkOwner(model_serving, "Secret", found); err != nil {
+		r.Recorder.Event(model_serving, corev1.EventTypeWarning, reasonCredentialsConflict, err.Error())
+		return err
+	}
+
+	if equality.Semantic.DeepEqual(desired.Data, found.Data) {
+		return nil
+	}
+
+	found.Data = desired.Data
+	return r.updated(ctx, model_serving, "Secret", found)
+}
+
+// mutableStatefulSetSpec keeps the parts of a StatefulSet spec the API server
+// allows us to change after creation. Selector, service name and volume claim
+// templates are immutable and are only ever set on create.
+func mutableStatefulSetSpec(spec appsv1.StatefulSetSpec) appsv1.StatefulSetSpec {
+	return appsv1.StatefulSetSpec{
+		Replicas:                             spec.Replicas,
+		Template:                             spec.Template,
+		UpdateStrategy:                       spec.UpdateStrategy,
+		RevisionHistoryLimit:                 spec.RevisionHistoryLimit,
+		MinReadySeconds:                      spec.MinReadySeconds,
+		PersistentVolumeClaimRetentionPolicy: spec.PersistentVolumeClaimRetentionPolicy,
+	}
+}
+
+func (r *ModelReconciler) reconcileStatefulSet(ctx context.Context, model_serving *mlv1alpha1.Model, desired *appsv1.StatefulSet) error {
+	ctrllog := log.FromContext(ctx)
+
+	wanted := mutableStatefulSetSpec(desired.Spec)
+	hash := model.Hash(wanted)
+	setHashAnnotation(desired, hash)
+
+	found := &appsv1.StatefulSet{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), found)
+	if apierrors.IsNotFound(err) {
+		return r.created(ctx, model_serving, "StatefulSet", desired)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The hash catches changes to the Model, DeepDerivative catches someone
+	// editing a field we own on the live object.
+	if found.Annotations[model.HashAnnotation] == hash &&
+		equality.Semantic.DeepDerivative(wanted, mutableStatefulSetSpec(found.Spec)) {
+		return nil
 	}
 
-	return ctrl.Result{Requeue: true}, nil
+	ctrllog.Info("Updating StatefulSet", "statefulset", desired.Name)
+	scaledFrom := desiredReplicas(found)
+	rollout := !equality.Semantic.DeepDerivative(wanted.Template, found.Spec.Template)
 
+	setHashAnnotation(found, hash)
+	// Without replicas the count is left to the autoscaler.
+	if wanted.Replicas != nil {
+		found.Spec.Replicas = wanted.Replicas
+	}
+	found.Spec.Template = wanted.Template
+	found.Spec.UpdateStrategy = wanted.UpdateStrategy
+	found.Spec.RevisionHistoryLimit = wanted.RevisionHistoryLimit
+	found.Spec.MinReadySeconds = wanted.MinReadySeconds
+	found.Spec.PersistentVolumeClaimRetentionPolicy = wanted.PersistentVolumeClaimRetentionPolicy
+	if err := r.Update(ctx, found); err != nil {
+		return err
+	}
+
+	switch {
+	case rollout:
+		r.Recorder.Eventf(model_serving, corev1.EventTypeNormal, reasonRolloutStarted,
+			"Rolling out version %s to StatefulSet %s", model_serving.Spec.Version, found.Name)
+	case scaledFrom != desiredReplicas(found):
+		r.Recorder.Eventf(model_serving, corev1.EventTypeNormal, reasonScaled,
+			"Scaled StatefulSet %s from %d to %d replicas", found.Name, scaledFrom, desiredReplicas(found))
+	default:
+		r.Recorder.Eventf(model_serving, corev1.EventTypeNormal, reasonUpdated, "Updated StatefulSet %s", found.Name)
+	}
+	return nil
+}
+
+func (r *ModelReconciler) reconcileService(ctx context.Context, model_serving *mlv1alpha1.Model, desired *corev1.Service) error {
+	hash := model.Hash(desired.Spec)
+	setHashAnnotation(desired, hash)
+
+	found := &corev1.Service{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), found)
+	if apierrors.IsNotFound(err) {
+		return r.created(ctx, model_serving, "Service", desired)
+	}
+	if err != nil {
+		return err
+	}
+
+	if found.Annotations[model.HashAnnotation] == hash &&
+		equality.Semantic.DeepDerivative(desired.Spec, found.Spec) {
+		return nil
+	}
+
+	setHashAnnotation(found, hash)
+	found.Spec.Selector = desired.Spec.Selector
+	found.Spec.Ports = desired.Spec.Ports
+	return r.updated(ctx, model_serving, "Service", found)
+}
+
+func setHashAnnotation(obj metav1.Object, hash string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[model.HashAnnotation] = hash
+	obj.SetAnnotations(annotations)
 }
 
 //+kubebuilder:rbac:groups=ml.kalkyai.com,resources=models,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=ml.kalkyai.com,resources=models/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=ml.kalkyai.com,resources=models/finalizers,verbs=update
+//+kubebuilder:rbac:groups=ml.kalkyai.com,resources=servingruntimes;clusterservingruntimes,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
-//+kubebuilder:rbac:groups=core,resources=pvc,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=endpoints,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors;prometheusrules,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the Model object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// Every pass renders the ConfigMap, StatefulSet and Service from the Model
+// spec and updates whatever has drifted from it, whether the Model was edited
+// or one of the owned objects was changed by hand. Deleted Models run their
+// cleanup policy before the finalizer is released.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.12.1/pkg/reconcile
 func (r *ModelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	ctrllog := log.FromContext(ctx).WithValues("models", req.NamespacedName)
+	ctx = log.IntoContext(ctx, ctrllog)
 
 	ctrllog.Info("Initializing Reconcile")
 	model_serving := &mlv1alpha1.Model{}
 	err := r.Get(ctx, req.NamespacedName, model_serving)
 
-	ctrllog.Info(fmt.Sprintf("%s", err))
-
 	if err != nil {
 		if apierrors.IsNotFound(err) {
-			ctrllog.Error(err, "Model not found")
+			ctrllog.Info("Model not found, ignoring since object must be deleted")
+			r.downloads.forget(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
-		ctrllog.Info("Model found")
+		ctrllog.Error(err, "Failed to get model")
 		return ctrl.Result{}, err
 	}
 
-	ctrllog.Info("Model Found")
-
-	// See if the statefulset exists
-	statefulset := &appsv1.StatefulSet{}
-	err = r.Get(ctx, types.NamespacedName{
-		Namespace: model_serving.Namespace,
-		Name:      model_serving.Name,
-	}, statefulset)
+	if !model_serving.DeletionTimestamp.IsZero() {
+		return r.reconcileDelete(ctx, model_serving)
+	}
 
-	if err != nil {
-		// create new resource if not found
-		if apierrors.IsNotFound(err) {
-			ctrllog.Info("Error --- statefulset not found create a new one")
-			return r.reconcileCreate(ctx, req)
+	if controllerutil.AddFinalizer(model_serving, cleanupFinalizer) {
+		if err := r.Update(ctx, model_serving); err != nil {
+			ctrllog.Error(err, "Failed to add finalizer")
+			return ctrl.Result{}, err
 		}
-		ctrllog.Info("Model found")
-		return ctrl.Result{}, err
 	}
 
-	// Handle if not already exists
+	if err := r.reconcileRollback(ctx, model_serving); err != nil {
+		ctrllog.Error(err, "Failed to apply rollback")
+		return ctrl.Result{}, err
+	}
 
-	return ctrl.Result{}, nil
+	result, err := r.reconcileResources(ctx, model_serving)
+	if statusErr := r.updateStatus(ctx, model_serving, err); statusErr != nil {
+		ctrllog.Error(statusErr, "Failed to update model status")
+		if err == nil {
+			return ctrl.Result{}, statusErr
+		}
+	}
+	if wait := untilProgressDeadline(model_serving, time.Now()); wait > 0 && (result.RequeueAfter == 0 || wait < result.RequeueAfter) {
+		// Roll back a revision that misses its deadline even when no pod
+		// event comes in.
+		result.RequeueAfter = wait
+	}
+	return result, err
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ModelReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&mlv1alpha1.Model{}).
-		Owns(&appsv1.Deployment{}).
-		Complete(r)
+	if err := registerModelCollector(mgr.GetClient()); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &mlv1alpha1.Model{}, credentialsSecretField, indexCredentialsSecret); err != nil {
+		return err
+	}
+
+	blder := ctrl.NewControllerManagedBy(mgr).
+		For(&mlv1alpha1.Model{}, builder.WithPredicates(requestsRecorded)).
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Secret{}).
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}).
+		Owns(&corev1.Endpoints{}).
+		Owns(&networkingv1.Ingress{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.modelsForSecret)).
+		Watches(&source.Kind{Type: &mlv1alpha1.ServingRuntime{}},
+			handler.EnqueueRequestsFromMapFunc(r.modelsForRuntime)).
+		Watches(&source.Kind{Type: &mlv1alpha1.ClusterServingRuntime{}},
+			handler.EnqueueRequestsFromMapFunc(r.modelsForRuntime)).
+		Watches(&source.Kind{Type: &corev1.Endpoints{}},
+			handler.EnqueueRequestsFromMapFunc(r.modelsForActivator)).
+		Watches(&source.Kind{Type: &corev1.Pod{}},
+			handler.EnqueueRequestsFromMapFunc(modelForPod),
+			builder.WithPredicates(podStateChanged))
+
+	// HTTPRoutes are only watched when the Gateway API is installed.
+	if _, err := mgr.GetRESTMapper().RESTMapping(model.HTTPRouteGVK.GroupKind(), model.HTTPRouteGVK.Version); err == nil {
+		route := &unstructured.Unstructured{}
+		route.SetGroupVersionKind(model.HTTPRouteGVK)
+		blder = blder.Owns(route)
+	}
+
+	// So are ServiceMonitors and PrometheusRules with the Prometheus
+	// Operator.
+	if r.monitoringAPI = hasMonitoringAPI(mgr); r.monitoringAPI {
+		for _, gvk := range []schema.GroupVersionKind{model.ServiceMonitorGVK, model.PrometheusRuleGVK} {
+			obj := &unstructured.Unstructured{}
+			obj.SetGroupVersionKind(gvk)
+			blder = blder.Owns(obj)
+		}
+	}
+
+	return blder.Complete(r)
 }
@@ -5,12 +5,16 @@ import (
 	"time"
 
 	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
@@ -73,9 +77,11 @@ var _ = Describe("Model Controller", func() {
 			}, time.Minute, time.Second).Should(Succeed())
 
 			By("Reconciling the custom resource created")
+			recorder := record.NewFakeRecorder(100)
 			modelReconciler := &ModelReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
 			}
 
 			_, err = modelReconciler.Reconcile(ctx, reconcile.Request{
@@ -88,6 +94,103 @@ var _ = Describe("Model Controller", func() {
 				found := &appsv1.StatefulSet{}
 				return k8sClient.Get(ctx, typeNamespaceName, found)
 			}, time.Minute, time.Second).Should(Succeed())
+
+			By("Checking if an Event was recorded for the StatefulSet")
+			events := []string{}
+			for len(recorder.Events) > 0 {
+				events = append(events, <-recorder.Events)
+			}
+			Expect(events).To(ContainElement("Normal Created Created StatefulSet test"))
+
+			By("Checking if the model status was populated")
+			Expect(k8sClient.Get(ctx, typeNamespaceName, modelObject)).To(Succeed())
+			Expect(modelObject.Status.URL).To(Equal("http://ms-test.test.svc:4000"))
+			Expect(modelObject.Status.ObservedGeneration).To(Equal(modelObject.Generation))
+			Expect(modelObject.Status.Selector).To(Equal("serving=test"))
+			Expect(meta.FindStatusCondition(modelObject.Status.Conditions, mlv1alpha1.ConditionReady)).NotTo(BeNil())
+			Expect(meta.IsStatusConditionTrue(modelObject.Status.Conditions, mlv1alpha1.ConditionProgressing)).To(BeTrue())
+
+			By("Updating the model spec")
+			Expect(k8sClient.Get(ctx, typeNamespaceName, modelObject)).To(Succeed())
+			modelObject.Spec.Replicas = 2
+			modelObject.Spec.Version = "0.7"
+			Expect(k8sClient.Update(ctx, modelObject)).To(Succeed())
+
+			_, err = modelReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespaceName,
+			})
+			Expect(err).To(Not(HaveOccurred()))
+
+			By("Checking if the Statefulset follows the new spec")
+			statefulset := &appsv1.StatefulSet{}
+			Expect(k8sClient.Get(ctx, typeNamespaceName, statefulset)).To(Succeed())
+			Expect(*statefulset.Spec.Replicas).To(Equal(int32(2)))
+			Expect(statefulset.Spec.Template.Spec.Containers[0].Image).To(Equal("plasmashadow/model_serving:0.7"))
+			Expect(statefulset.Spec.Template.Annotations).To(HaveKeyWithValue(model.VersionAnnotation, "0.7"))
+			Expect(statefulset.Spec.Template.Spec.TopologySpreadConstraints).To(HaveLen(1))
+			Expect(statefulset.Spec.Template.Spec.TopologySpreadConstraints[0].TopologyKey).To(Equal(corev1.LabelTopologyZone))
+			Expect(statefulset.Spec.Template.Spec.Containers[0].ReadinessProbe).NotTo(BeNil())
+			Expect(statefulset.Spec.Template.Spec.Containers[0].StartupProbe).NotTo(BeNil())
+
+			By("Moving inline credentials into a managed Secret")
+			Expect(k8sClient.Get(ctx, typeNamespaceName, modelObject)).To(Succeed())
+			modelObject.Spec.Accesskey = "access"
+			modelObject.Spec.SecretKey = "secret"
+			Expect(k8sClient.Update(ctx, modelObject)).To(Succeed())
+
+			_, err = modelReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespaceName,
+			})
+			Expect(err).To(Not(HaveOccurred()))
+
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "cred-test", Namespace: "test"}, secret)).To(Succeed())
+			Expect(string(secret.Data["secret_key"])).To(Equal("secret"))
+
+			configMap := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "cf-test", Namespace: "test"}, configMap)).To(Succeed())
+			Expect(configMap.Data).NotTo(HaveKey("secret_key"))
+
+			By("Handing the credentials to the fetcher only")
+			Expect(k8sClient.Get(ctx, typeNamespaceName, statefulset)).To(Succeed())
+			for _, env := range statefulset.Spec.Template.Spec.Containers[0].Env {
+				Expect(env.ValueFrom == nil || env.ValueFrom.SecretKeyRef == nil).To(BeTrue(), "%s is read from a Secret", env.Name)
+			}
+			Expect(statefulset.Spec.Template.Spec.InitContainers).To(HaveLen(1))
+			Expect(statefulset.Spec.Template.Spec.InitContainers[0].Name).To(Equal(model.FetcherContainer))
+			secretVolumes := []string{}
+			for _, volume := range statefulset.Spec.Template.Spec.Volumes {
+				if volume.Secret != nil {
+					secretVolumes = append(secretVolumes, volume.Secret.SecretName)
+				}
+			}
+			Expect(secretVolumes).To(ConsistOf("cred-test"))
+
+			By("Reverting a manual change to the Statefulset image")
+			statefulset.Spec.Template.Spec.Containers[0].Image = "plasmashadow/model_serving:latest"
+			Expect(k8sClient.Update(ctx, statefulset)).To(Succeed())
+
+			_, err = modelReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespaceName,
+			})
+			Expect(err).To(Not(HaveOccurred()))
+
+			Expect(k8sClient.Get(ctx, typeNamespaceName, statefulset)).To(Succeed())
+			Expect(statefulset.Spec.Template.Spec.Containers[0].Image).To(Equal("plasmashadow/model_serving:0.7"))
+
+			By("Running the cleanup policy when the model is deleted")
+			Expect(k8sClient.Get(ctx, typeNamespaceName, modelObject)).To(Succeed())
+			Expect(modelObject.Finalizers).To(ContainElement("ml.kalkyai.com/cleanup"))
+			Expect(k8sClient.Delete(ctx, modelObject)).To(Succeed())
+
+			_, err = modelReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespaceName,
+			})
+			Expect(err).To(Not(HaveOccurred()))
+
+			Eventually(func() bool {
+				return apierrors.IsNotFound(k8sClient.Get(ctx, typeNamespaceName, &mlv1alpha1.Model{}))
+			}, time.Minute, time.Second).Should(BeTrue())
 		})
 
 	})
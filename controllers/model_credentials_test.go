@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+func TestReconcileCredentialsKeepsForeignSecret(t *testing.T) {
+	model_serving := newTestModel()
+	model_serving.Spec.Accesskey = "access"
+	model_serving.Spec.SecretKey = "secret"
+	foreign := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cred-iris", Namespace: "team"},
+		Data:       map[string][]byte{"token": []byte("mine")},
+	}
+	r := newTestReconciler(t, model_serving, foreign)
+
+	if _, err := r.Reconcile(context.Background(), requestFor(model_serving)); err == nil {
+		t.Fatalf("reconcile took over a Secret the model does not own")
+	}
+
+	getObject(t, r, foreign)
+	if string(foreign.Data["token"]) != "mine" || len(foreign.Data) != 1 {
+		t.Errorf("foreign Secret was overwritten: %v", foreign.Data)
+	}
+	if !hasEvent(recordedEvents(r), corev1.EventTypeWarning, reasonCredentialsConflict) {
+		t.Errorf("no %s Event", reasonCredentialsConflict)
+	}
+	getObject(t, r, model_serving)
+	if !meta.IsStatusConditionTrue(model_serving.Status.Conditions, mlv1alpha1.ConditionDegraded) {
+		t.Errorf("model is not degraded: %v", model_serving.Status.Conditions)
+	}
+}
+
+func TestReconcileCredentialsUpdatesOwnedSecret(t *testing.T) {
+	model_serving := newTestModel()
+	model_serving.Spec.Accesskey = "access"
+	model_serving.Spec.SecretKey = "secret"
+	r := newTestReconciler(t, model_serving)
+	reconcileModel(t, r, model_serving)
+
+	getObject(t, r, model_serving)
+	model_serving.Spec.SecretKey = "rotated"
+	if err := r.Update(context.Background(), model_serving); err != nil {
+		t.Fatal(err)
+	}
+	reconcileModel(t, r, model_serving)
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "cred-iris", Namespace: "team"}}
+	getObject(t, r, secret)
+	if string(secret.Data["secret_key"]) != "rotated" {
+		t.Errorf("secret_key = %s", secret.Data["secret_key"])
+	}
+}
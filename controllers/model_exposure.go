@@ -0,0 +1,220 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+)
+
+const reasonGatewayAPIUnavailable = "GatewayAPIUnavailable"
+
+// modelExposure maps spec.exposure onto the model builder.
+func modelExposure(model_serving *mlv1alpha1.Model) *model.Exposure {
+	exposure := model_serving.Spec.Exposure
+	if exposure == nil {
+		return nil
+	}
+	mod := &model.Exposure{
+		Host:          exposure.Host,
+		Path:          exposure.Path,
+		TLSSecretName: exposure.TLSSecretName,
+		Annotations:   exposure.Annotations,
+		ClassName:     exposure.ClassName,
+	}
+	if gateway := exposure.Gateway; gateway != nil {
+		mod.GatewayName = gateway.Name
+		mod.GatewayNamespace = gateway.Namespace
+		mod.GatewaySectionName = gateway.SectionName
+	}
+	return mod
+}
+
+// exposureKind is the kind of route publishing the model, empty for none.
+func exposureKind(model_serving *mlv1alpha1.Model) mlv1alpha1.ExposureKind {
+	exposure := model_serving.Spec.Exposure
+	if exposure == nil {
+		return ""
+	}
+	if exposure.Kind == "" {
+		return mlv1alpha1.ExposureIngress
+	}
+	return exposure.Kind
+}
+
+// reconcileExposure creates or updates the Ingress or HTTPRoute publishing
+// the model, removes the one of the other kind and records the external URL.
+func (r *ModelReconciler) reconcileExposure(ctx context.Context, model_serving *mlv1alpha1.Model, mod *model.ModelServing) error {
+	kind := exposureKind(model_serving)
+
+	if kind != mlv1alpha1.ExposureIngress {
+		ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: model_serving.Namespace, Name: mod.RouteName()}}
+		if err := r.deleteOwned(ctx, model_serving, "Ingress", ingress); err != nil {
+			return err
+		}
+	}
+	if kind != mlv1alpha1.ExposureHTTPRoute {
+		route := &unstructured.Unstructured{}
+		route.SetGroupVersionKind(model.HTTPRouteGVK)
+		route.SetNamespace(model_serving.Namespace)
+		route.SetName(mod.RouteName())
+		if err := r.deleteOwned(ctx, model_serving, "HTTPRoute", route); err != nil {
+			return err
+		}
+	}
+
+	model_serving.Status.ExternalURL = ""
+	switch kind {
+	case mlv1alpha1.ExposureIngress:
+		if err := r.reconcileIngress(ctx, model_serving, mod.CreateIngress(ctx)); err != nil {
+			return err
+		}
+	case mlv1alpha1.ExposureHTTPRoute:
+		if err := r.reconcileHTTPRoute(ctx, model_serving, mod.CreateHTTPRoute(ctx)); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	model_serving.Status.ExternalURL = mod.ExternalURL()
+	return nil
+}
+
+func (r *ModelReconciler) reconcileIngress(ctx context.Context, model_serving *mlv1alpha1.Model, desired *networkingv1.Ingress) error {
+	if err := ctrl.SetControllerReference(model_serving, desired, r.Scheme); err != nil {
+		return err
+	}
+	hash := model.Hash([]interface{}{desired.Spec, desired.Annotations})
+	setHashAnnotation(desired, hash)
+
+	found := &networkingv1.Ingress{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), found)
+	if apierrors.IsNotFound(err) {
+		return r.created(ctx, model_serving, "Ingress", desired)
+	}
+	if err != nil {
+		return err
+	}
+	if err := r.checkOwner(model_serving, "Ingress", found); err != nil {
+		return err
+	}
+
+	if found.Annotations[model.HashAnnotation] == hash &&
+		equality.Semantic.DeepDerivative(desired.Spec, found.Spec) {
+		return nil
+	}
+
+	found.Annotations = mergeAnnotations(found.Annotations, desired.Annotations)
+	found.Spec = desired.Spec
+	return r.updated(ctx, model_serving, "Ingress", found)
+}
+
+func (r *ModelReconciler) reconcileHTTPRoute(ctx context.Context, model_serving *mlv1alpha1.Model, desired *unstructured.Unstructured) error {
+	err := r.reconcileUnstructured(ctx, model_serving, desired)
+	if meta.IsNoMatchError(err) {
+		r.Recorder.Event(model_serving, corev1.EventTypeWarning, reasonGatewayAPIUnavailable,
+			"Cannot expose the model with an HTTPRoute, the Gateway API is not installed")
+		return fmt.Errorf("exposing model: %w", err)
+	}
+	return err
+}
+
+// reconcileUnstructured creates or updates an object whose types are not a
+// dependency of the operator, comparing its spec and annotations.
+func (r *ModelReconciler) reconcileUnstructured(ctx context.Context, model_serving *mlv1alpha1.Model, desired *unstructured.Unstructured) error {
+	kind := desired.GetKind()
+	if err := ctrl.SetControllerReference(model_serving, desired, r.Scheme); err != nil {
+		return err
+	}
+	hash := model.Hash([]interface{}{desired.Object["spec"], desired.GetAnnotations(), desired.GetLabels()})
+	setHashAnnotation(desired, hash)
+
+	found := &unstructured.Unstructured{}
+	found.SetGroupVersionKind(desired.GroupVersionKind())
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), found)
+	if apierrors.IsNotFound(err) {
+		return r.created(ctx, model_serving, kind, desired)
+	}
+	if err != nil {
+		return err
+	}
+	if err := r.checkOwner(model_serving, kind, found); err != nil {
+		return err
+	}
+
+	if found.GetAnnotations()[model.HashAnnotation] == hash &&
+		equality.Semantic.DeepDerivative(desired.Object["spec"], found.Object["spec"]) {
+		return nil
+	}
+
+	found.SetAnnotations(mergeAnnotations(found.GetAnnotations(), desired.GetAnnotations()))
+	found.SetLabels(mergeAnnotations(found.GetLabels(), desired.GetLabels()))
+	found.Object["spec"] = desired.Object["spec"]
+	return r.updated(ctx, model_serving, kind, found)
+}
+
+// checkOwner refuses to take over an object someone else created under
+// the name the model uses.
+func (r *ModelReconciler) checkOwner(model_serving *mlv1alpha1.Model, kind string, found client.Object) error {
+	if metav1.IsControlledBy(found, model_serving) {
+		return nil
+	}
+	return fmt.Errorf("%s %s exists and is not owned by model %s", kind, found.GetName(), model_serving.Name)
+}
+
+// deleteOwned deletes obj of the given kind, found by its namespace and
+// name, when the model controls it. A type that is not installed means there is nothing to delete.
+func (r *ModelReconciler) deleteOwned(ctx context.Context, model_serving *mlv1alpha1.Model, kind string, obj client.Object) error {
+	err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+	if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(obj, model_serving) {
+		return nil
+	}
+	log.FromContext(ctx).Info("Deleting unused object", "kind", kind, "name", obj.GetName())
+	return client.IgnoreNotFound(r.Delete(ctx, obj))
+}
+
+// mergeAnnotations sets the desired annotations on top of the existing ones,
+// keeping those added by other controllers.
+func mergeAnnotations(existing, desired map[string]string) map[string]string {
+	if existing == nil {
+		existing = map[string]string{}
+	}
+	for key, value := range desired {
+		existing[key] = value
+	}
+	return existing
+}
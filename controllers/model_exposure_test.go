@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+)
+
+func routeOf(model_serving *mlv1alpha1.Model) *unstructured.Unstructured {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(model.HTTPRouteGVK)
+	route.SetNamespace(model_serving.Namespace)
+	route.SetName("ms-" + model_serving.Name)
+	return route
+}
+
+func ingressOf(model_serving *mlv1alpha1.Model) *networkingv1.Ingress {
+	return &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "ms-" + model_serving.Name, Namespace: model_serving.Namespace}}
+}
+
+// updateExposure replaces spec.exposure of the stored model.
+func updateExposure(t *testing.T, r *ModelReconciler, model_serving *mlv1alpha1.Model, exposure *mlv1alpha1.Exposure) {
+	t.Helper()
+	getObject(t, r, model_serving)
+	model_serving.Spec.Exposure = exposure
+	if err := r.Update(context.Background(), model_serving); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectDeleted(t *testing.T, r *ModelReconciler, obj client.Object) {
+	t.Helper()
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(obj), obj); !apierrors.IsNotFound(err) {
+		t.Errorf("%s was not deleted: %v", obj.GetName(), err)
+	}
+}
+
+func TestIngressExposure(t *testing.T) {
+	model_serving := newTestModel()
+	model_serving.Spec.Exposure = &mlv1alpha1.Exposure{
+		Host:          "models.example.com",
+		Path:          "/iris",
+		TLSSecretName: "models-tls",
+		ClassName:     "nginx",
+		Annotations:   map[string]string{"nginx.ingress.kubernetes.io/proxy-body-size": "10m"},
+	}
+	r := newTestReconciler(t, model_serving)
+
+	reconcileModel(t, r, model_serving)
+
+	ingress := ingressOf(model_serving)
+	getObject(t, r, ingress)
+	if !metav1.IsControlledBy(ingress, model_serving) {
+		t.Error("Ingress is not owned by the model")
+	}
+	if ingress.Annotations["nginx.ingress.kubernetes.io/proxy-body-size"] != "10m" {
+		t.Errorf("annotations = %v", ingress.Annotations)
+	}
+	if ingress.Spec.IngressClassName == nil || *ingress.Spec.IngressClassName != "nginx" {
+		t.Errorf("class = %v", ingress.Spec.IngressClassName)
+	}
+	if len(ingress.Spec.TLS) != 1 || ingress.Spec.TLS[0].SecretName != "models-tls" || ingress.Spec.TLS[0].Hosts[0] != "models.example.com" {
+		t.Errorf("tls = %+v", ingress.Spec.TLS)
+	}
+	rule := ingress.Spec.Rules[0]
+	path := rule.HTTP.Paths[0]
+	if rule.Host != "models.example.com" || path.Path != "/iris" || *path.PathType != networkingv1.PathTypePrefix {
+		t.Errorf("rule = %s%s", rule.Host, path.Path)
+	}
+	if backend := path.Backend.Service; backend.Name != "ms-iris" || backend.Port.Number != 4000 {
+		t.Errorf("backend = %+v", backend)
+	}
+	getObject(t, r, model_serving)
+	if model_serving.Status.ExternalURL != "https://models.example.com/iris" {
+		t.Errorf("external URL = %s", model_serving.Status.ExternalURL)
+	}
+
+	updateExposure(t, r, model_serving, &mlv1alpha1.Exposure{Host: "iris.example.com"})
+	reconcileModel(t, r, model_serving)
+	getObject(t, r, ingress)
+	if path := ingress.Spec.Rules[0].HTTP.Paths[0].Path; ingress.Spec.Rules[0].Host != "iris.example.com" || path != "/" || ingress.Spec.TLS != nil {
+		t.Errorf("Ingress not updated: %+v", ingress.Spec)
+	}
+
+	updateExposure(t, r, model_serving, nil)
+	reconcileModel(t, r, model_serving)
+	expectDeleted(t, r, ingress)
+	getObject(t, r, model_serving)
+	if model_serving.Status.ExternalURL != "" {
+		t.Errorf("external URL = %s after the exposure was removed", model_serving.Status.ExternalURL)
+	}
+}
+
+func TestHTTPRouteExposure(t *testing.T) {
+	model_serving := newTestModel()
+	model_serving.Spec.Exposure = &mlv1alpha1.Exposure{
+		Kind:    mlv1alpha1.ExposureHTTPRoute,
+		Host:    "models.example.com",
+		Path:    "/iris",
+		Gateway: &mlv1alpha1.GatewayReference{Name: "public", Namespace: "gateways"},
+	}
+	r := newTestReconciler(t, model_serving)
+
+	reconcileModel(t, r, model_serving)
+
+	route := routeOf(model_serving)
+	getObject(t, r, route)
+	if !metav1.IsControlledBy(route, model_serving) {
+		t.Error("HTTPRoute is not owned by the model")
+	}
+	parents, _, _ := unstructured.NestedSlice(route.Object, "spec", "parentRefs")
+	if len(parents) != 1 || parents[0].(map[string]interface{})["name"] != "public" || parents[0].(map[string]interface{})["namespace"] != "gateways" {
+		t.Errorf("parents = %v", parents)
+	}
+	hostnames, _, _ := unstructured.NestedStringSlice(route.Object, "spec", "hostnames")
+	if len(hostnames) != 1 || hostnames[0] != "models.example.com" {
+		t.Errorf("hostnames = %v", hostnames)
+	}
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+	rule := rules[0].(map[string]interface{})
+	if value, _, _ := unstructured.NestedString(rule["matches"].([]interface{})[0].(map[string]interface{}), "path", "value"); value != "/iris" {
+		t.Errorf("path = %s", value)
+	}
+	backend := rule["backendRefs"].([]interface{})[0].(map[string]interface{})
+	if backend["name"] != "ms-iris" || backend["port"] != int64(4000) {
+		t.Errorf("backend = %v", backend)
+	}
+	getObject(t, r, model_serving)
+	if model_serving.Status.ExternalURL != "http://models.example.com/iris" {
+		t.Errorf("external URL = %s", model_serving.Status.ExternalURL)
+	}
+
+	// Switching to an Ingress replaces the HTTPRoute.
+	updateExposure(t, r, model_serving, &mlv1alpha1.Exposure{Host: "models.example.com", Path: "/iris"})
+	reconcileModel(t, r, model_serving)
+	expectDeleted(t, r, routeOf(model_serving))
+	getObject(t, r, ingressOf(model_serving))
+
+	updateExposure(t, r, model_serving, nil)
+	reconcileModel(t, r, model_serving)
+	expectDeleted(t, r, ingressOf(model_serving))
+}
+
+// withoutGatewayAPI is a client of a cluster without the Gateway API.
+type withoutGatewayAPI struct {
+	client.Client
+}
+
+func (c withoutGatewayAPI) noMatch(obj client.Object) error {
+	if u, ok := obj.(*unstructured.Unstructured); ok && u.GroupVersionKind() == model.HTTPRouteGVK {
+		return &meta.NoKindMatchError{GroupKind: model.HTTPRouteGVK.GroupKind(), SearchedVersions: []string{model.HTTPRouteGVK.Version}}
+	}
+	return nil
+}
+
+func (c withoutGatewayAPI) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if err := c.noMatch(obj); err != nil {
+		return err
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c withoutGatewayAPI) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.noMatch(obj); err != nil {
+		return err
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestExposureWithoutGatewayAPI(t *testing.T) {
+	exposed := newTestModel()
+	exposed.Spec.Exposure = &mlv1alpha1.Exposure{Host: "models.example.com"}
+	routed := newTestModel()
+	routed.Name = "wine"
+	routed.Spec.Exposure = &mlv1alpha1.Exposure{Kind: mlv1alpha1.ExposureHTTPRoute, Host: "models.example.com", Gateway: &mlv1alpha1.GatewayReference{Name: "public"}}
+	r := newTestReconciler(t, exposed, routed)
+	r.Client = withoutGatewayAPI{r.Client}
+
+	// Models exposed with an Ingress do not need the Gateway API.
+	reconcileModel(t, r, exposed)
+	getObject(t, r, ingressOf(exposed))
+
+	if _, err := r.Reconcile(context.Background(), requestFor(routed)); err == nil {
+		t.Fatal("exposed the model with an HTTPRoute without the Gateway API")
+	}
+	if !hasEvent(recordedEvents(r), corev1.EventTypeWarning, reasonGatewayAPIUnavailable) {
+		t.Errorf("no %s Event", reasonGatewayAPIUnavailable)
+	}
+}
+
+func TestExposureNotOwned(t *testing.T) {
+	model_serving := newTestModel()
+	model_serving.Spec.Exposure = &mlv1alpha1.Exposure{Host: "models.example.com"}
+	foreign := ingressOf(model_serving)
+	foreign.Spec.DefaultBackend = &networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: "website"}}
+	r := newTestReconciler(t, model_serving, foreign)
+
+	if _, err := r.Reconcile(context.Background(), requestFor(model_serving)); err == nil {
+		t.Fatal("took over an Ingress the model does not own")
+	}
+	getObject(t, r, foreign)
+	if foreign.Spec.DefaultBackend == nil || len(foreign.Spec.Rules) != 0 {
+		t.Errorf("Ingress the model does not own was changed: %+v", foreign.Spec)
+	}
+	getObject(t, r, model_serving)
+	if !meta.IsStatusConditionTrue(model_serving.Status.Conditions, mlv1alpha1.ConditionDegraded) {
+		t.Errorf("conditions = %+v, want the model degraded", model_serving.Status.Conditions)
+	}
+
+	// Removing the exposure leaves the Ingress alone.
+	updateExposure(t, r, model_serving, nil)
+	reconcileModel(t, r, model_serving)
+	getObject(t, r, foreign)
+}
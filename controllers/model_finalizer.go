@@ -0,0 +1,164 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	"github.com/kalkyai/model-serving-operator/pkg/storage"
+)
+
+// cleanupFinalizer holds a deleted Model until its cleanup policy has run.
+const cleanupFinalizer = "ml.kalkyai.com/cleanup"
+
+// artifactCleanupTimeout is how long a deleted model waits for its storage
+// to become reachable again before the artifact is retained, so an outage
+// does not hold the model, and its namespace, in Terminating.
+const artifactCleanupTimeout = 10 * time.Minute
+
+// cleanupPolicy returns the model's cleanup policy with defaults applied.
+func cleanupPolicy(model_serving *mlv1alpha1.Model) mlv1alpha1.CleanupPolicy {
+	policy := mlv1alpha1.CleanupPolicy{Volumes: mlv1alpha1.DeleteVolumes}
+	if model_serving.Spec.Cleanup != nil {
+		policy = *model_serving.Spec.Cleanup
+		if policy.Volumes == "" {
+			policy.Volumes = mlv1alpha1.DeleteVolumes
+		}
+	}
+	return policy
+}
+
+// reconcileDelete runs the cleanup policy of a deleted model and releases
+// the finalizer. The owned StatefulSet, Service and ConfigMap are left to
+// the garbage collector, which only removes them once the finalizer is gone.
+func (r *ModelReconciler) reconcileDelete(ctx context.Context, model_serving *mlv1alpha1.Model) (ctrl.Result, error) {
+	ctrllog := log.FromContext(ctx)
+
+	if !controllerutil.ContainsFinalizer(model_serving, cleanupFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	policy := cleanupPolicy(model_serving)
+	removed := []string{}
+
+	volumes, err := r.cleanupVolumes(ctx, model_serving, policy.Volumes)
+	if err != nil {
+		ctrllog.Error(err, "Failed to clean up volumes")
+		return ctrl.Result{}, err
+	}
+	if len(volumes) > 0 {
+		removed = append(removed, fmt.Sprintf("volumes %s", strings.Join(volumes, ", ")))
+	}
+
+	if policy.DeleteArtifact {
+		uri := storage.URI(artifactStorage(model_serving))
+		err := r.deleteArtifact(ctx, model_serving)
+		switch {
+		case errors.Is(err, storage.ErrNotSupported):
+			ctrllog.Info("Storage backend cannot delete artifacts, retaining it", "artifact", uri)
+			r.Recorder.Eventf(model_serving, corev1.EventTypeWarning, "CleanupSkipped",
+				"Retained artifact %s, its storage backend does not support deleting", uri)
+		case err != nil && (permanentCleanupError(err) ||
+			time.Since(model_serving.DeletionTimestamp.Time) > artifactCleanupTimeout):
+			ctrllog.Error(err, "Failed to delete model artifact, retaining it", "artifact", uri)
+			r.Recorder.Eventf(model_serving, corev1.EventTypeWarning, "CleanupFailed",
+				"Retained artifact %s, deleting it failed: %s", uri, err)
+		case err != nil:
+			ctrllog.Error(err, "Failed to delete model artifact")
+			r.Recorder.Eventf(model_serving, corev1.EventTypeWarning, "CleanupFailed",
+				"Failed to delete artifact %s: %s", uri, err)
+			return ctrl.Result{}, err
+		default:
+			removed = append(removed, fmt.Sprintf("artifact %s", uri))
+		}
+	}
+
+	if len(removed) > 0 {
+		r.Recorder.Eventf(model_serving, corev1.EventTypeNormal, "CleanedUp", "Deleted %s", strings.Join(removed, " and "))
+	} else {
+		r.Recorder.Event(model_serving, corev1.EventTypeNormal, "CleanedUp", "Retained volumes and artifact")
+	}
+
+	controllerutil.RemoveFinalizer(model_serving, cleanupFinalizer)
+	return ctrl.Result{}, r.Update(ctx, model_serving)
+}
+
+// cleanupVolumes deletes the model's volume claims when the policy says so
+// and returns their names. The StatefulSet controller labels the claims it
+// creates with the pod selector.
+func (r *ModelReconciler) cleanupVolumes(ctx context.Context, model_serving *mlv1alpha1.Model, policy mlv1alpha1.VolumeRetentionPolicy) ([]string, error) {
+	if policy != mlv1alpha1.DeleteVolumes {
+		return nil, nil
+	}
+
+	claims := &corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, claims,
+		client.InNamespace(model_serving.Namespace),
+		client.MatchingLabels{"serving": model_serving.Name},
+	); err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for i := range claims.Items {
+		claim := &claims.Items[i]
+		if err := client.IgnoreNotFound(r.Delete(ctx, claim)); err != nil {
+			return nil, err
+		}
+		names = append(names, claim.Name)
+	}
+	return names, nil
+}
+
+// permanentCleanupError reports whether deleting the artifact would fail
+// again however often it is retried: the credentials Secret is gone, as
+// during a namespace teardown, or the store refused the request.
+func permanentCleanupError(err error) bool {
+	if apierrors.IsNotFound(err) {
+		return true
+	}
+	var e *storage.Error
+	return errors.As(err, &e) && e.StatusCode >= 400 && e.StatusCode < 500 &&
+		e.StatusCode != http.StatusRequestTimeout && e.StatusCode != http.StatusTooManyRequests
+}
+
+// deleteArtifact removes the model artifact from its storage.
+func (r *ModelReconciler) deleteArtifact(ctx context.Context, model_serving *mlv1alpha1.Model) error {
+	backend, err := storage.New(ctx, artifactStorage(model_serving), r.secrets(model_serving), storage.Options{})
+	if err != nil {
+		return err
+	}
+
+	err = backend.Delete(ctx)
+	if storage.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
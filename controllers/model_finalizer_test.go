@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+func TestReconcileDeleteRetainsUndeletableArtifact(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		secret    bool
+		deletedAt time.Duration
+		released  bool
+	}{
+		{name: "credentials deleted", status: http.StatusNoContent, released: true},
+		{name: "access denied", status: http.StatusForbidden, secret: true, released: true},
+		{name: "store unavailable", status: http.StatusServiceUnavailable, secret: true, released: false},
+		{name: "store unavailable for too long", status: http.StatusServiceUnavailable, secret: true,
+			deletedAt: artifactCleanupTimeout + time.Minute, released: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			model_serving := newTestModel()
+			model_serving.Spec.Endpoint = server.URL
+			model_serving.Spec.CredentialsSecretRef = &mlv1alpha1.CredentialsSecretRef{Name: "models"}
+			model_serving.Spec.Cleanup = &mlv1alpha1.CleanupPolicy{DeleteArtifact: true}
+			model_serving.Finalizers = []string{cleanupFinalizer}
+			model_serving.DeletionTimestamp = &metav1.Time{Time: time.Now().Add(-tt.deletedAt)}
+
+			objs := []client.Object{model_serving}
+			if tt.secret {
+				objs = append(objs, &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "models", Namespace: "team"},
+					Data:       map[string][]byte{"access_key": []byte("a"), "secret_key": []byte("s")},
+				})
+			}
+			r := newTestReconciler(t, objs...)
+
+			_, err := r.Reconcile(context.Background(), requestFor(model_serving))
+			gone := apierrors.IsNotFound(r.Get(context.Background(), client.ObjectKeyFromObject(model_serving), &mlv1alpha1.Model{}))
+			if gone != tt.released {
+				t.Errorf("finalizer released = %v, want %v (reconcile error %v)", gone, tt.released, err)
+			}
+			if tt.released && err != nil {
+				t.Errorf("reconcile: %v", err)
+			}
+			if !hasEvent(recordedEvents(r), corev1.EventTypeWarning, "CleanupFailed") {
+				t.Errorf("no CleanupFailed Event")
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+)
+
+const reasonActivatorUnavailable = "ActivatorUnavailable"
+
+// lastRequest is when the activator last saw a request for the model, or
+// when the model was created if it has not seen one yet.
+func lastRequest(model_serving *mlv1alpha1.Model) time.Time {
+	last := model_serving.CreationTimestamp.Time
+	if value, ok := model_serving.Annotations[mlv1alpha1.LastRequestAnnotation]; ok {
+		if seen, err := time.Parse(time.RFC3339, value); err == nil && seen.After(last) {
+			last = seen
+		}
+	}
+	return last
+}
+
+// requestAnnotations record the requests a model serves.
+var requestAnnotations = []string{mlv1alpha1.LastRequestAnnotation, mlv1alpha1.RequestCountAnnotation}
+
+// withoutRequests returns a copy of the model without its request
+// annotations and the metadata every update changes.
+func withoutRequests(model_serving *mlv1alpha1.Model) *mlv1alpha1.Model {
+	model_serving = model_serving.DeepCopy()
+	for _, key := range requestAnnotations {
+		delete(model_serving.Annotations, key)
+	}
+	if len(model_serving.Annotations) == 0 {
+		model_serving.Annotations = nil
+	}
+	model_serving.ResourceVersion = ""
+	model_serving.ManagedFields = nil
+	return model_serving
+}
+
+// requestsRecorded drops the updates of a serving model that only record
+// requests on it. They come with every batch of requests, and the model is
+// reconciled anyway by the time it may go idle. Requests recorded on an idle
+// model pass, they wake it up.
+var requestsRecorded = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldModel, ok := e.ObjectOld.(*mlv1alpha1.Model)
+		if !ok {
+			return true
+		}
+		newModel, ok := e.ObjectNew.(*mlv1alpha1.Model)
+		if !ok {
+			return true
+		}
+		if meta.IsStatusConditionTrue(oldModel.Status.Conditions, mlv1alpha1.ConditionIdle) {
+			return true
+		}
+		return !equality.Semantic.DeepEqual(withoutRequests(oldModel), withoutRequests(newModel))
+	},
+}
+
+// idleFor reports whether the model went without requests for its idle
+// timeout and, when it did not, how long until it does.
+func idleFor(model_serving *mlv1alpha1.Model, now time.Time) (bool, time.Duration) {
+	timeout := model_serving.Spec.IdleTimeout
+	if timeout == nil {
+		return false, 0
+	}
+	remaining := lastRequest(model_serving).Add(timeout.Duration).Sub(now)
+	if remaining <= 0 {
+		return true, 0
+	}
+	return false, remaining
+}
+
+// readyServingPods lists the ready serving pods of the model, of both tracks.
+func (r *ModelReconciler) readyServingPods(ctx context.Context, model_serving *mlv1alpha1.Model) ([]corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods,
+		client.InNamespace(model_serving.Namespace),
+		client.MatchingLabels{"serving": model_serving.Name},
+	); err != nil {
+		return nil, err
+	}
+	ready := []corev1.Pod{}
+	for _, pod := range pods.Items {
+		if pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				ready = append(ready, pod)
+			}
+		}
+	}
+	return ready, nil
+}
+
+// countRequests reads the requests the ready serving pods of a model with an
+// idle timeout served, and records a request on the model when their count
+// changed since it was last read: requests sent to the pods directly do not
+// reach the activator. It returns the pods when their metrics could be read,
+// so requests can be sent to them directly.
+func (r *ModelReconciler) countRequests(ctx context.Context, model_serving *mlv1alpha1.Model, mod *model.ModelServing) ([]corev1.Pod, error) {
+	if model_serving.Spec.IdleTimeout == nil {
+		return nil, nil
+	}
+	pods, err := r.readyServingPods(ctx, model_serving)
+	if err != nil || len(pods) == 0 {
+		return nil, err
+	}
+	counts, err := podRequests(ctx, model_serving, mod.Port, pods)
+	if err != nil {
+		log.FromContext(ctx).Info("Requests go through the activator, the serving pods have no readable request metrics", "error", err.Error())
+		return nil, nil
+	}
+
+	count := strconv.FormatFloat(counts.total, 'f', -1, 64)
+	if model_serving.Annotations[mlv1alpha1.RequestCountAnnotation] == count {
+		return pods, nil
+	}
+	if model_serving.Annotations == nil {
+		model_serving.Annotations = map[string]string{}
+	}
+	// The count resets when pods restart, any change means requests.
+	model_serving.Annotations[mlv1alpha1.LastRequestAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	model_serving.Annotations[mlv1alpha1.RequestCountAnnotation] = count
+	status := model_serving.Status
+	if err := r.Update(ctx, model_serving); err != nil {
+		return nil, err
+	}
+	// Update returns the stored status, which does not have our changes yet.
+	model_serving.Status = status
+	return pods, nil
+}
+
+// reconcileActivatorEndpoints fills the Endpoints of the selectorless Service
+// of a model with an idle timeout. They point at the ready serving pods when
+// they may be sent requests directly, and at the activator otherwise, which
+// holds requests while the model scales up.
+func (r *ModelReconciler) reconcileActivatorEndpoints(ctx context.Context, model_serving *mlv1alpha1.Model, mod *model.ModelServing, pods []corev1.Pod) error {
+	if !mod.ViaActivator {
+		// The endpoints controller takes the Endpoints over again once the
+		// Service has a selector.
+		return nil
+	}
+
+	if len(pods) > 0 {
+		return r.reconcileEndpoints(ctx, model_serving, mod.CreatePodEndpoints(ctx, pods))
+	}
+
+	activator := &corev1.Endpoints{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: r.Activator.Namespace, Name: r.Activator.Service}, activator)
+	if apierrors.IsNotFound(err) {
+		r.Recorder.Eventf(model_serving, corev1.EventTypeWarning, reasonActivatorUnavailable,
+			"Activator Service %s/%s not found, requests to the model fail until it is deployed", r.Activator.Namespace, r.Activator.Service)
+	} else if err != nil {
+		return err
+	}
+
+	return r.reconcileEndpoints(ctx, model_serving, mod.CreateActivatorEndpoints(ctx, activator))
+}
+
+// reconcileEndpoints creates or updates the Endpoints of the model Service.
+func (r *ModelReconciler) reconcileEndpoints(ctx context.Context, model_serving *mlv1alpha1.Model, desired *corev1.Endpoints) error {
+	if err := ctrl.SetControllerReference(model_serving, desired, r.Scheme); err != nil {
+		return err
+	}
+
+	found := &corev1.Endpoints{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), found)
+	if apierrors.IsNotFound(err) {
+		return r.created(ctx, model_serving, "Endpoints", desired)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Before the Service lost its selector, the endpoints controller owned
+	// these Endpoints and filled them with the serving pods.
+	controlled := metav1.IsControlledBy(found, model_serving)
+	if controlled && equality.Semantic.DeepEqual(desired.Subsets, found.Subsets) {
+		return nil
+	}
+	if !controlled {
+		if err := ctrl.SetControllerReference(model_serving, found, r.Scheme); err != nil {
+			return err
+		}
+	}
+	// The addresses follow every ready pod, so only switching between the
+	// activator and the serving pods is recorded as an Event.
+	switched := !controlled || routesToPods(found) != routesToPods(desired)
+	found.Subsets = desired.Subsets
+	if switched {
+		return r.updated(ctx, model_serving, "Endpoints", found)
+	}
+	log.FromContext(ctx).V(1).Info("Updating Endpoints", "name", found.Name)
+	return r.Update(ctx, found)
+}
+
+// routesToPods reports whether endpoints send requests to the serving pods
+// rather than to the activator.
+func routesToPods(endpoints *corev1.Endpoints) bool {
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			if address.TargetRef != nil && address.TargetRef.Kind == "Pod" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// modelsForActivator maps the Endpoints of the activator to the Models
+// routed through it, so they follow the activator pods.
+func (r *ModelReconciler) modelsForActivator(obj client.Object) []reconcile.Request {
+	if obj.GetNamespace() != r.Activator.Namespace || obj.GetName() != r.Activator.Service {
+		return nil
+	}
+
+	ctx := context.Background()
+	models := &mlv1alpha1.ModelList{}
+	if err := r.List(ctx, models); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list models for activator")
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, item := range models.Items {
+		if item.Spec.IdleTimeout != nil {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	"github.com/kalkyai/model-serving-operator/pkg/config"
+)
+
+func TestIdleFor(t *testing.T) {
+	created := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		timeout     time.Duration
+		lastRequest string
+		now         time.Time
+		idle        bool
+		wakeIn      time.Duration
+	}{
+		{name: "no idle timeout", now: created.Add(time.Hour)},
+		{name: "no request since created", timeout: 15 * time.Minute, now: created.Add(10 * time.Minute), wakeIn: 5 * time.Minute},
+		{name: "idle since created", timeout: 15 * time.Minute, now: created.Add(20 * time.Minute), idle: true},
+		{
+			name: "recent request", timeout: 15 * time.Minute, lastRequest: "2022-06-01T12:30:00Z",
+			now: created.Add(40 * time.Minute), wakeIn: 5 * time.Minute,
+		},
+		{
+			name: "old request", timeout: 15 * time.Minute, lastRequest: "2022-06-01T12:30:00Z",
+			now: created.Add(50 * time.Minute), idle: true,
+		},
+		{
+			name: "unreadable request", timeout: 15 * time.Minute, lastRequest: "yesterday",
+			now: created.Add(20 * time.Minute), idle: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model_serving := newTestModel()
+			model_serving.CreationTimestamp = metav1.NewTime(created)
+			if tt.timeout > 0 {
+				model_serving.Spec.IdleTimeout = &metav1.Duration{Duration: tt.timeout}
+			}
+			if tt.lastRequest != "" {
+				model_serving.Annotations = map[string]string{mlv1alpha1.LastRequestAnnotation: tt.lastRequest}
+			}
+			idle, wakeIn := idleFor(model_serving, tt.now)
+			if idle != tt.idle || wakeIn != tt.wakeIn {
+				t.Errorf("idleFor = %t, %s, want %t, %s", idle, wakeIn, tt.idle, tt.wakeIn)
+			}
+		})
+	}
+}
+
+func TestRequestsRecorded(t *testing.T) {
+	serving := newTestModel()
+	serving.Spec.IdleTimeout = &metav1.Duration{Duration: 15 * time.Minute}
+	serving.ResourceVersion = "1"
+
+	recorded := serving.DeepCopy()
+	recorded.ResourceVersion = "2"
+	recorded.Annotations = map[string]string{mlv1alpha1.LastRequestAnnotation: "2022-06-01T12:30:00Z"}
+
+	changed := recorded.DeepCopy()
+	changed.Spec.Replicas = 3
+
+	idle := serving.DeepCopy()
+	idle.Status.Conditions = []metav1.Condition{{Type: mlv1alpha1.ConditionIdle, Status: metav1.ConditionTrue}}
+	woken := idle.DeepCopy()
+	woken.ResourceVersion = "2"
+	woken.Annotations = recorded.Annotations
+
+	tests := []struct {
+		name     string
+		old, new *mlv1alpha1.Model
+		want     bool
+	}{
+		{name: "request on a serving model", old: serving, new: recorded, want: false},
+		{name: "spec change", old: serving, new: changed, want: true},
+		{name: "request on an idle model", old: idle, new: woken, want: true},
+	}
+	for _, tt := range tests {
+		if got := requestsRecorded.Update(event.UpdateEvent{ObjectOld: tt.old, ObjectNew: tt.new}); got != tt.want {
+			t.Errorf("%s: update passes = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+const servedMetrics = `# TYPE http_requests_total counter
+http_requests_total{code="200"} 990
+http_requests_total{code="500"} 10
+`
+
+// newIdleModel returns a model with an idle timeout whose pods publish
+// metrics on metricsPort.
+func newIdleModel(metricsPort int32, annotations map[string]string) *mlv1alpha1.Model {
+	model_serving := newTestModel()
+	model_serving.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	model_serving.Annotations = annotations
+	model_serving.Spec.IdleTimeout = &metav1.Duration{Duration: 15 * time.Minute}
+	model_serving.Spec.Monitoring = &mlv1alpha1.Monitoring{Port: &metricsPort}
+	return model_serving
+}
+
+// newIdleReconciler returns a ModelReconciler routing idle models through
+// an activator at 10.0.0.9.
+func newIdleReconciler(t *testing.T, objs ...client.Object) *ModelReconciler {
+	t.Helper()
+	activator := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "activator", Namespace: "model-serving-system"},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{{IP: "10.0.0.9"}},
+			Ports:     []corev1.EndpointPort{{Name: "http", Port: 8012}},
+		}},
+	}
+	r := newTestReconciler(t, append(objs, activator)...)
+	r.Activator = config.Activator{Namespace: "model-serving-system", Service: "activator"}
+	return r
+}
+
+// readyPod is a ready serving pod of the model on 127.0.0.1.
+func readyPod(model_serving *mlv1alpha1.Model) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      model_serving.Name + "-0",
+			Namespace: model_serving.Namespace,
+			Labels:    map[string]string{"serving": model_serving.Name},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			PodIP:      "127.0.0.1",
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+// endpointIPs returns the addresses of the Endpoints of the model Service.
+func endpointIPs(t *testing.T, r *ModelReconciler) []string {
+	t.Helper()
+	endpoints := &corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "ms-iris", Namespace: "team"}}
+	getObject(t, r, endpoints)
+	ips := []string{}
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			ips = append(ips, address.IP)
+		}
+	}
+	return ips
+}
+
+func TestIdleModelEndpoints(t *testing.T) {
+	port := metricsServer(t, servedMetrics)
+	recent := map[string]string{mlv1alpha1.LastRequestAnnotation: time.Now().UTC().Format(time.RFC3339)}
+	withoutMetrics := newIdleModel(port, recent)
+	withoutMetrics.Spec.Monitoring.Path = "/missing"
+	tests := []struct {
+		name     string
+		model    *mlv1alpha1.Model
+		pods     bool
+		ips      []string
+		replicas int32
+	}{
+		{name: "no ready pod", model: newIdleModel(port, recent), ips: []string{"10.0.0.9"}, replicas: 1},
+		{name: "ready pods", model: newIdleModel(port, recent), pods: true, ips: []string{"127.0.0.1"}, replicas: 1},
+		{name: "ready pods without metrics", model: withoutMetrics, pods: true, ips: []string{"10.0.0.9"}, replicas: 1},
+		{
+			name: "idle",
+			model: newIdleModel(port, map[string]string{
+				mlv1alpha1.LastRequestAnnotation:  "2022-06-01T12:00:00Z",
+				mlv1alpha1.RequestCountAnnotation: "1000",
+			}),
+			pods: true, ips: []string{"10.0.0.9"}, replicas: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs := []client.Object{tt.model}
+			if tt.pods {
+				objs = append(objs, readyPod(tt.model))
+			}
+			r := newIdleReconciler(t, objs...)
+
+			reconcileModel(t, r, tt.model)
+
+			if ips := endpointIPs(t, r); len(ips) != len(tt.ips) || ips[0] != tt.ips[0] {
+				t.Errorf("endpoints = %v, want %v", ips, tt.ips)
+			}
+			statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "iris", Namespace: "team"}}
+			getObject(t, r, statefulset)
+			if *statefulset.Spec.Replicas != tt.replicas {
+				t.Errorf("replicas = %d, want %d", *statefulset.Spec.Replicas, tt.replicas)
+			}
+		})
+	}
+}
+
+func TestIdleModelNotReady(t *testing.T) {
+	model_serving := newIdleModel(metricsServer(t, servedMetrics), map[string]string{
+		mlv1alpha1.LastRequestAnnotation:  "2022-06-01T12:00:00Z",
+		mlv1alpha1.RequestCountAnnotation: "1000",
+	})
+	r := newIdleReconciler(t, model_serving)
+	reconcileModel(t, r, model_serving)
+
+	// The StatefulSet scaled to zero is rolled out.
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "iris", Namespace: "team"}}
+	getObject(t, r, statefulset)
+	statefulset.Status.ObservedGeneration = statefulset.Generation
+	statefulset.Status.CurrentRevision, statefulset.Status.UpdateRevision = "iris-1", "iris-1"
+	if err := r.Update(context.Background(), statefulset); err != nil {
+		t.Fatal(err)
+	}
+	reconcileModel(t, r, model_serving)
+
+	getObject(t, r, model_serving)
+	if !meta.IsStatusConditionTrue(model_serving.Status.Conditions, mlv1alpha1.ConditionIdle) {
+		t.Fatalf("conditions = %+v, want the model idle", model_serving.Status.Conditions)
+	}
+	ready := meta.FindStatusCondition(model_serving.Status.Conditions, mlv1alpha1.ConditionReady)
+	if ready == nil || ready.Status != metav1.ConditionFalse || ready.Reason != "Idle" {
+		t.Errorf("ready = %+v, want False while idle", ready)
+	}
+}
+
+func TestEndpointsEvents(t *testing.T) {
+	model_serving := newIdleModel(metricsServer(t, servedMetrics), map[string]string{
+		mlv1alpha1.LastRequestAnnotation: time.Now().UTC().Format(time.RFC3339),
+	})
+	first := readyPod(model_serving)
+	r := newIdleReconciler(t, model_serving, first)
+	reconcileModel(t, r, model_serving)
+	recordedEvents(r)
+
+	// Another ready pod only changes the addresses.
+	second := readyPod(model_serving)
+	second.Name = "iris-1"
+	if err := r.Create(context.Background(), second); err != nil {
+		t.Fatal(err)
+	}
+	reconcileModel(t, r, model_serving)
+	if ips := endpointIPs(t, r); len(ips) != 2 {
+		t.Errorf("endpoints = %v, want both serving pods", ips)
+	}
+	if events := recordedEvents(r); containsString(events, "Normal Updated Updated Endpoints ms-iris") {
+		t.Errorf("events = %v, want none for a new ready pod", events)
+	}
+
+	// Without ready pods requests go to the activator again.
+	for _, pod := range []*corev1.Pod{first, second} {
+		if err := r.Delete(context.Background(), pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reconcileModel(t, r, model_serving)
+	if ips := endpointIPs(t, r); len(ips) != 1 || ips[0] != "10.0.0.9" {
+		t.Errorf("endpoints = %v, want the activator", ips)
+	}
+	if events := recordedEvents(r); !containsString(events, "Normal Updated Updated Endpoints ms-iris") {
+		t.Errorf("events = %v, want one for switching to the activator", events)
+	}
+}
+
+func TestServedRequestsKeepModelUp(t *testing.T) {
+	model_serving := newIdleModel(metricsServer(t, servedMetrics), map[string]string{
+		mlv1alpha1.LastRequestAnnotation:  time.Now().Add(-20 * time.Minute).UTC().Format(time.RFC3339),
+		mlv1alpha1.RequestCountAnnotation: "500",
+	})
+	r := newIdleReconciler(t, model_serving, readyPod(model_serving))
+
+	result := reconcileModel(t, r, model_serving)
+
+	getObject(t, r, model_serving)
+	if count := model_serving.Annotations[mlv1alpha1.RequestCountAnnotation]; count != "1000" {
+		t.Errorf("request count = %s, want the requests the pods served", count)
+	}
+	if idle, _ := idleFor(model_serving, time.Now()); idle {
+		t.Error("model went idle although its pods served requests")
+	}
+	if result.RequeueAfter <= 0 || result.RequeueAfter > 15*time.Minute {
+		t.Errorf("requeue after %s, want once the model may go idle", result.RequeueAfter)
+	}
+	if ips := endpointIPs(t, r); len(ips) != 1 || ips[0] != "127.0.0.1" {
+		t.Errorf("endpoints = %v, want the serving pod", ips)
+	}
+}
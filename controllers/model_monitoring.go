@@ -0,0 +1,154 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+)
+
+// Defaults of spec.monitoring.
+const (
+	defaultMetricsPath    = "/metrics"
+	defaultAlertFor       = 5 * time.Minute
+	defaultMaxErrorRate   = "5"
+	defaultMaxLatency     = time.Second
+	defaultRequestsMetric = "http_requests_total"
+	defaultLatencyMetric  = "http_request_duration_seconds"
+)
+
+// modelMonitoring maps spec.monitoring onto the model builder, filling in
+// the defaults. It is nil when monitoring is disabled.
+func modelMonitoring(model_serving *mlv1alpha1.Model) *model.Monitoring {
+	spec := model_serving.Spec.Monitoring
+	if spec == nil {
+		spec = &mlv1alpha1.Monitoring{}
+	}
+	if spec.Disabled {
+		return nil
+	}
+
+	monitoring := &model.Monitoring{Path: defaultMetricsPath, Labels: spec.Labels}
+	if spec.Port != nil {
+		monitoring.Port = *spec.Port
+	}
+	if spec.Path != "" {
+		monitoring.Path = spec.Path
+	}
+	if spec.Interval != nil {
+		monitoring.Interval = spec.Interval.Duration
+	}
+
+	alerts := spec.Alerts
+	if alerts == nil {
+		alerts = &mlv1alpha1.ModelAlerts{}
+	}
+	if alerts.Disabled {
+		return monitoring
+	}
+	maxErrorRate := resource.MustParse(defaultMaxErrorRate)
+	if alerts.MaxErrorRate != nil {
+		maxErrorRate = *alerts.MaxErrorRate
+	}
+	monitoring.Alerts = &model.Alerts{
+		For:            defaultAlertFor,
+		MaxErrorRatio:  maxErrorRate.AsApproximateFloat64() / 100,
+		MaxLatency:     defaultMaxLatency,
+		RequestsMetric: defaultRequestsMetric,
+		LatencyMetric:  defaultLatencyMetric,
+		// Idle models and models scaled to zero have no pod to scrape.
+		AlwaysUp: model_serving.Spec.IdleTimeout == nil && model_serving.Spec.Replicas > 0,
+	}
+	if alerts.For != nil {
+		monitoring.Alerts.For = alerts.For.Duration
+	}
+	if alerts.MaxLatency != nil {
+		monitoring.Alerts.MaxLatency = alerts.MaxLatency.Duration
+	}
+	if alerts.RequestsMetric != "" {
+		monitoring.Alerts.RequestsMetric = alerts.RequestsMetric
+	}
+	if alerts.LatencyMetric != "" {
+		monitoring.Alerts.LatencyMetric = alerts.LatencyMetric
+	}
+	return monitoring
+}
+
+// reconcileMonitoring creates or updates the metrics Service, ServiceMonitor
+// and PrometheusRule of the model, and deletes those monitoring no longer
+// asks for. Nothing is done without the Prometheus Operator CRDs.
+func (r *ModelReconciler) reconcileMonitoring(ctx context.Context, model_serving *mlv1alpha1.Model, mod *model.ModelServing) error {
+	if !r.monitoringAPI {
+		return nil
+	}
+
+	monitor := &unstructured.Unstructured{}
+	monitor.SetGroupVersionKind(model.ServiceMonitorGVK)
+	rule := &unstructured.Unstructured{}
+	rule.SetGroupVersionKind(model.PrometheusRuleGVK)
+	for _, obj := range []*unstructured.Unstructured{monitor, rule} {
+		obj.SetNamespace(model_serving.Namespace)
+		obj.SetName(mod.MonitorName())
+	}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: model_serving.Namespace, Name: mod.MetricsServiceName()}}
+
+	if mod.Monitoring == nil {
+		if err := r.deleteOwned(ctx, model_serving, "PrometheusRule", rule); err != nil {
+			return err
+		}
+		if err := r.deleteOwned(ctx, model_serving, "ServiceMonitor", monitor); err != nil {
+			return err
+		}
+		return r.deleteOwned(ctx, model_serving, "Service", service)
+	}
+
+	service = mod.CreateMetricsService(ctx)
+	if err := ctrl.SetControllerReference(model_serving, service, r.Scheme); err != nil {
+		return err
+	}
+	if err := r.reconcileService(ctx, model_serving, service); err != nil {
+		return err
+	}
+	if err := r.reconcileUnstructured(ctx, model_serving, mod.CreateServiceMonitor(ctx)); err != nil {
+		return err
+	}
+	if mod.Monitoring.Alerts == nil {
+		return r.deleteOwned(ctx, model_serving, "PrometheusRule", rule)
+	}
+	return r.reconcileUnstructured(ctx, model_serving, mod.CreatePrometheusRule(ctx))
+}
+
+// hasMonitoringAPI reports whether the Prometheus Operator CRDs are
+// installed.
+func hasMonitoringAPI(mgr ctrl.Manager) bool {
+	for _, gvk := range []schema.GroupVersionKind{model.ServiceMonitorGVK, model.PrometheusRuleGVK} {
+		if _, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+			return false
+		}
+	}
+	return true
+}
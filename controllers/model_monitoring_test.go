@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+)
+
+// monitoringObject returns an empty object of kind gvk named as the monitoring
+// objects of the test model.
+func monitoringObject(gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetNamespace("team")
+	obj.SetName("ms-iris")
+	return obj
+}
+
+// metricsService returns the metrics Service of the test model.
+func metricsService() *corev1.Service {
+	return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "ms-iris-metrics", Namespace: "team"}}
+}
+
+// updateMonitoring replaces spec.monitoring of the stored model.
+func updateMonitoring(t *testing.T, r *ModelReconciler, model_serving *mlv1alpha1.Model, monitoring *mlv1alpha1.Monitoring) {
+	t.Helper()
+	getObject(t, r, model_serving)
+	model_serving.Spec.Monitoring = monitoring
+	if err := r.Update(context.Background(), model_serving); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMonitoring(t *testing.T) {
+	model_serving := newTestModel()
+	port := int32(9090)
+	model_serving.Spec.Monitoring = &mlv1alpha1.Monitoring{Port: &port}
+	r := newTestReconciler(t, model_serving)
+	r.monitoringAPI = true
+	reconcileModel(t, r, model_serving)
+
+	monitor := monitoringObject(model.ServiceMonitorGVK)
+	getObject(t, r, monitor)
+	rule := monitoringObject(model.PrometheusRuleGVK)
+	getObject(t, r, rule)
+	for _, obj := range []client.Object{monitor, rule} {
+		if !metav1.IsControlledBy(obj, model_serving) {
+			t.Errorf("%s is not controlled by the model", obj.GetObjectKind().GroupVersionKind().Kind)
+		}
+	}
+	service := metricsService()
+	getObject(t, r, service)
+	if len(service.Spec.Ports) == 0 || service.Spec.Ports[0].Port != port {
+		t.Errorf("metrics ports = %v, want %d", service.Spec.Ports, port)
+	}
+
+	// Alerts are removed on their own.
+	updateMonitoring(t, r, model_serving, &mlv1alpha1.Monitoring{Port: &port, Alerts: &mlv1alpha1.ModelAlerts{Disabled: true}})
+	reconcileModel(t, r, model_serving)
+	expectDeleted(t, r, monitoringObject(model.PrometheusRuleGVK))
+	getObject(t, r, monitoringObject(model.ServiceMonitorGVK))
+
+	updateMonitoring(t, r, model_serving, &mlv1alpha1.Monitoring{Disabled: true})
+	reconcileModel(t, r, model_serving)
+	expectDeleted(t, r, monitoringObject(model.ServiceMonitorGVK))
+	expectDeleted(t, r, monitoringObject(model.PrometheusRuleGVK))
+	expectDeleted(t, r, metricsService())
+}
+
+// withoutMonitoringAPI is a client of a cluster without the Prometheus
+// Operator CRDs.
+type withoutMonitoringAPI struct {
+	client.Client
+}
+
+func (c withoutMonitoringAPI) noMatch(obj client.Object) error {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil
+	}
+	if gvk := u.GroupVersionKind(); gvk == model.ServiceMonitorGVK || gvk == model.PrometheusRuleGVK {
+		return &meta.NoKindMatchError{GroupKind: gvk.GroupKind(), SearchedVersions: []string{gvk.Version}}
+	}
+	return nil
+}
+
+func (c withoutMonitoringAPI) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if err := c.noMatch(obj); err != nil {
+		return err
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c withoutMonitoringAPI) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.noMatch(obj); err != nil {
+		return err
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestMonitoringWithoutMonitoringAPI(t *testing.T) {
+	model_serving := newTestModel()
+	model_serving.Spec.Monitoring = &mlv1alpha1.Monitoring{}
+	r := newTestReconciler(t, model_serving)
+	r.Client = withoutMonitoringAPI{r.Client}
+
+	// The client fails any access to the monitoring objects.
+	reconcileModel(t, r, model_serving)
+	expectDeleted(t, r, metricsService())
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+)
+
+const reasonOverridesConflict = "OverridesConflict"
+
+// overridePodTemplate merges spec.podTemplateOverrides onto the pod template
+// of statefulset and reports the outcome in the OverridesApplied condition.
+// The StatefulSet is left alone while the overrides do not merge, rather
+// than rolling the pods without them.
+func (r *ModelReconciler) overridePodTemplate(model_serving *mlv1alpha1.Model, statefulset *appsv1.StatefulSet) error {
+	status := &model_serving.Status
+	overrides := model_serving.Spec.PodTemplateOverrides
+	if overrides == nil || len(overrides.Raw) == 0 {
+		meta.RemoveStatusCondition(&status.Conditions, mlv1alpha1.ConditionOverridesApplied)
+		return nil
+	}
+
+	err := model.MergePodTemplate(&statefulset.Spec.Template, overrides.Raw, statefulset.Spec.Selector.MatchLabels)
+	if err != nil {
+		message := fmt.Sprintf("Failed to merge spec.podTemplateOverrides: %s", err)
+		if !meta.IsStatusConditionFalse(status.Conditions, mlv1alpha1.ConditionOverridesApplied) {
+			r.Recorder.Event(model_serving, corev1.EventTypeWarning, reasonOverridesConflict, message)
+		}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               mlv1alpha1.ConditionOverridesApplied,
+			Status:             metav1.ConditionFalse,
+			Reason:             "MergeConflict",
+			Message:            message,
+			ObservedGeneration: model_serving.Generation,
+		})
+		return fmt.Errorf("merging pod template overrides: %w", err)
+	}
+
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               mlv1alpha1.ConditionOverridesApplied,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Merged",
+		Message:            "spec.podTemplateOverrides is merged onto the pod template",
+		ObservedGeneration: model_serving.Generation,
+	})
+	return nil
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+func TestPodTemplateOverrides(t *testing.T) {
+	model_serving := newTestModel()
+	model_serving.Spec.PodTemplateOverrides = &runtime.RawExtension{Raw: []byte(
+		`{"spec": {"containers": [{"name": "proxy", "image": "envoyproxy/envoy:v1.22"}]}}`,
+	)}
+	r := newTestReconciler(t, model_serving)
+	reconcileModel(t, r, model_serving)
+
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "iris", Namespace: "team"}}
+	getObject(t, r, statefulset)
+	names := []string{}
+	for _, container := range statefulset.Spec.Template.Spec.Containers {
+		names = append(names, container.Name)
+	}
+	if len(names) != 2 || !containsString(names, "serving") || !containsString(names, "proxy") {
+		t.Errorf("containers = %v, want the serving container and the sidecar", names)
+	}
+	getObject(t, r, model_serving)
+	if !meta.IsStatusConditionTrue(model_serving.Status.Conditions, mlv1alpha1.ConditionOverridesApplied) {
+		t.Errorf("conditions = %+v, want OverridesApplied", model_serving.Status.Conditions)
+	}
+}
+
+func TestPodTemplateOverridesConflict(t *testing.T) {
+	model_serving := newTestModel()
+	r := newTestReconciler(t, model_serving)
+	reconcileModel(t, r, model_serving)
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "iris", Namespace: "team"}}
+	getObject(t, r, statefulset)
+	rendered := statefulset.Spec.Template
+
+	// Models created while the webhook was not running may relabel the
+	// serving pods.
+	getObject(t, r, model_serving)
+	model_serving.Spec.PodTemplateOverrides = &runtime.RawExtension{Raw: []byte(`{"metadata": {"labels": {"serving": "other"}}}`)}
+	if err := r.Update(context.Background(), model_serving); err != nil {
+		t.Fatal(err)
+	}
+	recordedEvents(r)
+	if _, err := r.Reconcile(context.Background(), requestFor(model_serving)); err == nil {
+		t.Fatal("reconcile succeeded with overrides that do not merge")
+	}
+
+	getObject(t, r, model_serving)
+	condition := meta.FindStatusCondition(model_serving.Status.Conditions, mlv1alpha1.ConditionOverridesApplied)
+	if condition == nil || condition.Status != metav1.ConditionFalse || condition.Reason != "MergeConflict" {
+		t.Errorf("overrides applied = %+v, want False with MergeConflict", condition)
+	}
+	if events := recordedEvents(r); !hasEvent(events, corev1.EventTypeWarning, reasonOverridesConflict) {
+		t.Errorf("events = %v, want %s", events, reasonOverridesConflict)
+	}
+	getObject(t, r, statefulset)
+	if statefulset.Spec.Template.Labels["serving"] != "iris" || len(statefulset.Spec.Template.Spec.Containers) != len(rendered.Spec.Containers) {
+		t.Errorf("template = %+v, want the StatefulSet left alone", statefulset.Spec.Template.ObjectMeta)
+	}
+}
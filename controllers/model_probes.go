@@ -0,0 +1,136 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+)
+
+// Budget of the default startup probe: a base for the server to come up
+// plus the time to load each GiB of artifact.
+const (
+	startupBase   = time.Minute
+	startupPerGiB = 30 * time.Second
+	bytesPerGiB   = 1 << 30
+	servingName   = "serving"
+)
+
+// startupBudget is how long the serving container may take to load the
+// artifact of the model, sized by spec.artifact.size or the storage size.
+func startupBudget(model_serving *mlv1alpha1.Model, mod *model.ModelServing) time.Duration {
+	size := mod.StorageSize
+	if artifact := model_serving.Spec.Artifact; artifact != nil && artifact.Size != nil {
+		size = *artifact.Size
+	}
+	gib := (size.Value() + bytesPerGiB - 1) / bytesPerGiB
+	return startupBase + time.Duration(gib)*startupPerGiB
+}
+
+// applyProbes applies spec.probes to the serving container of statefulset,
+// on top of the defaults rendered by the model builder.
+func applyProbes(model_serving *mlv1alpha1.Model, mod *model.ModelServing, statefulset *appsv1.StatefulSet) {
+	probes := model_serving.Spec.Probes
+	if probes == nil {
+		return
+	}
+	containers := statefulset.Spec.Template.Spec.Containers
+	for i := range containers {
+		if containers[i].Name != "serving" {
+			continue
+		}
+		containers[i].ReadinessProbe = overrideProbe(containers[i].ReadinessProbe, probes.Readiness, mod)
+		containers[i].LivenessProbe = overrideProbe(containers[i].LivenessProbe, probes.Liveness, mod)
+		containers[i].StartupProbe = overrideProbe(containers[i].StartupProbe, probes.Startup, mod)
+	}
+}
+
+// overrideProbe returns probe with the fields set in override replaced.
+func overrideProbe(probe *corev1.Probe, override *mlv1alpha1.ModelProbe, mod *model.ModelServing) *corev1.Probe {
+	if override == nil {
+		return probe
+	}
+	if override.Disabled {
+		return nil
+	}
+
+	if probe == nil {
+		probe = &corev1.Probe{ProbeHandler: mod.ProbeHandler("")}
+	} else {
+		probe = probe.DeepCopy()
+	}
+	switch {
+	case override.HTTPPath != "":
+		probe.ProbeHandler = mod.ProbeHandler(override.HTTPPath)
+	case override.TCP:
+		probe.ProbeHandler = mod.ProbeHandler("")
+	}
+
+	for _, field := range []struct {
+		value  *int32
+		target *int32
+	}{
+		{override.InitialDelaySeconds, &probe.InitialDelaySeconds},
+		{override.PeriodSeconds, &probe.PeriodSeconds},
+		{override.TimeoutSeconds, &probe.TimeoutSeconds},
+		{override.FailureThreshold, &probe.FailureThreshold},
+	} {
+		if field.value != nil {
+			*field.target = *field.value
+		}
+	}
+	return probe
+}
+
+// unreadyContainers counts the pods whose serving container runs but is
+// held back by its probes: starting have not passed the startup probe yet,
+// unready fail the readiness probe.
+func unreadyContainers(pods []corev1.Pod) (starting int, unready int) {
+	for _, pod := range pods {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Name != "serving" || cs.State.Running == nil || cs.Ready {
+				continue
+			}
+			if cs.Started != nil && !*cs.Started {
+				starting++
+			} else {
+				unready++
+			}
+		}
+	}
+	return starting, unready
+}
+
+// notReady explains why the replicas of statefulset are not all ready,
+// telling pods loading the model apart from pods failing their probe.
+func notReady(statefulset *appsv1.StatefulSet, pods []corev1.Pod, replicas string) (string, string) {
+	message := fmt.Sprintf("%d/%d %s ready", statefulset.Status.ReadyReplicas, desiredReplicas(statefulset), replicas)
+	starting, unready := unreadyContainers(pods)
+	switch {
+	case unready > 0:
+		return "ReadinessProbeFailing", fmt.Sprintf("%s, %d failing the readiness probe", message, unready)
+	case starting > 0:
+		return "ModelLoading", fmt.Sprintf("%s, %d loading the model", message, starting)
+	}
+	return "ReplicasNotReady", message
+}
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+	"github.com/kalkyai/model-serving-operator/pkg/runtimes"
+)
+
+// renderedServing reconciles the model and returns its serving container.
+func renderedServing(t *testing.T, model_serving *mlv1alpha1.Model) corev1.Container {
+	t.Helper()
+	r := newTestReconciler(t, model_serving)
+	reconcileModel(t, r, model_serving)
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: model_serving.Name, Namespace: model_serving.Namespace}}
+	getObject(t, r, statefulset)
+	return servingContainer(t, statefulset.Spec.Template.Spec)
+}
+
+// probeTarget describes what probe checks: "tcp:<port>" or
+// "http:<port><path>", and "none" without a probe.
+func probeTarget(probe *corev1.Probe) string {
+	switch {
+	case probe == nil:
+		return "none"
+	case probe.TCPSocket != nil:
+		return "tcp:" + probe.TCPSocket.Port.String()
+	case probe.HTTPGet != nil:
+		return "http:" + probe.HTTPGet.Port.String() + probe.HTTPGet.Path
+	}
+	return "unknown"
+}
+
+func TestDefaultProbes(t *testing.T) {
+	tests := []struct {
+		framework string
+		readiness string
+		liveness  string
+		startup   string
+	}{
+		{framework: runtimes.SKLearn, readiness: "tcp:4000", liveness: "tcp:4000", startup: "tcp:4000"},
+		{framework: runtimes.XGBoost, readiness: "http:8080/v1/models/iris", liveness: "http:8080/", startup: "http:8080/v1/models/iris"},
+		{framework: runtimes.LightGBM, readiness: "http:8080/v1/models/iris", liveness: "http:8080/", startup: "http:8080/v1/models/iris"},
+		{framework: runtimes.ONNX, readiness: "tcp:8001", liveness: "tcp:8001", startup: "tcp:8001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.framework, func(t *testing.T) {
+			model_serving := newTestModel()
+			model_serving.Spec.Framework = tt.framework
+			container := renderedServing(t, model_serving)
+
+			if got := probeTarget(container.ReadinessProbe); got != tt.readiness {
+				t.Errorf("readiness probe = %s, want %s", got, tt.readiness)
+			}
+			if got := probeTarget(container.LivenessProbe); got != tt.liveness {
+				t.Errorf("liveness probe = %s, want %s", got, tt.liveness)
+			}
+			if got := probeTarget(container.StartupProbe); got != tt.startup {
+				t.Errorf("startup probe = %s, want %s", got, tt.startup)
+			}
+		})
+	}
+}
+
+func TestStartupProbeBudget(t *testing.T) {
+	model_serving := newTestModel()
+	size := resource.MustParse("2Gi")
+	model_serving.Spec.Artifact = &mlv1alpha1.ArtifactVerification{Size: &size}
+	probe := renderedServing(t, model_serving).StartupProbe
+
+	// A minute to come up and 30s per GiB, checked every 10s.
+	if probe.PeriodSeconds != 10 || probe.FailureThreshold != 12 {
+		t.Errorf("startup probe every %ds failing after %d, want every 10s failing after 12", probe.PeriodSeconds, probe.FailureThreshold)
+	}
+}
+
+func TestStartupBudget(t *testing.T) {
+	tests := []struct {
+		artifact string
+		storage  string
+		want     time.Duration
+	}{
+		{storage: "1Gi", want: 90 * time.Second},
+		{storage: "10Gi", want: 6 * time.Minute},
+		{artifact: "500Mi", storage: "10Gi", want: 90 * time.Second},
+		{artifact: "2.5Gi", storage: "10Gi", want: 150 * time.Second},
+		{artifact: "0", storage: "10Gi", want: time.Minute},
+	}
+	for _, tt := range tests {
+		model_serving := newTestModel()
+		if tt.artifact != "" {
+			size := resource.MustParse(tt.artifact)
+			model_serving.Spec.Artifact = &mlv1alpha1.ArtifactVerification{Size: &size}
+		}
+		mod := &model.ModelServing{StorageSize: resource.MustParse(tt.storage)}
+		if got := startupBudget(model_serving, mod); got != tt.want {
+			t.Errorf("startupBudget(%s, %s) = %s, want %s", tt.artifact, tt.storage, got, tt.want)
+		}
+	}
+}
+
+func TestProbeOverrides(t *testing.T) {
+	model_serving := newTestModel()
+	model_serving.Spec.Framework = runtimes.XGBoost
+	model_serving.Spec.Probes = &mlv1alpha1.ModelProbes{
+		Readiness: &mlv1alpha1.ModelProbe{HTTPPath: "/v2/health/ready", PeriodSeconds: pointer.Int32(5)},
+		Liveness:  &mlv1alpha1.ModelProbe{TCP: true, FailureThreshold: pointer.Int32(6)},
+		Startup:   &mlv1alpha1.ModelProbe{Disabled: true},
+	}
+	container := renderedServing(t, model_serving)
+
+	if got := probeTarget(container.ReadinessProbe); got != "http:8080/v2/health/ready" || container.ReadinessProbe.PeriodSeconds != 5 {
+		t.Errorf("readiness probe = %s every %ds", got, container.ReadinessProbe.PeriodSeconds)
+	}
+	if got := probeTarget(container.LivenessProbe); got != "tcp:8080" || container.LivenessProbe.FailureThreshold != 6 {
+		t.Errorf("liveness probe = %s failing after %d", got, container.LivenessProbe.FailureThreshold)
+	}
+	if container.StartupProbe != nil {
+		t.Errorf("startup probe = %s, want it disabled", probeTarget(container.StartupProbe))
+	}
+}
+
+func TestProbeOverridesKeepDefaults(t *testing.T) {
+	model_serving := newTestModel()
+	model_serving.Spec.Framework = runtimes.XGBoost
+	model_serving.Spec.Probes = &mlv1alpha1.ModelProbes{
+		Readiness: &mlv1alpha1.ModelProbe{TimeoutSeconds: pointer.Int32(3)},
+	}
+	container := renderedServing(t, model_serving)
+
+	if got := probeTarget(container.ReadinessProbe); got != "http:8080/v1/models/iris" || container.ReadinessProbe.TimeoutSeconds != 3 {
+		t.Errorf("readiness probe = %s timing out after %ds, want the runtime path with the timeout", got, container.ReadinessProbe.TimeoutSeconds)
+	}
+	if got := probeTarget(container.LivenessProbe); got != "http:8080/" {
+		t.Errorf("liveness probe = %s, want the runtime default", got)
+	}
+}
+
+func TestNotReadyMessage(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		Spec:   appsv1.StatefulSetSpec{Replicas: pointer.Int32(3)},
+		Status: appsv1.StatefulSetStatus{ReadyReplicas: 1},
+	}
+	pod := func(started, ready bool) corev1.Pod {
+		return corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:    "serving",
+			Ready:   ready,
+			Started: &started,
+			State:   corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+		}}}}
+	}
+	tests := []struct {
+		name    string
+		pods    []corev1.Pod
+		reason  string
+		message string
+	}{
+		{name: "loading", pods: []corev1.Pod{pod(true, true), pod(false, false)}, reason: "ModelLoading", message: "1/3 replicas ready, 1 loading the model"},
+		{name: "failing", pods: []corev1.Pod{pod(true, true), pod(false, false), pod(true, false)}, reason: "ReadinessProbeFailing", message: "1/3 replicas ready, 1 failing the readiness probe"},
+		{name: "pending", pods: []corev1.Pod{pod(true, true)}, reason: "ReplicasNotReady", message: "1/3 replicas ready"},
+	}
+	for _, tt := range tests {
+		reason, message := notReady(statefulset, tt.pods, "replicas")
+		if reason != tt.reason || message != tt.message {
+			t.Errorf("%s: notReady = %s %q, want %s %q", tt.name, reason, message, tt.reason, tt.message)
+		}
+	}
+}
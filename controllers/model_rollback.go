@@ -0,0 +1,243 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+)
+
+const (
+	defaultProgressDeadline     = 10 * time.Minute
+	defaultRevisionHistoryLimit = 10
+)
+
+// Reasons of a rollback, also used for its Events.
+const (
+	rollbackAutomatic = "Automatic"
+	rollbackManual    = "Manual"
+
+	reasonRolledBack     = "RolledBack"
+	reasonRollbackFailed = "RollbackFailed"
+)
+
+// specRevision is the revision the model spec asks for.
+func specRevision(model_serving *mlv1alpha1.Model) mlv1alpha1.ModelRevision {
+	return mlv1alpha1.ModelRevision{
+		Version:  model_serving.Spec.Version,
+		Location: artifactLocation(model_serving),
+	}
+}
+
+// desiredRevision is the revision the operator rolls out: the one in the
+// spec, unless that revision was rolled back.
+func desiredRevision(model_serving *mlv1alpha1.Model) mlv1alpha1.ModelRevision {
+	revision := specRevision(model_serving)
+	if rollback := model_serving.Status.RolledBack; rollback != nil && rollback.From == revision {
+		return rollback.To.ModelRevision
+	}
+	return revision
+}
+
+// servedRevision reads the revision a StatefulSet template serves.
+func servedRevision(statefulset *appsv1.StatefulSet) mlv1alpha1.ModelRevision {
+	annotations := statefulset.Spec.Template.Annotations
+	return mlv1alpha1.ModelRevision{
+		Version:  annotations[model.VersionAnnotation],
+		Location: annotations[model.LocationAnnotation],
+	}
+}
+
+// lastGoodRevision is the newest revision in the history, or nil.
+func lastGoodRevision(model_serving *mlv1alpha1.Model) *mlv1alpha1.RevisionRecord {
+	history := model_serving.Status.History
+	if len(history) == 0 {
+		return nil
+	}
+	return &history[len(history)-1]
+}
+
+// reconcileRollback ends a rollback once the spec moved on and applies a
+// rollback asked for with the rollback annotation. The annotation is removed
+// so it does not apply again to a later spec.
+func (r *ModelReconciler) reconcileRollback(ctx context.Context, model_serving *mlv1alpha1.Model) error {
+	if rollback := model_serving.Status.RolledBack; rollback != nil && rollback.From != specRevision(model_serving) {
+		model_serving.Status.RolledBack = nil
+	}
+
+	value, ok := model_serving.Annotations[mlv1alpha1.RollbackAnnotation]
+	if !ok {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Removing rollback annotation", "revision", value)
+	delete(model_serving.Annotations, mlv1alpha1.RollbackAnnotation)
+	status := model_serving.Status
+	if err := r.Update(ctx, model_serving); err != nil {
+		return err
+	}
+	// Update returns the stored status, which does not have our changes yet.
+	model_serving.Status = status
+
+	revision, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		r.Recorder.Eventf(model_serving, corev1.EventTypeWarning, reasonRollbackFailed, "Invalid revision %q", value)
+		return nil
+	}
+	for _, record := range model_serving.Status.History {
+		if record.Revision != revision {
+			continue
+		}
+		message := fmt.Sprintf("Rolled back to revision %d (version %s) on request", revision, record.Version)
+		model_serving.Status.RolledBack = &mlv1alpha1.RollbackStatus{
+			From:    specRevision(model_serving),
+			To:      record,
+			Reason:  rollbackManual,
+			Message: message,
+		}
+		r.Recorder.Event(model_serving, corev1.EventTypeNormal, reasonRolledBack, message)
+		return nil
+	}
+	r.Recorder.Eventf(model_serving, corev1.EventTypeWarning, reasonRollbackFailed, "Revision %d is not in the history", revision)
+	return nil
+}
+
+// recordRevision adds a revision that serves all replicas to the history,
+// with the digest its artifact was downloaded with, if known. This history
+// in the model status is the only one: the StatefulSet keeps no
+// ControllerRevisions, and rolling back renders the StatefulSet again from
+// the recorded revision.
+func recordRevision(model_serving *mlv1alpha1.Model, revision mlv1alpha1.ModelRevision, digest string) {
+	status := &model_serving.Status
+
+	var number int64 = 1
+	if last := lastGoodRevision(model_serving); last != nil {
+		if last.ModelRevision == revision {
+			if last.Digest == "" {
+				last.Digest = digest
+			}
+			return
+		}
+		number = last.Revision + 1
+	}
+	status.History = append(status.History, mlv1alpha1.RevisionRecord{
+		Revision:      number,
+		ModelRevision: revision,
+		Digest:        digest,
+		ServedAt:      metav1.Now(),
+	})
+
+	limit := defaultRevisionHistoryLimit
+	if model_serving.Spec.RevisionHistoryLimit != nil {
+		limit = int(*model_serving.Spec.RevisionHistoryLimit)
+	}
+	if len(status.History) > limit {
+		status.History = status.History[len(status.History)-limit:]
+	}
+}
+
+// detectFailedRollout rolls back to the last good revision when the revision
+// being rolled out crash loops or misses the progress deadline. Canary
+// rollouts are left to their own analysis.
+func (r *ModelReconciler) detectFailedRollout(model_serving *mlv1alpha1.Model, statefulset *appsv1.StatefulSet, pods []corev1.Pod) {
+	status := &model_serving.Status
+	if status.RolledBack != nil || rolledOut(statefulset) {
+		return
+	}
+	if rollout := status.Rollout; rollout != nil && rollout.Canary != nil {
+		return
+	}
+
+	last := lastGoodRevision(model_serving)
+	if last == nil || servedRevision(statefulset) == last.ModelRevision {
+		return
+	}
+
+	var message string
+	if crash, ok := crashLooping(pods); ok {
+		message = fmt.Sprintf("Version %s crash loops: %s", model_serving.Spec.Version, crash)
+	} else if deadline, started := progressDeadline(model_serving); started && time.Since(deadline) > 0 {
+		message = fmt.Sprintf("Version %s did not become ready within %s", model_serving.Spec.Version, progressDeadlineDuration(model_serving))
+	} else {
+		return
+	}
+
+	message = fmt.Sprintf("%s, rolled back to revision %d (version %s)", message, last.Revision, last.Version)
+	status.RolledBack = &mlv1alpha1.RollbackStatus{
+		From:    specRevision(model_serving),
+		To:      *last,
+		Reason:  rollbackAutomatic,
+		Message: message,
+	}
+	r.Recorder.Event(model_serving, corev1.EventTypeWarning, reasonRolledBack, message)
+}
+
+func progressDeadlineDuration(model_serving *mlv1alpha1.Model) time.Duration {
+	if model_serving.Spec.ProgressDeadline != nil {
+		return model_serving.Spec.ProgressDeadline.Duration
+	}
+	return defaultProgressDeadline
+}
+
+// trackRollout records when revision started rolling out. A revision
+// applied while an earlier one is still rolling out gets its own clock.
+func trackRollout(model_serving *mlv1alpha1.Model, revision mlv1alpha1.ModelRevision) {
+	status := &model_serving.Status
+	if status.RollingOut == nil || status.RollingOut.ModelRevision != revision {
+		status.RollingOut = &mlv1alpha1.RevisionProgress{ModelRevision: revision, StartedAt: metav1.Now()}
+	}
+}
+
+// progressDeadline is when the revision being rolled out has to be ready,
+// counted from when it started rolling out, and whether a rollout the
+// deadline applies to is in progress.
+func progressDeadline(model_serving *mlv1alpha1.Model) (time.Time, bool) {
+	status := &model_serving.Status
+	if status.RolledBack != nil || lastGoodRevision(model_serving) == nil {
+		return time.Time{}, false
+	}
+	if rollout := status.Rollout; rollout != nil && rollout.Canary != nil {
+		return time.Time{}, false
+	}
+	rollingOut := status.RollingOut
+	if rollingOut == nil || rollingOut.ModelRevision != desiredRevision(model_serving) {
+		return time.Time{}, false
+	}
+	return rollingOut.StartedAt.Add(progressDeadlineDuration(model_serving)), true
+}
+
+// untilProgressDeadline is how long to wait before checking a rollout
+// against its deadline again, zero when there is nothing to check. Nothing
+// else may trigger a reconcile while a revision is stuck pending.
+func untilProgressDeadline(model_serving *mlv1alpha1.Model, now time.Time) time.Duration {
+	deadline, started := progressDeadline(model_serving)
+	if !started || !deadline.After(now) {
+		return 0
+	}
+	// A moment past the deadline, so it has passed when the check runs.
+	return deadline.Sub(now) + time.Second
+}
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+// newUpgradedModel returns a model moving to version 0.7 after serving the
+// revisions of versions, which started rolling out since ago.
+func newUpgradedModel(since time.Duration, versions ...string) *mlv1alpha1.Model {
+	model_serving := newTestModel()
+	model_serving.Spec.Version = "0.7"
+	for i, version := range versions {
+		model_serving.Status.History = append(model_serving.Status.History, mlv1alpha1.RevisionRecord{
+			Revision:      int64(i + 1),
+			ModelRevision: mlv1alpha1.ModelRevision{Version: version, Location: "iris.sav"},
+			ServedAt:      metav1.Now(),
+		})
+	}
+	meta.SetStatusCondition(&model_serving.Status.Conditions, metav1.Condition{
+		Type:               mlv1alpha1.ConditionProgressing,
+		Status:             metav1.ConditionTrue,
+		Reason:             "RollingOut",
+		LastTransitionTime: metav1.NewTime(time.Now().Add(-since)),
+	})
+	model_serving.Status.RollingOut = &mlv1alpha1.RevisionProgress{
+		ModelRevision: specRevision(model_serving),
+		StartedAt:     metav1.NewTime(time.Now().Add(-since)),
+	}
+	return model_serving
+}
+
+func expectServedVersion(t *testing.T, r *ModelReconciler, version string) {
+	t.Helper()
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "iris", Namespace: "team"}}
+	getObject(t, r, statefulset)
+	if image := servingContainer(t, statefulset.Spec.Template.Spec).Image; !strings.HasSuffix(image, ":"+version) {
+		t.Errorf("image = %s, want version %s", image, version)
+	}
+}
+
+func TestRequeueAtProgressDeadline(t *testing.T) {
+	model_serving := newUpgradedModel(3*time.Minute, "0.6")
+	r := newTestReconciler(t, model_serving)
+
+	result := reconcileModel(t, r, model_serving)
+
+	remaining := defaultProgressDeadline - 3*time.Minute
+	if result.RequeueAfter < remaining-time.Minute || result.RequeueAfter > remaining+time.Minute {
+		t.Errorf("requeue after %s, want about %s", result.RequeueAfter, remaining)
+	}
+	getObject(t, r, model_serving)
+	if model_serving.Status.RolledBack != nil {
+		t.Errorf("rolled back before the deadline: %+v", model_serving.Status.RolledBack)
+	}
+}
+
+func TestRollbackAfterProgressDeadline(t *testing.T) {
+	model_serving := newUpgradedModel(defaultProgressDeadline+time.Minute, "0.6")
+	r := newTestReconciler(t, model_serving)
+
+	reconcileModel(t, r, model_serving)
+
+	getObject(t, r, model_serving)
+	rollback := model_serving.Status.RolledBack
+	if rollback == nil || rollback.Reason != rollbackAutomatic || rollback.To.Version != "0.6" {
+		t.Fatalf("rollback = %+v", rollback)
+	}
+	if !hasEvent(recordedEvents(r), corev1.EventTypeWarning, reasonRolledBack) {
+		t.Errorf("no %s Event", reasonRolledBack)
+	}
+	if !meta.IsStatusConditionTrue(model_serving.Status.Conditions, mlv1alpha1.ConditionRolledBack) {
+		t.Errorf("no RolledBack condition: %v", model_serving.Status.Conditions)
+	}
+
+	reconcileModel(t, r, model_serving)
+	expectServedVersion(t, r, "0.6")
+}
+
+func TestProgressDeadlinePerRevision(t *testing.T) {
+	// Version 0.7 has been rolling out for almost the whole deadline when
+	// 0.8 is applied.
+	model_serving := newUpgradedModel(defaultProgressDeadline-time.Minute, "0.6")
+	model_serving.Spec.Version = "0.8"
+	r := newTestReconciler(t, model_serving)
+
+	result := reconcileModel(t, r, model_serving)
+
+	getObject(t, r, model_serving)
+	rollingOut := model_serving.Status.RollingOut
+	if rollingOut == nil || rollingOut.Version != "0.8" || time.Since(rollingOut.StartedAt.Time) > time.Minute {
+		t.Fatalf("rolling out %+v, want 0.8 from now", rollingOut)
+	}
+	if result.RequeueAfter < defaultProgressDeadline-time.Minute {
+		t.Errorf("requeue after %s, want the whole deadline of 0.8", result.RequeueAfter)
+	}
+
+	// Past the deadline 0.7 had, 0.8 is still within its own.
+	model_serving.Status.RollingOut.StartedAt = metav1.NewTime(time.Now().Add(-2 * time.Minute))
+	if err := r.Status().Update(context.Background(), model_serving); err != nil {
+		t.Fatal(err)
+	}
+	reconcileModel(t, r, model_serving)
+
+	getObject(t, r, model_serving)
+	if model_serving.Status.RolledBack != nil {
+		t.Errorf("rolled back on the deadline of an earlier revision: %+v", model_serving.Status.RolledBack)
+	}
+	expectServedVersion(t, r, "0.8")
+}
+
+func TestRollbackAnnotation(t *testing.T) {
+	model_serving := newUpgradedModel(time.Minute, "0.5", "0.6")
+	model_serving.Annotations = map[string]string{mlv1alpha1.RollbackAnnotation: "1"}
+	r := newTestReconciler(t, model_serving)
+
+	reconcileModel(t, r, model_serving)
+
+	getObject(t, r, model_serving)
+	if _, ok := model_serving.Annotations[mlv1alpha1.RollbackAnnotation]; ok {
+		t.Errorf("rollback annotation was not removed")
+	}
+	rollback := model_serving.Status.RolledBack
+	if rollback == nil || rollback.Reason != rollbackManual || rollback.To.Revision != 1 {
+		t.Fatalf("rollback = %+v", rollback)
+	}
+	expectServedVersion(t, r, "0.5")
+
+	// Revisions missing from the history are reported.
+	model_serving.Annotations = map[string]string{mlv1alpha1.RollbackAnnotation: "7"}
+	if err := r.Update(context.Background(), model_serving); err != nil {
+		t.Fatal(err)
+	}
+	recordedEvents(r)
+	reconcileModel(t, r, model_serving)
+	if !hasEvent(recordedEvents(r), corev1.EventTypeWarning, reasonRollbackFailed) {
+		t.Errorf("no %s Event", reasonRollbackFailed)
+	}
+}
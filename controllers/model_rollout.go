@@ -0,0 +1,331 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+)
+
+// defaultReadyTimeout bounds how long a canary step waits for ready pods
+// when the rollout has no analysis.
+const defaultReadyTimeout = 10 * time.Minute
+
+const (
+	reasonRolloutAborted  = "RolloutAborted"
+	reasonRolloutPromoted = "RolloutPromoted"
+)
+
+// splitReplicas divides replicas between the stable and the canary pods so
+// the canary gets the share of them closest to weight percent. The canary
+// has at least one pod, and below 100% so does the stable revision, so with
+// few replicas the split can be far from the weight: a 10% step on two
+// replicas is a 50% split.
+func splitReplicas(replicas int32, weight int32) (int32, int32) {
+	if replicas <= 0 {
+		return 0, 0
+	}
+	canary := (replicas*weight + 50) / 100
+	if canary < 1 {
+		canary = 1
+	}
+	stable := replicas - canary
+	if weight < 100 && stable < 1 {
+		stable = 1
+	}
+	return stable, canary
+}
+
+// planRollout moves the rollout status along with the spec and decides what
+// the stable and canary pods run. stable is adjusted in place; the returned
+// builder renders the canary, or is nil when no canary should run.
+func (r *ModelReconciler) planRollout(model_serving *mlv1alpha1.Model, stable *model.ModelServing) *model.ModelServing {
+	strategy := model_serving.Spec.Rollout
+	if strategy == nil {
+		model_serving.Status.Rollout = nil
+		return nil
+	}
+
+	desired := desiredRevision(model_serving)
+	status := model_serving.Status.Rollout
+	if status == nil {
+		// The revision running when the strategy is first seen is stable.
+		status = &mlv1alpha1.RolloutStatus{Phase: mlv1alpha1.RolloutSucceeded, Stable: desired}
+		model_serving.Status.Rollout = status
+	}
+
+	switch {
+	case status.Stable == desired:
+		if status.Canary != nil && status.Phase != mlv1alpha1.RolloutAborted {
+			r.Recorder.Event(model_serving, corev1.EventTypeNormal, reasonRolloutAborted, "Spec reverted to the stable revision")
+			status.Phase = mlv1alpha1.RolloutAborted
+			status.Message = "Spec reverted to the stable revision"
+		}
+		status.Canary = nil
+		status.Weight = 0
+		return nil
+
+	case stable.Replicas == 0:
+		// There is nothing to analyse without pods.
+		status.Phase = mlv1alpha1.RolloutSucceeded
+		status.Stable = desired
+		status.Canary = nil
+		status.Weight = 0
+		status.Message = "Promoted without canary, the model is scaled to zero"
+		return nil
+
+	case status.Canary == nil || *status.Canary != desired:
+		now := metav1.Now()
+		status.Phase = mlv1alpha1.RolloutProgressing
+		status.Canary = &desired
+		status.Step = 0
+		status.StepStartedAt = &now
+		r.Recorder.Eventf(model_serving, corev1.EventTypeNormal, reasonRolloutStarted,
+			"Starting canary rollout of version %s from %s", desired.Version, desired.Location)
+
+	case status.Phase == mlv1alpha1.RolloutAborted:
+		// Keep serving the stable revision until the spec changes again.
+		stable.Version = status.Stable.Version
+		stable.ModelURL = status.Stable.Location
+		status.Weight = 0
+		return nil
+	}
+
+	if last := int32(len(strategy.Steps)) - 1; status.Step > last {
+		// The steps were shortened during the rollout, the canary goes on
+		// from the new last step.
+		status.Step = last
+	}
+	weight := strategy.Steps[status.Step].Weight
+	stableReplicas, canaryReplicas := splitReplicas(stable.Replicas, weight)
+	if model_serving.Spec.Autoscaling != nil {
+		// The autoscaler keeps sizing the stable pods, the canary is added
+		// on top of them.
+		stableReplicas = stable.Replicas
+	}
+
+	canary := *stable
+	canary.Track = model.CanaryTrack
+	canary.Replicas = canaryReplicas
+
+	if status.Phase == mlv1alpha1.RolloutPromoting {
+		// The stable pods move to the new revision with their full count
+		// while the canary keeps serving.
+		status.Weight = canaryReplicas * 100 / (stable.Replicas + canaryReplicas)
+		return &canary
+	}
+
+	stable.Version = status.Stable.Version
+	stable.ModelURL = status.Stable.Location
+	stable.Replicas = stableReplicas
+	// The Service balances over pods, so the weight reported is the share
+	// of replicas rather than the weight of the step.
+	status.Weight = canaryReplicas * 100 / (stableReplicas + canaryReplicas)
+	status.Message = fmt.Sprintf("Step %d/%d asks for %d%% of traffic", status.Step+1, len(strategy.Steps), weight)
+	return &canary
+}
+
+// reconcileCanary creates or updates the canary ConfigMap and StatefulSet,
+// or removes them when no canary should run.
+func (r *ModelReconciler) reconcileCanary(ctx context.Context, model_serving *mlv1alpha1.Model, config *corev1.ConfigMap, statefulset *appsv1.StatefulSet) error {
+	if statefulset != nil {
+		if err := r.reconcileConfigMap(ctx, model_serving, config); err != nil {
+			return err
+		}
+		return r.reconcileStatefulSet(ctx, model_serving, statefulset)
+	}
+
+	canary := &model.ModelServing{Name: model_serving.Name, Namespace: model_serving.Namespace, Track: model.CanaryTrack}
+	for _, obj := range []client.Object{&appsv1.StatefulSet{}, &corev1.ConfigMap{}} {
+		name := canary.WorkloadName()
+		if _, ok := obj.(*corev1.ConfigMap); ok {
+			name = canary.ConfigMapName()
+		}
+		err := r.Get(ctx, types.NamespacedName{Namespace: model_serving.Namespace, Name: name}, obj)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !metav1.IsControlledBy(obj, model_serving) {
+			continue
+		}
+		log.FromContext(ctx).Info("Deleting canary", "name", name)
+		if err := client.IgnoreNotFound(r.Delete(ctx, obj)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// advanceRollout runs the analysis of the current step and moves the rollout
+// to the next step, promotes or aborts it. stable and canary are the
+// StatefulSets just reconciled, canary is nil when none runs.
+func (r *ModelReconciler) advanceRollout(ctx context.Context, model_serving *mlv1alpha1.Model, stable *appsv1.StatefulSet, canary *appsv1.StatefulSet) (ctrl.Result, error) {
+	status := model_serving.Status.Rollout
+	if status == nil || status.Canary == nil || canary == nil {
+		return ctrl.Result{}, nil
+	}
+
+	switch status.Phase {
+	case mlv1alpha1.RolloutPromoting:
+		found, err := r.current(ctx, stable)
+		if err != nil || found == nil || !rolledOut(found) {
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(model_serving, corev1.EventTypeNormal, reasonRolloutPromoted,
+			"Promoted version %s from %s", status.Canary.Version, status.Canary.Location)
+		status.Phase = mlv1alpha1.RolloutSucceeded
+		status.Stable = *status.Canary
+		status.Canary = nil
+		status.Step = 0
+		status.Weight = 0
+		status.StepStartedAt = nil
+		status.Message = fmt.Sprintf("Promoted version %s", status.Stable.Version)
+		return ctrl.Result{Requeue: true}, nil
+
+	case mlv1alpha1.RolloutProgressing:
+		return r.analyseCanary(ctx, model_serving, canary)
+	}
+	return ctrl.Result{}, nil
+}
+
+// analyseCanary checks the canary pods against the analysis thresholds:
+// restarts of their serving containers, their readiness and the share of
+// requests they failed.
+func (r *ModelReconciler) analyseCanary(ctx context.Context, model_serving *mlv1alpha1.Model, canary *appsv1.StatefulSet) (ctrl.Result, error) {
+	status := model_serving.Status.Rollout
+	strategy := model_serving.Spec.Rollout
+
+	readyTimeout := defaultReadyTimeout
+	var maxRestarts int32
+	if analysis := strategy.Analysis; analysis != nil {
+		if analysis.ReadyTimeout != nil {
+			readyTimeout = analysis.ReadyTimeout.Duration
+		}
+		maxRestarts = analysis.MaxRestarts
+	}
+
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods,
+		client.InNamespace(model_serving.Namespace),
+		client.MatchingLabels{"serving": model_serving.Name, model.TrackLabel: model.CanaryTrack},
+	); err != nil {
+		return ctrl.Result{}, err
+	}
+	var restarts int32
+	for _, pod := range pods.Items {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Name == "serving" {
+				restarts += cs.RestartCount
+			}
+		}
+	}
+	if restarts > maxRestarts {
+		return r.abortRollout(model_serving, fmt.Sprintf("Canary serving containers restarted %d times, at most %d are allowed", restarts, maxRestarts))
+	}
+
+	elapsed := time.Since(status.StepStartedAt.Time)
+
+	found, err := r.current(ctx, canary)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if found == nil || !rolledOut(found) {
+		if elapsed > readyTimeout {
+			return r.abortRollout(model_serving, fmt.Sprintf("Canary replicas were not ready within %s", readyTimeout))
+		}
+		return ctrl.Result{RequeueAfter: readyTimeout - elapsed}, nil
+	}
+
+	if analysis := strategy.Analysis; analysis != nil && analysis.MaxErrorRate != nil {
+		counts, err := canaryRequests(ctx, model_serving, canary, pods.Items)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Failed to read the canary metrics")
+			if elapsed > readyTimeout {
+				return r.abortRollout(model_serving, fmt.Sprintf("Canary metrics could not be read within %s: %s", readyTimeout, err))
+			}
+			status.Message = fmt.Sprintf("Step %d/%d waits for the canary metrics: %s", status.Step+1, len(strategy.Steps), err)
+			return ctrl.Result{RequeueAfter: analysisInterval}, nil
+		}
+		if rate := counts.errorRate(); rate > analysis.MaxErrorRate.AsApproximateFloat64() {
+			return r.abortRollout(model_serving, fmt.Sprintf("Canary answered %.1f%% of %.0f requests with errors, at most %s%% are allowed",
+				rate, counts.total, analysis.MaxErrorRate))
+		}
+	}
+
+	// planRollout keeps the step within the steps of the spec.
+	step := strategy.Steps[status.Step]
+	if step.Pause != nil && elapsed < step.Pause.Duration {
+		requeue := step.Pause.Duration - elapsed
+		if analysis := strategy.Analysis; analysis != nil && analysis.MaxErrorRate != nil && requeue > analysisInterval {
+			// Keep checking the error rate during the pause.
+			requeue = analysisInterval
+		}
+		return ctrl.Result{RequeueAfter: requeue}, nil
+	}
+
+	now := metav1.Now()
+	status.Step++
+	status.StepStartedAt = &now
+	if int(status.Step) >= len(strategy.Steps) {
+		status.Phase = mlv1alpha1.RolloutPromoting
+		status.Message = fmt.Sprintf("Promoting version %s", status.Canary.Version)
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+func (r *ModelReconciler) abortRollout(model_serving *mlv1alpha1.Model, message string) (ctrl.Result, error) {
+	status := model_serving.Status.Rollout
+	r.Recorder.Eventf(model_serving, corev1.EventTypeWarning, reasonRolloutAborted,
+		"Aborted canary rollout of version %s: %s", status.Canary.Version, message)
+	status.Phase = mlv1alpha1.RolloutAborted
+	status.Weight = 0
+	status.Message = message
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// current returns the live StatefulSet once the cache has caught up with
+// desired, and nil while it still shows an older spec.
+func (r *ModelReconciler) current(ctx context.Context, desired *appsv1.StatefulSet) (*appsv1.StatefulSet, error) {
+	found := &appsv1.StatefulSet{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), found)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if found.Annotations[model.HashAnnotation] != desired.Annotations[model.HashAnnotation] {
+		return nil, nil
+	}
+	return found, nil
+}
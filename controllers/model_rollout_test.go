@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+)
+
+// newRolloutModel returns a model with four replicas halfway through a
+// canary rollout from version 0.6 to 0.7.
+func newRolloutModel(steps ...int32) *mlv1alpha1.Model {
+	model_serving := newTestModel()
+	model_serving.Spec.Replicas = 4
+	model_serving.Spec.Version = "0.7"
+	model_serving.Spec.Rollout = &mlv1alpha1.RolloutStrategy{}
+	for _, weight := range steps {
+		model_serving.Spec.Rollout.Steps = append(model_serving.Spec.Rollout.Steps, mlv1alpha1.RolloutStep{Weight: weight})
+	}
+	now := metav1.Now()
+	model_serving.Status.Rollout = &mlv1alpha1.RolloutStatus{
+		Phase:         mlv1alpha1.RolloutProgressing,
+		Stable:        mlv1alpha1.ModelRevision{Version: "0.6", Location: "iris.sav"},
+		Canary:        &mlv1alpha1.ModelRevision{Version: "0.7", Location: "iris.sav"},
+		StepStartedAt: &now,
+	}
+	return model_serving
+}
+
+func canaryStatefulSet(model_serving *mlv1alpha1.Model) *appsv1.StatefulSet {
+	canary := &model.ModelServing{Name: model_serving.Name, Track: model.CanaryTrack}
+	return &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: canary.WorkloadName(), Namespace: model_serving.Namespace}}
+}
+
+// markRolledOut reports every replica of the StatefulSet as updated and
+// ready, as the StatefulSet controller would.
+func markRolledOut(t *testing.T, r *ModelReconciler, statefulset *appsv1.StatefulSet) {
+	t.Helper()
+	getObject(t, r, statefulset)
+	replicas := desiredReplicas(statefulset)
+	statefulset.Status = appsv1.StatefulSetStatus{
+		ObservedGeneration: statefulset.Generation,
+		Replicas:           replicas,
+		ReadyReplicas:      replicas,
+		UpdatedReplicas:    replicas,
+		CurrentRevision:    "1",
+		UpdateRevision:     "1",
+	}
+	if err := r.Status().Update(context.Background(), statefulset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRolloutShortenedSteps(t *testing.T) {
+	model_serving := newRolloutModel(50)
+	model_serving.Status.Rollout.Step = 2
+	r := newTestReconciler(t, model_serving)
+
+	reconcileModel(t, r, model_serving)
+	markRolledOut(t, r, canaryStatefulSet(model_serving))
+	reconcileModel(t, r, model_serving)
+
+	getObject(t, r, model_serving)
+	rollout := model_serving.Status.Rollout
+	if rollout.Phase != mlv1alpha1.RolloutPromoting {
+		t.Errorf("phase = %s, want the canary promoted after the new last step", rollout.Phase)
+	}
+	if rollout.Step != 1 {
+		t.Errorf("step = %d, want the step after the new last one", rollout.Step)
+	}
+}
+
+func TestSplitReplicas(t *testing.T) {
+	tests := []struct {
+		replicas, weight, stable, canary int32
+	}{
+		{replicas: 10, weight: 10, stable: 9, canary: 1},
+		{replicas: 4, weight: 30, stable: 3, canary: 1},
+		{replicas: 4, weight: 50, stable: 2, canary: 2},
+		{replicas: 2, weight: 10, stable: 1, canary: 1},
+		{replicas: 1, weight: 10, stable: 1, canary: 1},
+		{replicas: 3, weight: 100, stable: 0, canary: 3},
+		{replicas: 0, weight: 50, stable: 0, canary: 0},
+	}
+	for _, tt := range tests {
+		stable, canary := splitReplicas(tt.replicas, tt.weight)
+		if stable != tt.stable || canary != tt.canary {
+			t.Errorf("splitReplicas(%d, %d) = %d, %d, want %d, %d", tt.replicas, tt.weight, stable, canary, tt.stable, tt.canary)
+		}
+	}
+}
+
+func TestRolloutReportsActualWeight(t *testing.T) {
+	model_serving := newRolloutModel(10, 100)
+	model_serving.Spec.Replicas = 2
+	r := newTestReconciler(t, model_serving)
+
+	reconcileModel(t, r, model_serving)
+
+	getObject(t, r, model_serving)
+	rollout := model_serving.Status.Rollout
+	if rollout.Weight != 50 {
+		t.Errorf("weight = %d, want the 50%% one of two replicas gets", rollout.Weight)
+	}
+	if rollout.Message != "Step 1/2 asks for 10% of traffic" {
+		t.Errorf("message = %q", rollout.Message)
+	}
+	canary := canaryStatefulSet(model_serving)
+	getObject(t, r, canary)
+	if *canary.Spec.Replicas != 1 {
+		t.Errorf("canary replicas = %d", *canary.Spec.Replicas)
+	}
+}
+
+func canaryPod(model_serving *mlv1alpha1.Model, restarts int32) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      model_serving.Name + "-canary-0",
+			Namespace: model_serving.Namespace,
+			Labels:    map[string]string{"serving": model_serving.Name, model.TrackLabel: model.CanaryTrack},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			PodIP: "127.0.0.1",
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "serving", Ready: true, RestartCount: restarts},
+			},
+		},
+	}
+}
+
+func TestRolloutPromotesCanary(t *testing.T) {
+	model_serving := newRolloutModel(50)
+	r := newTestReconciler(t, model_serving)
+
+	reconcileModel(t, r, model_serving)
+	stable := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "iris", Namespace: "team"}}
+	getObject(t, r, stable)
+	if image := servingContainer(t, stable.Spec.Template.Spec).Image; !strings.HasSuffix(image, ":0.6") {
+		t.Errorf("stable image = %s during the canary step", image)
+	}
+
+	expectPhase := func(phase mlv1alpha1.RolloutPhase) {
+		t.Helper()
+		getObject(t, r, model_serving)
+		if model_serving.Status.Rollout.Phase != phase {
+			t.Fatalf("phase = %s, want %s: %s", model_serving.Status.Rollout.Phase, phase, model_serving.Status.Rollout.Message)
+		}
+	}
+
+	markRolledOut(t, r, canaryStatefulSet(model_serving))
+	reconcileModel(t, r, model_serving)
+	expectPhase(mlv1alpha1.RolloutPromoting)
+
+	// The stable pods move to the new version before the canary goes.
+	reconcileModel(t, r, model_serving)
+	getObject(t, r, stable)
+	if image := servingContainer(t, stable.Spec.Template.Spec).Image; !strings.HasSuffix(image, ":0.7") {
+		t.Errorf("stable image = %s while promoting", image)
+	}
+	markRolledOut(t, r, stable)
+	reconcileModel(t, r, model_serving)
+	expectPhase(mlv1alpha1.RolloutSucceeded)
+	if model_serving.Status.Rollout.Stable.Version != "0.7" {
+		t.Errorf("stable revision = %+v", model_serving.Status.Rollout.Stable)
+	}
+	if !hasEvent(recordedEvents(r), corev1.EventTypeNormal, reasonRolloutPromoted) {
+		t.Errorf("no %s Event", reasonRolloutPromoted)
+	}
+
+	reconcileModel(t, r, model_serving)
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(canaryStatefulSet(model_serving)), &appsv1.StatefulSet{}); !apierrors.IsNotFound(err) {
+		t.Errorf("canary StatefulSet left after the promotion: %v", err)
+	}
+}
+
+func TestRolloutAbortsOnRestarts(t *testing.T) {
+	model_serving := newRolloutModel(50, 100)
+	model_serving.Spec.Rollout.Analysis = &mlv1alpha1.RolloutAnalysis{MaxRestarts: 1}
+	r := newTestReconciler(t, model_serving, canaryPod(model_serving, 2))
+
+	reconcileModel(t, r, model_serving)
+
+	getObject(t, r, model_serving)
+	if model_serving.Status.Rollout.Phase != mlv1alpha1.RolloutAborted {
+		t.Fatalf("phase = %s, want the canary aborted", model_serving.Status.Rollout.Phase)
+	}
+	if !hasEvent(recordedEvents(r), corev1.EventTypeWarning, reasonRolloutAborted) {
+		t.Errorf("no %s Event", reasonRolloutAborted)
+	}
+
+	reconcileModel(t, r, model_serving)
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(canaryStatefulSet(model_serving)), &appsv1.StatefulSet{}); !apierrors.IsNotFound(err) {
+		t.Errorf("canary StatefulSet left after the abort: %v", err)
+	}
+	stable := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "iris", Namespace: "team"}}
+	getObject(t, r, stable)
+	if image := servingContainer(t, stable.Spec.Template.Spec).Image; !strings.HasSuffix(image, ":0.6") {
+		t.Errorf("stable image = %s after the abort", image)
+	}
+	if *stable.Spec.Replicas != 4 {
+		t.Errorf("stable replicas = %d, want all of them back", *stable.Spec.Replicas)
+	}
+}
+
+func TestRolloutErrorRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		metrics string
+		phase   mlv1alpha1.RolloutPhase
+	}{
+		{
+			name: "below the threshold",
+			metrics: `# TYPE http_requests_total counter
+http_requests_total{code="200"} 990
+http_requests_total{code="503"} 10
+`,
+			phase: mlv1alpha1.RolloutProgressing,
+		},
+		{
+			name: "above the threshold",
+			metrics: `# TYPE http_requests_total counter
+http_requests_total{code="200"} 80
+http_requests_total{code="500"} 20
+`,
+			phase: mlv1alpha1.RolloutAborted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model_serving := newRolloutModel(50, 100)
+			metricsPort := metricsServer(t, tt.metrics)
+			model_serving.Spec.Monitoring = &mlv1alpha1.Monitoring{Port: &metricsPort}
+			maxErrorRate := resource.MustParse("5")
+			model_serving.Spec.Rollout.Analysis = &mlv1alpha1.RolloutAnalysis{MaxErrorRate: &maxErrorRate}
+			r := newTestReconciler(t, model_serving, canaryPod(model_serving, 0))
+
+			reconcileModel(t, r, model_serving)
+			markRolledOut(t, r, canaryStatefulSet(model_serving))
+			reconcileModel(t, r, model_serving)
+
+			getObject(t, r, model_serving)
+			rollout := model_serving.Status.Rollout
+			if rollout.Phase != tt.phase {
+				t.Errorf("phase = %s, want %s: %s", rollout.Phase, tt.phase, rollout.Message)
+			}
+			if tt.phase == mlv1alpha1.RolloutProgressing && rollout.Step != 1 {
+				t.Errorf("step = %d, want the canary moved to the next step", rollout.Step)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+	"github.com/kalkyai/model-serving-operator/pkg/runtimes"
+)
+
+const reasonRuntimeUnavailable = "RuntimeUnavailable"
+
+// servingRuntime is a ServingRuntime or ClusterServingRuntime.
+type servingRuntime struct {
+	kind string
+	name string
+	spec *mlv1alpha1.ServingRuntimeSpec
+}
+
+func (s *servingRuntime) String() string {
+	return s.kind + "/" + s.name
+}
+
+// modelFormat is the format runtimes are matched against.
+func modelFormat(model_serving *mlv1alpha1.Model) string {
+	if model_serving.Spec.Framework == "" {
+		return runtimes.SKLearn
+	}
+	return model_serving.Spec.Framework
+}
+
+// resolveRuntime finds the serving runtime of the model: the one it names or
+// else the one auto selected for its framework. nil means the built-in
+// runtime of the framework.
+func resolveRuntime(ctx context.Context, r client.Reader, model_serving *mlv1alpha1.Model) (*servingRuntime, error) {
+	format := modelFormat(model_serving)
+
+	if name := model_serving.Spec.Runtime; name != "" {
+		runtime, err := namedRuntime(ctx, r, model_serving.Namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		if runtime.spec.Disabled {
+			return nil, fmt.Errorf("serving runtime %s is disabled", runtime)
+		}
+		if _, ok := runtime.spec.Supports(format); !ok {
+			return nil, fmt.Errorf("serving runtime %s does not support format %s", runtime, format)
+		}
+		return runtime, nil
+	}
+
+	candidates, err := autoSelectRuntimes(ctx, r, model_serving.Namespace, format)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) > 0 {
+		return candidates[0], nil
+	}
+	if _, ok := runtimes.Lookup(format); !ok {
+		return nil, fmt.Errorf("no serving runtime supports format %s, the built-in runtimes serve %s",
+			format, strings.Join(runtimes.Frameworks(), ", "))
+	}
+	return nil, nil
+}
+
+// namedRuntime returns the ServingRuntime of the namespace, or else the
+// ClusterServingRuntime, with the name.
+func namedRuntime(ctx context.Context, r client.Reader, namespace string, name string) (*servingRuntime, error) {
+	namespaced := &mlv1alpha1.ServingRuntime{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, namespaced)
+	if err == nil {
+		return &servingRuntime{kind: "ServingRuntime", name: name, spec: &namespaced.Spec}, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	cluster := &mlv1alpha1.ClusterServingRuntime{}
+	err = r.Get(ctx, types.NamespacedName{Name: name}, cluster)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("serving runtime %s not found", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &servingRuntime{kind: "ClusterServingRuntime", name: name, spec: &cluster.Spec}, nil
+}
+
+// autoSelectRuntimes lists the enabled runtimes auto selecting format,
+// best first: runtimes of the namespace before cluster runtimes, then by
+// priority and name.
+func autoSelectRuntimes(ctx context.Context, r client.Reader, namespace string, format string) ([]*servingRuntime, error) {
+	type candidate struct {
+		runtime  *servingRuntime
+		cluster  bool
+		priority int32
+	}
+	candidates := []candidate{}
+	add := func(runtime *servingRuntime, cluster bool) {
+		supported, ok := runtime.spec.Supports(format)
+		if ok && supported.AutoSelect && !runtime.spec.Disabled {
+			candidates = append(candidates, candidate{runtime: runtime, cluster: cluster, priority: supported.Priority})
+		}
+	}
+
+	namespaced := &mlv1alpha1.ServingRuntimeList{}
+	if err := r.List(ctx, namespaced, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for i := range namespaced.Items {
+		item := &namespaced.Items[i]
+		add(&servingRuntime{kind: "ServingRuntime", name: item.Name, spec: &item.Spec}, false)
+	}
+
+	cluster := &mlv1alpha1.ClusterServingRuntimeList{}
+	if err := r.List(ctx, cluster); err != nil {
+		return nil, err
+	}
+	for i := range cluster.Items {
+		item := &cluster.Items[i]
+		add(&servingRuntime{kind: "ClusterServingRuntime", name: item.Name, spec: &item.Spec}, true)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.cluster != b.cluster {
+			return !a.cluster
+		}
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		return a.runtime.name < b.runtime.name
+	})
+
+	selected := make([]*servingRuntime, 0, len(candidates))
+	for _, c := range candidates {
+		selected = append(selected, c.runtime)
+	}
+	return selected, nil
+}
+
+// applyRuntime renders the serving container of mod from the template of
+// runtime.
+func applyRuntime(mod *model.ModelServing, runtime *servingRuntime) {
+	container := runtime.spec.Container.DeepCopy()
+	if len(container.Ports) > 0 {
+		mod.Port = container.Ports[0].ContainerPort
+	}
+	if len(container.Resources.Limits) == 0 && len(container.Resources.Requests) == 0 {
+		container.Resources = *runtime.spec.Resources.DeepCopy()
+	}
+	mod.Template = container
+	mod.Protocol = string(runtime.spec.Protocol)
+}
+
+// modelsForRuntime maps a ServingRuntime or ClusterServingRuntime to the
+// Models that use it or could select it.
+func (r *ModelReconciler) modelsForRuntime(obj client.Object) []reconcile.Request {
+	ctx := context.Background()
+
+	var kind string
+	var spec *mlv1alpha1.ServingRuntimeSpec
+	opts := []client.ListOption{}
+	switch runtime := obj.(type) {
+	case *mlv1alpha1.ServingRuntime:
+		kind, spec = "ServingRuntime", &runtime.Spec
+		opts = append(opts, client.InNamespace(runtime.Namespace))
+	case *mlv1alpha1.ClusterServingRuntime:
+		kind, spec = "ClusterServingRuntime", &runtime.Spec
+	default:
+		return nil
+	}
+	used := (&servingRuntime{kind: kind, name: obj.GetName()}).String()
+
+	models := &mlv1alpha1.ModelList{}
+	if err := r.List(ctx, models, opts...); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list models for serving runtime", "runtime", used)
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, item := range models.Items {
+		_, supported := spec.Supports(modelFormat(&item))
+		if item.Spec.Runtime == obj.GetName() || item.Status.Runtime == used || supported {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: item.Namespace, Name: item.Name},
+			})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	"github.com/kalkyai/model-serving-operator/pkg/config"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+	"github.com/kalkyai/model-serving-operator/pkg/runtimes"
+)
+
+// expectServing checks the image and port of the serving container of the
+// model's StatefulSet.
+func expectServing(t *testing.T, r *ModelReconciler, image string, port int32) {
+	t.Helper()
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "iris", Namespace: "team"}}
+	getObject(t, r, statefulset)
+	container := servingContainer(t, statefulset.Spec.Template.Spec)
+	if container.Image != image {
+		t.Errorf("image = %s, want %s", container.Image, image)
+	}
+	if len(container.Ports) == 0 || container.Ports[0].ContainerPort != port {
+		t.Errorf("ports = %v, want %d", container.Ports, port)
+	}
+}
+
+func TestFrameworkChangeFollowsRuntime(t *testing.T) {
+	model_serving := newTestModel()
+	model_serving.Spec.Framework = runtimes.SKLearn
+	r := newTestReconciler(t, model_serving)
+
+	reconcileModel(t, r, model_serving)
+	expectServing(t, r, "plasmashadow/model_serving:0.6", 4000)
+
+	getObject(t, r, model_serving)
+	model_serving.Spec.Framework = runtimes.XGBoost
+	if err := r.Update(context.Background(), model_serving); err != nil {
+		t.Fatal(err)
+	}
+	reconcileModel(t, r, model_serving)
+	expectServing(t, r, "kserve/xgbserver:v0.9.0", 8080)
+}
+
+// runtimeSpec serves format, auto selected with priority when autoSelect.
+func runtimeSpec(format string, autoSelect bool, priority int32) mlv1alpha1.ServingRuntimeSpec {
+	return mlv1alpha1.ServingRuntimeSpec{
+		SupportedModelFormats: []mlv1alpha1.SupportedModelFormat{{Name: format, AutoSelect: autoSelect, Priority: priority}},
+		Protocol:              mlv1alpha1.ProtocolV2,
+		Container: corev1.Container{
+			Image: "nvcr.io/nvidia/tritonserver:22.05-py3",
+			Ports: []corev1.ContainerPort{{ContainerPort: 9000}},
+		},
+	}
+}
+
+func namespacedRuntime(namespace, name string, spec mlv1alpha1.ServingRuntimeSpec) *mlv1alpha1.ServingRuntime {
+	return &mlv1alpha1.ServingRuntime{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}, Spec: spec}
+}
+
+func clusterRuntime(name string, spec mlv1alpha1.ServingRuntimeSpec) *mlv1alpha1.ClusterServingRuntime {
+	return &mlv1alpha1.ClusterServingRuntime{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: spec}
+}
+
+func disabled(spec mlv1alpha1.ServingRuntimeSpec) mlv1alpha1.ServingRuntimeSpec {
+	spec.Disabled = true
+	return spec
+}
+
+func TestResolveRuntime(t *testing.T) {
+	tests := []struct {
+		name      string
+		framework string
+		runtime   string
+		objs      []client.Object
+		want      string
+		err       bool
+	}{
+		{name: "built-in runtime", framework: runtimes.XGBoost},
+		{name: "unsupported format", framework: "tensorflow", err: true},
+		{
+			name: "named runtime of the namespace before the cluster", runtime: "triton",
+			objs: []client.Object{
+				clusterRuntime("triton", runtimeSpec(runtimes.SKLearn, false, 0)),
+				namespacedRuntime("team", "triton", runtimeSpec(runtimes.SKLearn, false, 0)),
+			},
+			want: "ServingRuntime/triton",
+		},
+		{
+			name: "named cluster runtime", runtime: "triton",
+			objs: []client.Object{
+				clusterRuntime("triton", runtimeSpec(runtimes.SKLearn, false, 0)),
+				namespacedRuntime("other", "triton", runtimeSpec(runtimes.SKLearn, false, 0)),
+			},
+			want: "ClusterServingRuntime/triton",
+		},
+		{name: "named runtime not found", runtime: "triton", err: true},
+		{
+			name: "named runtime disabled", runtime: "triton",
+			objs: []client.Object{namespacedRuntime("team", "triton", disabled(runtimeSpec(runtimes.SKLearn, false, 0)))},
+			err:  true,
+		},
+		{
+			name: "named runtime without the format", runtime: "triton", framework: runtimes.ONNX,
+			objs: []client.Object{namespacedRuntime("team", "triton", runtimeSpec(runtimes.SKLearn, false, 0))},
+			err:  true,
+		},
+		{
+			name: "runtimes of the namespace before the cluster",
+			objs: []client.Object{
+				clusterRuntime("cluster", runtimeSpec(runtimes.SKLearn, true, 10)),
+				namespacedRuntime("team", "namespaced", runtimeSpec(runtimes.SKLearn, true, 1)),
+			},
+			want: "ServingRuntime/namespaced",
+		},
+		{
+			name: "highest priority first",
+			objs: []client.Object{
+				namespacedRuntime("team", "low", runtimeSpec(runtimes.SKLearn, true, 1)),
+				namespacedRuntime("team", "high", runtimeSpec(runtimes.SKLearn, true, 5)),
+			},
+			want: "ServingRuntime/high",
+		},
+		{
+			name: "name orders runtimes of the same priority",
+			objs: []client.Object{
+				clusterRuntime("beta", runtimeSpec(runtimes.SKLearn, true, 1)),
+				clusterRuntime("alpha", runtimeSpec(runtimes.SKLearn, true, 1)),
+			},
+			want: "ClusterServingRuntime/alpha",
+		},
+		{
+			name: "disabled runtimes are skipped",
+			objs: []client.Object{
+				namespacedRuntime("team", "disabled", disabled(runtimeSpec(runtimes.SKLearn, true, 10))),
+				clusterRuntime("enabled", runtimeSpec(runtimes.SKLearn, true, 0)),
+			},
+			want: "ClusterServingRuntime/enabled",
+		},
+		{
+			name: "runtimes not auto selecting are skipped",
+			objs: []client.Object{namespacedRuntime("team", "manual", runtimeSpec(runtimes.SKLearn, false, 10))},
+		},
+		{
+			name: "runtimes of other namespaces and formats are skipped",
+			objs: []client.Object{
+				namespacedRuntime("other", "elsewhere", runtimeSpec(runtimes.SKLearn, true, 10)),
+				namespacedRuntime("team", "onnx", runtimeSpec(runtimes.ONNX, true, 10)),
+			},
+		},
+		{
+			name: "format only a runtime serves", framework: "tensorflow",
+			objs: []client.Object{clusterRuntime("tfserving", runtimeSpec("tensorflow", true, 0))},
+			want: "ClusterServingRuntime/tfserving",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model_serving := newTestModel()
+			model_serving.Spec.Framework = tt.framework
+			model_serving.Spec.Runtime = tt.runtime
+			r := newTestReconciler(t, tt.objs...)
+
+			runtime, err := resolveRuntime(context.Background(), r, model_serving)
+			if (err != nil) != tt.err {
+				t.Fatalf("error = %v, want an error %t", err, tt.err)
+			}
+			got := ""
+			if runtime != nil {
+				got = runtime.String()
+			}
+			if got != tt.want {
+				t.Errorf("runtime = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyRuntime(t *testing.T) {
+	limits := corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("4Gi")}}
+	requests := corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}}
+
+	spec := runtimeSpec(runtimes.SKLearn, true, 0)
+	spec.Resources = limits
+	mod := &model.ModelServing{Name: "iris", Port: 4000}
+	applyRuntime(mod, &servingRuntime{kind: "ServingRuntime", name: "triton", spec: &spec})
+
+	if mod.Port != 9000 {
+		t.Errorf("port = %d, want the first port of the container", mod.Port)
+	}
+	if mod.Protocol != string(mlv1alpha1.ProtocolV2) {
+		t.Errorf("protocol = %s", mod.Protocol)
+	}
+	if mod.Template.Image != spec.Container.Image || !equalResources(mod.Template.Resources, limits) {
+		t.Errorf("template = %+v, want the container with the runtime resources", mod.Template)
+	}
+
+	// Resources of the container template win, and the runtime is not
+	// changed by rendering.
+	spec.Container.Resources = requests
+	spec.Container.Ports = nil
+	mod = &model.ModelServing{Name: "iris", Port: 4000}
+	applyRuntime(mod, &servingRuntime{kind: "ServingRuntime", name: "triton", spec: &spec})
+	if mod.Port != 4000 {
+		t.Errorf("port = %d, want the port of the model without container ports", mod.Port)
+	}
+	if !equalResources(mod.Template.Resources, requests) {
+		t.Errorf("resources = %+v, want the ones of the container", mod.Template.Resources)
+	}
+	mod.Template.Image = "changed"
+	if spec.Container.Image == "changed" {
+		t.Error("rendering changed the runtime")
+	}
+}
+
+func equalResources(a, b corev1.ResourceRequirements) bool {
+	return a.Limits.Memory().Equal(*b.Limits.Memory()) && a.Requests.Cpu().Equal(*b.Requests.Cpu())
+}
+
+func TestModelUsesServingRuntime(t *testing.T) {
+	model_serving := newTestModel()
+	r := newTestReconciler(t, model_serving, namespacedRuntime("team", "triton", runtimeSpec(runtimes.SKLearn, true, 0)))
+
+	reconcileModel(t, r, model_serving)
+	expectServing(t, r, "nvcr.io/nvidia/tritonserver:22.05-py3", 9000)
+
+	getObject(t, r, model_serving)
+	if model_serving.Status.Runtime != "ServingRuntime/triton" {
+		t.Errorf("status runtime = %q", model_serving.Status.Runtime)
+	}
+}
+
+func TestNewModelServingRuntime(t *testing.T) {
+	model_serving := newTestModel()
+	model_serving.Spec.Framework = "tensorflow"
+	if _, err := newModelServing(model_serving, config.Defaults{}, nil); err == nil {
+		t.Error("want an error for a format without a built-in runtime")
+	}
+
+	// A ServingRuntime serving the format takes none of the settings of a
+	// built-in runtime.
+	spec := runtimeSpec("tensorflow", true, 0)
+	mod, err := newModelServing(model_serving, config.Defaults{}, &servingRuntime{kind: "ServingRuntime", name: "tfserving", spec: &spec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mod.Runtime.ReadinessPath != "" || len(mod.Runtime.Args) > 0 {
+		t.Errorf("runtime = %+v, want none of the built-in runtimes", mod.Runtime)
+	}
+	if mod.Template == nil || mod.Port != 9000 {
+		t.Errorf("template = %v, port = %d, want the ServingRuntime container", mod.Template, mod.Port)
+	}
+}
@@ -0,0 +1,355 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	"github.com/kalkyai/model-serving-operator/pkg/fetcher"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+	"github.com/kalkyai/model-serving-operator/pkg/storage"
+)
+
+// Waiting reasons that mean a serving container will not come up on its own.
+var failingReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// podFailure returns the reason and message of the first serving pod that
+// is stuck failing, in its init or serving containers, or empty strings if
+// none is.
+func podFailure(pods []corev1.Pod) (string, string) {
+	for _, pod := range pods {
+		statuses := append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+		statuses = append(statuses, pod.Status.ContainerStatuses...)
+		for _, cs := range statuses {
+			if cs.State.Waiting != nil && failingReasons[cs.State.Waiting.Reason] {
+				return cs.State.Waiting.Reason, fmt.Sprintf("pod %s: %s", pod.Name, cs.State.Waiting.Message)
+			}
+		}
+	}
+	return "", ""
+}
+
+// servingContainerReady reports whether any serving container is ready,
+// which means a replica managed to load the model artifact.
+func servingContainerReady(pods []corev1.Pod) bool {
+	for _, pod := range pods {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Name == "serving" && cs.Ready {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// crashLooping reports whether a serving container keeps exiting, which is
+// how the serving image reports a model it could not download or load.
+func crashLooping(pods []corev1.Pod) (string, bool) {
+	for _, pod := range pods {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Name != "serving" || cs.State.Waiting == nil || cs.State.Waiting.Reason != "CrashLoopBackOff" {
+				continue
+			}
+			if t := cs.LastTerminationState.Terminated; t != nil && t.Message != "" {
+				return t.Message, true
+			}
+			return fmt.Sprintf("serving container in pod %s is crash looping", pod.Name), true
+		}
+	}
+	return "", false
+}
+
+// fetchReports reads the reports of the fetcher init containers from their
+// termination messages. A failed fetcher keeps its report in the last
+// termination state while it waits to be restarted.
+func fetchReports(pods []corev1.Pod) []fetcher.Report {
+	reports := []fetcher.Report{}
+	for _, pod := range pods {
+		for _, cs := range pod.Status.InitContainerStatuses {
+			if cs.Name != model.FetcherContainer {
+				continue
+			}
+			terminated := cs.State.Terminated
+			if terminated == nil {
+				terminated = cs.LastTerminationState.Terminated
+			}
+			if terminated == nil {
+				continue
+			}
+			if report, ok := fetcher.ParseReport(terminated.Message); ok {
+				reports = append(reports, report)
+			}
+		}
+	}
+	return reports
+}
+
+// fetchedDigest returns the digest the fetchers downloaded for uri.
+func fetchedDigest(reports []fetcher.Report, uri string) string {
+	for _, report := range reports {
+		if report.Reason == "" && report.URI == uri {
+			return report.Digest
+		}
+	}
+	return ""
+}
+
+// fetchFailure returns the first report failing for reason.
+func fetchFailure(reports []fetcher.Report, reason string) *fetcher.Report {
+	for i := range reports {
+		if reports[i].Reason == reason {
+			return &reports[i]
+		}
+	}
+	return nil
+}
+
+// rolledOut reports whether the StatefulSet controller has caught up with
+// the latest template and every desired replica is ready.
+func rolledOut(statefulset *appsv1.StatefulSet) bool {
+	replicas := desiredReplicas(statefulset)
+	return statefulset.Status.ObservedGeneration >= statefulset.Generation &&
+		statefulset.Status.UpdateRevision == statefulset.Status.CurrentRevision &&
+		statefulset.Status.UpdatedReplicas == replicas &&
+		statefulset.Status.ReadyReplicas == replicas
+}
+
+func desiredReplicas(statefulset *appsv1.StatefulSet) int32 {
+	if statefulset.Spec.Replicas == nil {
+		return 1
+	}
+	return *statefulset.Spec.Replicas
+}
+
+// updateStatus recomputes the model status from the owned StatefulSet and its
+// pods. reconcileErr is the error, if any, hit while reconciling resources.
+func (r *ModelReconciler) updateStatus(ctx context.Context, model_serving *mlv1alpha1.Model, reconcileErr error) error {
+	status := &model_serving.Status
+	generation := model_serving.Generation
+
+	wasProgressing := meta.IsStatusConditionTrue(status.Conditions, mlv1alpha1.ConditionProgressing)
+	var progressingSince time.Time
+	if wasProgressing {
+		progressingSince = meta.FindStatusCondition(status.Conditions, mlv1alpha1.ConditionProgressing).LastTransitionTime.Time
+	}
+	// A model is ready for the first time before any revision served.
+	firstReady := len(status.History) == 0 && !meta.IsStatusConditionTrue(status.Conditions, mlv1alpha1.ConditionReady)
+	wasArtifactFailing := meta.IsStatusConditionFalse(status.Conditions, mlv1alpha1.ConditionArtifactAvailable)
+	wasArtifactUnverified := meta.IsStatusConditionFalse(status.Conditions, mlv1alpha1.ConditionArtifactVerified)
+
+	setCondition := func(conditionType string, conditionStatus metav1.ConditionStatus, reason, message string) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               conditionType,
+			Status:             conditionStatus,
+			Reason:             reason,
+			Message:            message,
+			ObservedGeneration: generation,
+		})
+	}
+
+	status.ObservedGeneration = generation
+
+	statefulset := &appsv1.StatefulSet{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(model_serving), statefulset)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	found := err == nil
+
+	selector := labels.Set{"serving": model_serving.Name}
+	status.Selector = selector.String()
+
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods,
+		client.InNamespace(model_serving.Namespace),
+		client.MatchingLabels(selector),
+	); err != nil {
+		return err
+	}
+	r.downloads.observe(client.ObjectKeyFromObject(model_serving), pods.Items)
+
+	status.ReadyReplicas = 0
+	status.Replicas = 0
+	if found {
+		status.ReadyReplicas = statefulset.Status.ReadyReplicas
+		status.Replicas = statefulset.Status.Replicas
+	}
+
+	status.DesiredReplicas = model_serving.Spec.Replicas
+	if model_serving.Spec.Autoscaling != nil {
+		status.DesiredReplicas = status.Replicas
+		replicas, ok, err := r.autoscalerDesiredReplicas(ctx, model_serving)
+		if err != nil {
+			return err
+		}
+		if ok && replicas > 0 {
+			status.DesiredReplicas = replicas
+		}
+	}
+
+	if model_serving.Spec.IdleTimeout == nil {
+		meta.RemoveStatusCondition(&status.Conditions, mlv1alpha1.ConditionIdle)
+	} else if idle, _ := idleFor(model_serving, time.Now()); idle {
+		status.DesiredReplicas = 0
+		setCondition(mlv1alpha1.ConditionIdle, metav1.ConditionTrue, "NoRequests",
+			fmt.Sprintf("No requests since %s, scaled to zero", lastRequest(model_serving).UTC().Format(time.RFC3339)))
+	} else {
+		setCondition(mlv1alpha1.ConditionIdle, metav1.ConditionFalse, "Serving",
+			fmt.Sprintf("Scaling to zero after %s without requests", model_serving.Spec.IdleTimeout.Duration))
+	}
+
+	reports := fetchReports(pods.Items)
+	desired := desiredRevision(model_serving)
+
+	switch {
+	case servingContainerReady(pods.Items):
+		setCondition(mlv1alpha1.ConditionArtifactAvailable, metav1.ConditionTrue, "ModelLoaded", "A serving replica loaded the model artifact")
+	case fetchFailure(reports, fetcher.ReasonDownloadFailed) != nil:
+		failure := fetchFailure(reports, fetcher.ReasonDownloadFailed)
+		setCondition(mlv1alpha1.ConditionArtifactAvailable, metav1.ConditionFalse, failure.Reason, failure.Message)
+		if !wasArtifactFailing {
+			r.Recorder.Eventf(model_serving, corev1.EventTypeWarning, reasonArtifactDownloadFailed, "Failed to download %s: %s", failure.URI, failure.Message)
+		}
+	default:
+		if message, ok := crashLooping(pods.Items); ok {
+			setCondition(mlv1alpha1.ConditionArtifactAvailable, metav1.ConditionFalse, "ModelLoadFailed", message)
+			if !wasArtifactFailing {
+				r.Recorder.Event(model_serving, corev1.EventTypeWarning, reasonArtifactDownloadFailed, message)
+			}
+		} else {
+			setCondition(mlv1alpha1.ConditionArtifactAvailable, metav1.ConditionUnknown, "Pending", "No serving replica has loaded the model yet")
+		}
+	}
+
+	desiredURI := storage.URI(revisionStorage(model_serving, desired.Location))
+	if mismatch := fetchFailure(reports, fetcher.ReasonChecksumMismatch); mismatch != nil {
+		status.ArtifactDigest = ""
+		setCondition(mlv1alpha1.ConditionArtifactVerified, metav1.ConditionFalse, mismatch.Reason, mismatch.Message)
+		if !wasArtifactUnverified {
+			r.Recorder.Event(model_serving, corev1.EventTypeWarning, reasonArtifactVerificationFailed, mismatch.Message)
+		}
+	} else if digest := fetchedDigest(reports, desiredURI); digest != "" {
+		status.ArtifactDigest = digest
+		if expectedDigest(model_serving, desired) != "" {
+			setCondition(mlv1alpha1.ConditionArtifactVerified, metav1.ConditionTrue, "ChecksumMatched",
+				fmt.Sprintf("Artifact %s has the expected digest %s", desiredURI, digest))
+		} else {
+			setCondition(mlv1alpha1.ConditionArtifactVerified, metav1.ConditionTrue, "DigestRecorded",
+				fmt.Sprintf("Artifact %s has digest %s, no checksum was given to verify it", desiredURI, digest))
+		}
+	} else {
+		status.ArtifactDigest = ""
+		setCondition(mlv1alpha1.ConditionArtifactVerified, metav1.ConditionUnknown, "Pending", "No serving replica has downloaded the artifact yet")
+	}
+
+	rollout := status.Rollout
+	canaryRunning := rollout != nil && rollout.Canary != nil &&
+		(rollout.Phase == mlv1alpha1.RolloutProgressing || rollout.Phase == mlv1alpha1.RolloutPromoting)
+
+	reason, message := podFailure(pods.Items)
+	switch {
+	case reconcileErr != nil:
+		setCondition(mlv1alpha1.ConditionDegraded, metav1.ConditionTrue, "ReconcileFailed", reconcileErr.Error())
+	case rollout != nil && rollout.Canary != nil && rollout.Phase == mlv1alpha1.RolloutAborted:
+		setCondition(mlv1alpha1.ConditionDegraded, metav1.ConditionTrue, "RolloutAborted", rollout.Message)
+	case reason != "":
+		setCondition(mlv1alpha1.ConditionDegraded, metav1.ConditionTrue, reason, message)
+	default:
+		setCondition(mlv1alpha1.ConditionDegraded, metav1.ConditionFalse, "AsExpected", "")
+	}
+
+	switch {
+	case !found:
+		setCondition(mlv1alpha1.ConditionProgressing, metav1.ConditionTrue, "Creating", "Waiting for the StatefulSet to be created")
+		trackRollout(model_serving, desired)
+		setCondition(mlv1alpha1.ConditionReady, metav1.ConditionFalse, "Creating", "Waiting for the StatefulSet to be created")
+	case canaryRunning:
+		status.RollingOut = nil
+		setCondition(mlv1alpha1.ConditionProgressing, metav1.ConditionTrue, "CanaryRollout",
+			fmt.Sprintf("%s, %d%% goes to version %s", rollout.Message, rollout.Weight, rollout.Canary.Version))
+		if rolledOut(statefulset) {
+			setCondition(mlv1alpha1.ConditionReady, metav1.ConditionTrue, "ReplicasReady",
+				fmt.Sprintf("%d/%d stable replicas ready", statefulset.Status.ReadyReplicas, desiredReplicas(statefulset)))
+		} else {
+			reason, message := notReady(statefulset, pods.Items, "stable replicas")
+			setCondition(mlv1alpha1.ConditionReady, metav1.ConditionFalse, reason, message)
+		}
+	case rolledOut(statefulset):
+		setCondition(mlv1alpha1.ConditionProgressing, metav1.ConditionFalse, "RolloutComplete", "All replicas serve the current spec")
+		setCondition(mlv1alpha1.ConditionReady, metav1.ConditionTrue, "ReplicasReady",
+			fmt.Sprintf("%d/%d replicas ready", statefulset.Status.ReadyReplicas, desiredReplicas(statefulset)))
+		served := servedRevision(statefulset)
+		status.Version = served.Version
+		recordRevision(model_serving, served,
+			fetchedDigest(reports, storage.URI(revisionStorage(model_serving, served.Location))))
+		if wasProgressing {
+			r.Recorder.Eventf(model_serving, corev1.EventTypeNormal, reasonRolloutFinished,
+				"Version %s is served by %d replicas", status.Version, statefulset.Status.ReadyReplicas)
+			if status.RollingOut != nil && status.RollingOut.ModelRevision == served {
+				progressingSince = status.RollingOut.StartedAt.Time
+			}
+			rolloutDuration.Observe(time.Since(progressingSince).Seconds())
+		}
+		status.RollingOut = nil
+	default:
+		trackRollout(model_serving, desired)
+		setCondition(mlv1alpha1.ConditionProgressing, metav1.ConditionTrue, "RollingOut",
+			fmt.Sprintf("%d/%d replicas updated", statefulset.Status.UpdatedReplicas, desiredReplicas(statefulset)))
+		reason, message := notReady(statefulset, pods.Items, "replicas")
+		setCondition(mlv1alpha1.ConditionReady, metav1.ConditionFalse, reason, message)
+	}
+
+	// An idle model has no replica to serve requests, they wait in the
+	// activator until it scales up again.
+	if meta.IsStatusConditionTrue(status.Conditions, mlv1alpha1.ConditionIdle) {
+		setCondition(mlv1alpha1.ConditionReady, metav1.ConditionFalse, "Idle",
+			"Scaled to zero for lack of requests, the next request scales the model up")
+	}
+
+	if firstReady && meta.IsStatusConditionTrue(status.Conditions, mlv1alpha1.ConditionReady) {
+		timeToReady.Observe(time.Since(model_serving.CreationTimestamp.Time).Seconds())
+	}
+
+	if found {
+		r.detectFailedRollout(model_serving, statefulset, pods.Items)
+	}
+	if rollback := status.RolledBack; rollback != nil {
+		setCondition(mlv1alpha1.ConditionRolledBack, metav1.ConditionTrue, rollback.Reason, rollback.Message)
+	} else {
+		setCondition(mlv1alpha1.ConditionRolledBack, metav1.ConditionFalse, "AsExpected", "")
+	}
+
+	return r.Status().Update(ctx, model_serving)
+}
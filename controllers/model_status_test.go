@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestModelScaleSubresource(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "config", "crd", "bases", "ml.kalkyai.com_models.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type scale struct {
+		SpecReplicasPath   string `json:"specReplicasPath"`
+		StatusReplicasPath string `json:"statusReplicasPath"`
+		LabelSelectorPath  string `json:"labelSelectorPath"`
+	}
+	crd := struct {
+		Spec struct {
+			Versions []struct {
+				Name         string `json:"name"`
+				Subresources struct {
+					Scale *scale `json:"scale"`
+				} `json:"subresources"`
+			} `json:"versions"`
+		} `json:"spec"`
+	}{}
+	if err := yaml.Unmarshal(data, &crd); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(crd.Spec.Versions) == 0 {
+		t.Fatal("no versions in the CRD")
+	}
+	want := scale{SpecReplicasPath: ".spec.replicas", StatusReplicasPath: ".status.replicas", LabelSelectorPath: ".status.selector"}
+	for _, version := range crd.Spec.Versions {
+		if got := version.Subresources.Scale; got == nil || *got != want {
+			t.Errorf("scale subresource of %s = %+v, want %+v", version.Name, got, want)
+		}
+	}
+}
+
+func TestStatusScale(t *testing.T) {
+	model_serving := newTestModel()
+	model_serving.Spec.Replicas = 2
+	r := newTestReconciler(t, model_serving)
+	reconcileModel(t, r, model_serving)
+
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "iris", Namespace: "team"}}
+	getObject(t, r, statefulset)
+	statefulset.Status.Replicas, statefulset.Status.ReadyReplicas = 2, 1
+	if err := r.Update(context.Background(), statefulset); err != nil {
+		t.Fatal(err)
+	}
+	reconcileModel(t, r, model_serving)
+
+	getObject(t, r, model_serving)
+	if model_serving.Status.Selector != "serving=iris" {
+		t.Errorf("selector = %q, want the label of the serving pods", model_serving.Status.Selector)
+	}
+	if model_serving.Status.Replicas != 2 || model_serving.Status.ReadyReplicas != 1 {
+		t.Errorf("replicas = %d, ready = %d, want those of the StatefulSet", model_serving.Status.Replicas, model_serving.Status.ReadyReplicas)
+	}
+}
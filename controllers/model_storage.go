@@ -0,0 +1,203 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	model "github.com/kalkyai/model-serving-operator/pkg/model"
+	"github.com/kalkyai/model-serving-operator/pkg/storage"
+)
+
+// artifactStorage returns where the model artifact is stored. Models using
+// the deprecated bucket fields are mapped onto the S3 backend, with inline
+// credentials read from the operator-managed Secret.
+func artifactStorage(model_serving *mlv1alpha1.Model) *mlv1alpha1.ModelStorage {
+	if model_serving.Spec.Storage != nil {
+		return model_serving.Spec.Storage
+	}
+
+	s3 := &mlv1alpha1.S3Storage{
+		Endpoint:             model_serving.Spec.Endpoint,
+		Bucket:               model_serving.Spec.Bucket,
+		Key:                  model_serving.Spec.Location,
+		CredentialsSecretRef: model_serving.Spec.CredentialsSecretRef,
+	}
+	if hasInlineCredentials(model_serving) {
+		managed := &model.ModelServing{Name: model_serving.Name}
+		s3.CredentialsSecretRef = &mlv1alpha1.CredentialsSecretRef{Name: managed.SecretName()}
+	}
+	return &mlv1alpha1.ModelStorage{S3: s3}
+}
+
+// artifactLocation is the location recorded for a revision: the object key
+// for the deprecated bucket fields, the storage URI otherwise.
+func artifactLocation(model_serving *mlv1alpha1.Model) string {
+	if model_serving.Spec.Storage == nil {
+		return model_serving.Spec.Location
+	}
+	return storage.URI(model_serving.Spec.Storage)
+}
+
+// revisionStorage points the storage of the model at the location of a
+// revision. A location the current backend cannot reach, such as one left
+// in the history before the model moved to another backend, falls back to
+// the storage in the spec.
+func revisionStorage(model_serving *mlv1alpha1.Model, location string) *mlv1alpha1.ModelStorage {
+	spec := artifactStorage(model_serving)
+	if location == "" {
+		return spec
+	}
+	relocated, err := storage.Relocate(spec, location)
+	if err != nil {
+		return spec
+	}
+	return relocated
+}
+
+// locateArtifact tells the fetcher where to download the artifact of the
+// revision mod serves, mod.ModelURL, and the serving container where to
+// load it from.
+func locateArtifact(model_serving *mlv1alpha1.Model, mod *model.ModelServing) error {
+	store := revisionStorage(model_serving, mod.ModelURL)
+	config, err := json.Marshal(store)
+	if err != nil {
+		return err
+	}
+
+	mod.Storage = string(config)
+	mod.StorageURI = storage.URI(store)
+	mod.ArtifactPath = path.Join(model.ArtifactDir, artifactFile(mod.StorageURI))
+	mod.ArtifactDigest = expectedDigest(model_serving, mlv1alpha1.ModelRevision{Version: mod.Version, Location: mod.ModelURL})
+	mod.ArtifactClaim = ""
+	mod.StorageSecrets = nil
+	mod.Endpoint, mod.Bucket = "", ""
+
+	switch {
+	case store.S3 != nil:
+		mod.Endpoint = store.S3.Endpoint
+		mod.Bucket = store.S3.Bucket
+	case store.PVC != nil:
+		mod.ArtifactClaim = store.PVC.ClaimName
+	}
+	for _, ref := range storageSecrets(store) {
+		mod.StorageSecrets = append(mod.StorageSecrets, ref.name)
+	}
+	return nil
+}
+
+// artifactFile names the downloaded artifact after the last element of its
+// URI, so the serving container can tell its format by the extension.
+func artifactFile(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.Path != "" {
+		uri = u.Path
+	}
+	name := path.Base(uri)
+	if name == "." || name == "/" {
+		return "model"
+	}
+	return name
+}
+
+// expectedDigest is the digest the artifact of revision must have: the
+// checksum in the spec for the spec revision, or the digest recorded when an
+// earlier revision was served.
+func expectedDigest(model_serving *mlv1alpha1.Model, revision mlv1alpha1.ModelRevision) string {
+	if revision == specRevision(model_serving) {
+		if artifact := model_serving.Spec.Artifact; artifact != nil && artifact.SHA256 != "" {
+			return "sha256:" + artifact.SHA256
+		}
+		return ""
+	}
+	for _, record := range model_serving.Status.History {
+		if record.ModelRevision == revision {
+			return record.Digest
+		}
+	}
+	return ""
+}
+
+// secretKeys is a Secret the storage of a model reads, with the keys it
+// needs from it.
+type secretKeys struct {
+	name string
+	keys []string
+}
+
+// storageSecrets lists the Secrets the storage of the model references.
+func storageSecrets(store *mlv1alpha1.ModelStorage) []secretKeys {
+	selector := func(ref *corev1.SecretKeySelector) []secretKeys {
+		if ref == nil {
+			return nil
+		}
+		return []secretKeys{{name: ref.Name, keys: []string{ref.Key}}}
+	}
+
+	switch {
+	case store.S3 != nil:
+		ref := store.S3.CredentialsSecretRef
+		if ref == nil {
+			return nil
+		}
+		accessKeyKey, secretKeyKey := ref.AccessKeyKey, ref.SecretKeyKey
+		if accessKeyKey == "" {
+			accessKeyKey = storage.DefaultAccessKeyKey
+		}
+		if secretKeyKey == "" {
+			secretKeyKey = storage.DefaultSecretKeyKey
+		}
+		return []secretKeys{{name: ref.Name, keys: []string{accessKeyKey, secretKeyKey}}}
+	case store.GCS != nil:
+		return selector(store.GCS.ServiceAccountKeySecretRef)
+	case store.AzureBlob != nil:
+		return selector(store.AzureBlob.SASTokenSecretRef)
+	case store.HTTP != nil:
+		return selector(store.HTTP.AuthorizationSecretRef)
+	case store.OCI != nil:
+		if ref := store.OCI.PullSecretRef; ref != nil {
+			return []secretKeys{{name: ref.Name, keys: []string{corev1.DockerConfigJsonKey}}}
+		}
+	}
+	return nil
+}
+
+// secrets reads the Secrets of the model's namespace for a storage backend.
+// The inline credentials of the deprecated fields are served directly, so
+// they stay readable while the managed Secret is garbage collected.
+func (r *ModelReconciler) secrets(model_serving *mlv1alpha1.Model) storage.Secrets {
+	managed := &model.ModelServing{Name: model_serving.Name}
+	return func(ctx context.Context, name string) (map[string][]byte, error) {
+		if hasInlineCredentials(model_serving) && name == managed.SecretName() {
+			return map[string][]byte{
+				storage.DefaultAccessKeyKey: []byte(model_serving.Spec.Accesskey),
+				storage.DefaultSecretKeyKey: []byte(model_serving.Spec.SecretKey),
+			}, nil
+		}
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: model_serving.Namespace, Name: name}, secret); err != nil {
+			return nil, err
+		}
+		return secret.Data, nil
+	}
+}
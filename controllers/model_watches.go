@@ -0,0 +1,125 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+// credentialsSecretField indexes Models by the Secrets their storage reads
+// credentials from.
+const credentialsSecretField = ".spec.storage.secretNames"
+
+func indexCredentialsSecret(obj client.Object) []string {
+	model_serving := obj.(*mlv1alpha1.Model)
+	if hasInlineCredentials(model_serving) {
+		return nil
+	}
+	names := []string{}
+	for _, ref := range storageSecrets(artifactStorage(model_serving)) {
+		names = append(names, ref.name)
+	}
+	return names
+}
+
+// modelsForSecret maps a Secret to the Models referencing it, so creating a
+// missing Secret or rotating keys is picked up.
+func (r *ModelReconciler) modelsForSecret(obj client.Object) []reconcile.Request {
+	ctx := context.Background()
+
+	models := &mlv1alpha1.ModelList{}
+	if err := r.List(ctx, models,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{credentialsSecretField: obj.GetName()},
+	); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list models for secret", "secret", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(models.Items))
+	for _, item := range models.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: item.Namespace, Name: item.Name},
+		})
+	}
+	return requests
+}
+
+// modelForPod maps a serving pod back to its Model through the serving label.
+func modelForPod(obj client.Object) []reconcile.Request {
+	name, ok := obj.GetLabels()["serving"]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: name},
+	}}
+}
+
+// podState summarises the parts of a pod status the Model status is built
+// from, including the fetcher init container reporting the artifact.
+func podState(pod *corev1.Pod) string {
+	state := string(pod.Status.Phase)
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			state += "/" + string(condition.Status)
+		}
+	}
+	for _, cs := range pod.Status.InitContainerStatuses {
+		state += fmt.Sprintf("/%s:%d", cs.Name, cs.RestartCount)
+		if cs.State.Waiting != nil {
+			state += ":" + cs.State.Waiting.Reason
+		}
+		if cs.State.Terminated != nil {
+			state += ":" + cs.State.Terminated.Reason
+		}
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		state += fmt.Sprintf("/%s:%t:%d", cs.Name, cs.Ready, cs.RestartCount)
+		if cs.State.Waiting != nil {
+			state += ":" + cs.State.Waiting.Reason
+		}
+	}
+	return state
+}
+
+// podStateChanged drops pod updates that do not change readiness or
+// container health.
+var podStateChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldPod, ok := e.ObjectOld.(*corev1.Pod)
+		if !ok {
+			return false
+		}
+		newPod, ok := e.ObjectNew.(*corev1.Pod)
+		if !ok {
+			return false
+		}
+		return podState(oldPod) != podState(newPod)
+	},
+}
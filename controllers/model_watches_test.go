@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	"github.com/kalkyai/model-serving-operator/pkg/config"
+	"github.com/kalkyai/model-serving-operator/pkg/runtimes"
+)
+
+// requestedModels returns the sorted namespace/name of each request.
+func requestedModels(requests []reconcile.Request) string {
+	names := []string{}
+	for _, request := range requests {
+		names = append(names, request.String())
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// namedModel returns a test model called name in namespace.
+func namedModel(namespace, name string) *mlv1alpha1.Model {
+	model_serving := newTestModel()
+	model_serving.Namespace = namespace
+	model_serving.Name = name
+	return model_serving
+}
+
+func TestModelsForSecret(t *testing.T) {
+	referencing := namedModel("team", "iris")
+	referencing.Spec.CredentialsSecretRef = &mlv1alpha1.CredentialsSecretRef{Name: "models"}
+	other := namedModel("team", "mnist")
+	other.Spec.CredentialsSecretRef = &mlv1alpha1.CredentialsSecretRef{Name: "mnist-keys"}
+	inline := namedModel("team", "wine")
+	inline.Spec.Accesskey = "access"
+	inline.Spec.SecretKey = "secret"
+	elsewhere := namedModel("staging", "iris")
+	elsewhere.Spec.CredentialsSecretRef = &mlv1alpha1.CredentialsSecretRef{Name: "models"}
+	r := newTestReconciler(t, referencing, other, inline, elsewhere)
+
+	tests := []struct {
+		name   string
+		secret string
+		want   string
+	}{
+		{name: "referenced", secret: "models", want: "team/iris"},
+		{name: "unrelated", secret: "registry-pull", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: tt.secret, Namespace: "team"}}
+			if got := requestedModels(r.modelsForSecret(secret)); got != tt.want {
+				t.Errorf("models = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModelsForRuntime(t *testing.T) {
+	named := namedModel("team", "iris")
+	named.Spec.Runtime = "triton"
+	using := namedModel("team", "mnist")
+	using.Status.Runtime = "ServingRuntime/triton"
+	supported := namedModel("team", "wine")
+	supported.Spec.Framework = runtimes.ONNX
+	unrelated := namedModel("team", "boston")
+	unrelated.Spec.Framework = runtimes.XGBoost
+	elsewhere := namedModel("staging", "iris")
+	elsewhere.Spec.Runtime = "triton"
+	r := newTestReconciler(t, named, using, supported, unrelated, elsewhere)
+
+	runtime := namespacedRuntime("team", "triton", runtimeSpec(runtimes.ONNX, true, 1))
+	if got, want := requestedModels(r.modelsForRuntime(runtime)), "team/iris,team/mnist,team/wine"; got != want {
+		t.Errorf("models for ServingRuntime = %q, want %q", got, want)
+	}
+
+	cluster := clusterRuntime("xgboost", runtimeSpec(runtimes.XGBoost, true, 1))
+	if got, want := requestedModels(r.modelsForRuntime(cluster)), "team/boston"; got != want {
+		t.Errorf("models for ClusterServingRuntime = %q, want %q", got, want)
+	}
+}
+
+func TestModelsForActivator(t *testing.T) {
+	idle := namedModel("team", "iris")
+	idle.Spec.IdleTimeout = &metav1.Duration{Duration: 15 * time.Minute}
+	always := namedModel("team", "mnist")
+	r := newTestReconciler(t, idle, always)
+	r.Activator = config.Activator{Namespace: "model-serving-system", Service: "activator"}
+
+	activator := &corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "activator", Namespace: "model-serving-system"}}
+	if got, want := requestedModels(r.modelsForActivator(activator)), "team/iris"; got != want {
+		t.Errorf("models for the activator = %q, want %q", got, want)
+	}
+
+	serving := &corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "ms-iris", Namespace: "team"}}
+	if got := requestedModels(r.modelsForActivator(serving)); got != "" {
+		t.Errorf("models for other Endpoints = %q, want none", got)
+	}
+}
+
+func TestModelForPod(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{name: "serving pod", labels: map[string]string{"serving": "iris"}, want: "team/iris"},
+		{name: "other pod", labels: map[string]string{"app": "iris"}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "iris-0", Namespace: "team", Labels: tt.labels}}
+			if got := requestedModels(modelForPod(pod)); got != tt.want {
+				t.Errorf("models = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -33,6 +33,7 @@ import (
 
 	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
 	"github.com/kalkyai/model-serving-operator/controllers"
+	"github.com/kalkyai/model-serving-operator/pkg/config"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -52,8 +53,12 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var operatorConfig string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.StringVar(&operatorConfig, "operator-config", "",
+		"Path to the operator configuration file holding the defaults applied to Models. "+
+			"Built-in defaults are used when empty.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -65,6 +70,19 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	cfg := config.Default()
+	if operatorConfig != "" {
+		var err error
+		if cfg, err = config.Load(operatorConfig); err != nil {
+			setupLog.Error(err, "unable to load operator configuration", "path", operatorConfig)
+			os.Exit(1)
+		}
+	}
+	if cfg.Defaults.FetcherImage == "" {
+		setupLog.Error(nil, "no fetcher image configured, set defaults.fetcherImage or "+config.OperatorImageEnv)
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -90,12 +108,34 @@ func main() {
 	}
 
 	if err = (&controllers.ModelReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("model-controller"),
+		Defaults:  cfg.Defaults,
+		Activator: cfg.Activator,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Model")
 		os.Exit(1)
 	}
+	if err = (&controllers.BatchPredictionReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("batchprediction-controller"),
+		Defaults: cfg.Defaults,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "BatchPrediction")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&mlv1alpha1.Model{}).SetupWebhookWithManager(mgr, cfg.Defaults); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Model")
+			os.Exit(1)
+		}
+		if err = (&mlv1alpha1.BatchPrediction{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "BatchPrediction")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -0,0 +1,322 @@
+// Package activator proxies requests to models with an idle timeout. It
+// records every request on the model so the operator keeps it running, and
+// holds requests for a model scaled to zero until a serving pod is ready.
+package activator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+// servicePrefix is the prefix of model Service names, ms-<name>.
+const servicePrefix = "ms-"
+
+// servingPort is the name of the serving container port.
+const servingPort = "serving"
+
+// Default settings of an Activator.
+const (
+	DefaultTimeout        = 2 * time.Minute
+	DefaultRecordInterval = 30 * time.Second
+	DefaultPollInterval   = 500 * time.Millisecond
+)
+
+// errNotFound is returned when a request does not address a model.
+var errNotFound = errors.New("not found")
+
+// Activator is an http.Handler forwarding requests sent to model Services to
+// the ready serving pods of the model.
+type Activator struct {
+	// Client reads Models and Pods, usually from a cache, and records
+	// requests on Models.
+	Client client.Client
+
+	// Timeout bounds how long a request is held for a ready pod.
+	Timeout time.Duration
+	// RecordInterval is how often requests are recorded on a model that
+	// serves. Requests for a model without ready pods are recorded right
+	// away to wake it up.
+	RecordInterval time.Duration
+	// PollInterval is how often held requests look for a ready pod.
+	PollInterval time.Duration
+
+	mu       sync.Mutex
+	recorded map[types.NamespacedName]time.Time
+	next     uint32
+}
+
+// New returns an Activator with the default settings.
+func New(c client.Client) *Activator {
+	return &Activator{
+		Client:         c,
+		Timeout:        DefaultTimeout,
+		RecordInterval: DefaultRecordInterval,
+		PollInterval:   DefaultPollInterval,
+	}
+}
+
+func (a *Activator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	logger := log.FromContext(ctx).WithValues("host", req.Host)
+
+	key, err := a.resolve(ctx, req.Host, req.URL.Path)
+	if errors.Is(err, errNotFound) {
+		http.Error(w, fmt.Sprintf("no model with an idle timeout is served at %s", req.Host), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error(err, "Failed to resolve model")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	target, err := a.activate(ctx, key)
+	if err != nil {
+		logger.Error(err, "Failed to activate model", "model", key)
+		if errors.Is(err, context.DeadlineExceeded) {
+			http.Error(w, fmt.Sprintf("model %s did not become ready in %s", key, a.Timeout), http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	httputil.NewSingleHostReverseProxy(target).ServeHTTP(w, req)
+}
+
+// ModelFromHost returns the model a request was sent to, from the host of
+// its Service: ms-<name>[.<namespace>[.svc[.<cluster domain>]]][:port]. The
+// namespace is empty for the short form.
+func ModelFromHost(host string) (types.NamespacedName, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	labels := strings.Split(host, ".")
+	if !strings.HasPrefix(labels[0], servicePrefix) || labels[0] == servicePrefix {
+		return types.NamespacedName{}, errNotFound
+	}
+	key := types.NamespacedName{Name: strings.TrimPrefix(labels[0], servicePrefix)}
+	if len(labels) > 1 {
+		key.Namespace = labels[1]
+	}
+	return key, nil
+}
+
+// resolve finds the model a request was sent to. A short Service host is
+// only resolved when one namespace has a model with an idle timeout by that
+// name. Other hosts are looked up in spec.exposure, as requests coming from
+// an Ingress or a Gateway keep their external host.
+func (a *Activator) resolve(ctx context.Context, host, path string) (types.NamespacedName, error) {
+	key, err := ModelFromHost(host)
+	if errors.Is(err, errNotFound) {
+		return a.resolveExposed(ctx, host, path)
+	}
+	if err != nil {
+		return key, err
+	}
+
+	if key.Namespace != "" {
+		model := &mlv1alpha1.Model{}
+		err := a.Client.Get(ctx, key, model)
+		if apierrors.IsNotFound(err) || (err == nil && model.Spec.IdleTimeout == nil) {
+			return key, errNotFound
+		}
+		return key, err
+	}
+
+	models := &mlv1alpha1.ModelList{}
+	if err := a.Client.List(ctx, models); err != nil {
+		return key, err
+	}
+	matches := []types.NamespacedName{}
+	for _, item := range models.Items {
+		if item.Name == key.Name && item.Spec.IdleTimeout != nil {
+			matches = append(matches, types.NamespacedName{Namespace: item.Namespace, Name: item.Name})
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return key, errNotFound
+	case 1:
+		return matches[0], nil
+	default:
+		return key, fmt.Errorf("%d namespaces have a model %s, address it as %s%s.<namespace>", len(matches), key.Name, servicePrefix, key.Name)
+	}
+}
+
+// resolveExposed finds the model with an idle timeout exposed at host with
+// the longest path prefix of path.
+func (a *Activator) resolveExposed(ctx context.Context, host, path string) (types.NamespacedName, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	models := &mlv1alpha1.ModelList{}
+	if err := a.Client.List(ctx, models); err != nil {
+		return types.NamespacedName{}, err
+	}
+	var found *mlv1alpha1.Model
+	longest := -1
+	for i := range models.Items {
+		item := &models.Items[i]
+		exposure := item.Spec.Exposure
+		if item.Spec.IdleTimeout == nil || exposure == nil || exposure.Host != host {
+			continue
+		}
+		prefix := strings.TrimSuffix(exposure.Path, "/")
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			continue
+		}
+		if len(prefix) > longest {
+			found, longest = item, len(prefix)
+		}
+	}
+	if found == nil {
+		return types.NamespacedName{}, errNotFound
+	}
+	return types.NamespacedName{Namespace: found.Namespace, Name: found.Name}, nil
+}
+
+// activate records the request on the model and returns the address of a
+// ready serving pod, waiting for one when the model is scaled to zero.
+func (a *Activator) activate(ctx context.Context, key types.NamespacedName) (*url.URL, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.Timeout)
+	defer cancel()
+
+	target, err := a.readyPod(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.record(ctx, key, target == nil); err != nil {
+		if target == nil {
+			return nil, err
+		}
+		// A serving model keeps running until the next request is
+		// recorded.
+		log.FromContext(ctx).Error(err, "Failed to record request", "model", key)
+	}
+
+	for target == nil {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(a.PollInterval):
+		}
+		if target, err = a.readyPod(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+	return target, nil
+}
+
+// record moves the last request time of the model forward, at most every
+// RecordInterval unless the model has to be woken up. Every change of the
+// annotation updates the Model, so it is left alone while another replica
+// recorded a request within the interval.
+func (a *Activator) record(ctx context.Context, key types.NamespacedName, wake bool) error {
+	now := time.Now()
+	interval := a.RecordInterval
+	if wake {
+		interval = a.PollInterval
+	}
+
+	a.mu.Lock()
+	if a.recorded == nil {
+		a.recorded = map[types.NamespacedName]time.Time{}
+	}
+	last, ok := a.recorded[key]
+	if ok && now.Sub(last) < interval {
+		a.mu.Unlock()
+		return nil
+	}
+	a.recorded[key] = now
+	a.mu.Unlock()
+
+	// Other replicas of the activator record requests on the same model.
+	model := &mlv1alpha1.Model{}
+	if err := a.Client.Get(ctx, key, model); err != nil {
+		a.forget(key)
+		return err
+	}
+	if seen, err := time.Parse(time.RFC3339, model.Annotations[mlv1alpha1.LastRequestAnnotation]); err == nil && now.Sub(seen) < interval {
+		return nil
+	}
+
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, mlv1alpha1.LastRequestAnnotation, now.UTC().Format(time.RFC3339))
+	if err := a.Client.Patch(ctx, model, client.RawPatch(types.MergePatchType, []byte(patch))); err != nil {
+		a.forget(key)
+		return err
+	}
+	return nil
+}
+
+// forget drops the last recorded request of the model, so the next request
+// is recorded again.
+func (a *Activator) forget(key types.NamespacedName) {
+	a.mu.Lock()
+	delete(a.recorded, key)
+	a.mu.Unlock()
+}
+
+// readyPod returns the address of a ready serving pod of the model, taking
+// turns between them, or nil when none is ready.
+func (a *Activator) readyPod(ctx context.Context, key types.NamespacedName) (*url.URL, error) {
+	pods := &corev1.PodList{}
+	if err := a.Client.List(ctx, pods,
+		client.InNamespace(key.Namespace),
+		client.MatchingLabels{"serving": key.Name},
+	); err != nil {
+		return nil, err
+	}
+
+	targets := []*url.URL{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		port, ok := servingContainerPort(pod)
+		if !ok || pod.Status.PodIP == "" || pod.DeletionTimestamp != nil || !podReady(pod) {
+			continue
+		}
+		targets = append(targets, &url.URL{Scheme: "http", Host: net.JoinHostPort(pod.Status.PodIP, fmt.Sprint(port))})
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	return targets[atomic.AddUint32(&a.next, 1)%uint32(len(targets))], nil
+}
+
+func servingContainerPort(pod *corev1.Pod) (int32, bool) {
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == servingPort {
+				return port.ContainerPort, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,251 @@
+package activator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+func TestModelFromHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want types.NamespacedName
+		err  bool
+	}{
+		{host: "ms-iris.team.svc:4000", want: types.NamespacedName{Namespace: "team", Name: "iris"}},
+		{host: "ms-iris.team.svc.cluster.local", want: types.NamespacedName{Namespace: "team", Name: "iris"}},
+		{host: "ms-iris:4000", want: types.NamespacedName{Name: "iris"}},
+		{host: "ms-", err: true},
+		{host: "iris.team.svc", err: true},
+	}
+	for _, test := range tests {
+		got, err := ModelFromHost(test.host)
+		if (err != nil) != test.err {
+			t.Errorf("ModelFromHost(%q) error = %v, want error %t", test.host, err, test.err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ModelFromHost(%q) = %v, want %v", test.host, got, test.want)
+		}
+	}
+}
+
+func newScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := mlv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func idleModel(namespace, name string) *mlv1alpha1.Model {
+	return &mlv1alpha1.Model{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       mlv1alpha1.ModelSpec{IdleTimeout: &metav1.Duration{Duration: 15 * time.Minute}},
+	}
+}
+
+// servingPod is a ready serving pod of the model answering on backend.
+func servingPod(t *testing.T, model *mlv1alpha1.Model, backend *httptest.Server) *corev1.Pod {
+	host, port, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	containerPort, _ := strconv.Atoi(port)
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: model.Namespace, Name: model.Name + "-0", Labels: map[string]string{"serving": model.Name}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:  "serving",
+			Ports: []corev1.ContainerPort{{Name: "serving", ContainerPort: int32(containerPort)}},
+		}}},
+		Status: corev1.PodStatus{
+			PodIP:      host,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+func newBackend(t *testing.T) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "prediction for %s", req.Host)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func serve(a *Activator, host string) *http.Response {
+	req := httptest.NewRequest(http.MethodPost, "http://"+host+"/predict", nil)
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func lastRequest(t *testing.T, c client.Client, model *mlv1alpha1.Model) string {
+	found := &mlv1alpha1.Model{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(model), found); err != nil {
+		t.Fatal(err)
+	}
+	return found.Annotations[mlv1alpha1.LastRequestAnnotation]
+}
+
+func TestForwardsToReadyPod(t *testing.T) {
+	model := idleModel("team", "iris")
+	backend := newBackend(t)
+	c := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(model, servingPod(t, model, backend)).Build()
+
+	resp := serve(New(c), "ms-iris:4000")
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "prediction for ms-iris:4000" {
+		t.Fatalf("got %d %q", resp.StatusCode, body)
+	}
+	if lastRequest(t, c, model) == "" {
+		t.Error("request was not recorded on the model")
+	}
+}
+
+func TestForwardsExposedHost(t *testing.T) {
+	model := idleModel("team", "iris")
+	model.Spec.Exposure = &mlv1alpha1.Exposure{Host: "models.example.com", Path: "/iris"}
+	other := idleModel("team", "wine")
+	other.Spec.Exposure = &mlv1alpha1.Exposure{Host: "models.example.com", Path: "/"}
+	backend := newBackend(t)
+	c := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(model, other, servingPod(t, model, backend)).Build()
+
+	req := httptest.NewRequest(http.MethodPost, "http://models.example.com/iris/predict", nil)
+	rec := httptest.NewRecorder()
+	New(c).ServeHTTP(rec, req)
+	if resp := rec.Result(); resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d", resp.StatusCode)
+	}
+	if lastRequest(t, c, other) != "" || lastRequest(t, c, model) == "" {
+		t.Error("request was not recorded on the model with the longest path")
+	}
+}
+
+func TestHoldsRequestUntilReady(t *testing.T) {
+	model := idleModel("team", "iris")
+	backend := newBackend(t)
+	c := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(model).Build()
+
+	a := New(c)
+	a.PollInterval = 10 * time.Millisecond
+
+	done := make(chan *http.Response)
+	go func() { done <- serve(a, "ms-iris.team.svc:4000") }()
+
+	// The request wakes the model up before any pod is ready.
+	deadline := time.Now().Add(5 * time.Second)
+	for lastRequest(t, c, model) == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("request was not recorded on the model")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := c.Create(context.Background(), servingPod(t, model, backend)); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := <-done
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d", resp.StatusCode)
+	}
+}
+
+func TestTimesOutWithoutReadyPod(t *testing.T) {
+	model := idleModel("team", "iris")
+	c := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(model).Build()
+
+	a := New(c)
+	a.Timeout = 50 * time.Millisecond
+	a.PollInterval = 10 * time.Millisecond
+
+	if resp := serve(a, "ms-iris.team"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+}
+
+func TestRejectsModelsWithoutIdleTimeout(t *testing.T) {
+	model := idleModel("team", "iris")
+	model.Spec.IdleTimeout = nil
+	c := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(model, idleModel("other", "iris"), idleModel("more", "iris")).Build()
+
+	for host, want := range map[string]int{
+		"ms-iris.team": http.StatusNotFound,
+		"ms-wine.team": http.StatusNotFound,
+		"ms-iris":      http.StatusBadGateway,
+	} {
+		if resp := serve(New(c), host); resp.StatusCode != want {
+			t.Errorf("%s: got %d, want %d", host, resp.StatusCode, want)
+		}
+	}
+}
+
+// patchCounter counts the patches sent through it.
+type patchCounter struct {
+	client.Client
+	patches int32
+}
+
+func (c *patchCounter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	atomic.AddInt32(&c.patches, 1)
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func TestRecordsRequestsOncePerInterval(t *testing.T) {
+	model := idleModel("team", "iris")
+	backend := newBackend(t)
+	c := &patchCounter{Client: fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(model, servingPod(t, model, backend)).Build()}
+
+	a := New(c)
+	for i := 0; i < 3; i++ {
+		if resp := serve(a, "ms-iris.team"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("got %d", resp.StatusCode)
+		}
+	}
+	// Another replica of the activator finds the request recorded.
+	if resp := serve(New(c), "ms-iris.team"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d", resp.StatusCode)
+	}
+
+	if c.patches != 1 {
+		t.Errorf("model patched %d times, want once per interval", c.patches)
+	}
+	if lastRequest(t, c, model) == "" {
+		t.Error("request was not recorded on the model")
+	}
+}
+
+func TestRecordsRequestsAgainAfterInterval(t *testing.T) {
+	model := idleModel("team", "iris")
+	model.Annotations = map[string]string{mlv1alpha1.LastRequestAnnotation: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)}
+	backend := newBackend(t)
+	c := &patchCounter{Client: fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(model, servingPod(t, model, backend)).Build()}
+
+	before := lastRequest(t, c, model)
+	if resp := serve(New(c), "ms-iris.team"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d", resp.StatusCode)
+	}
+	if c.patches != 1 || lastRequest(t, c, model) == before {
+		t.Errorf("an old request kept the new one from being recorded")
+	}
+}
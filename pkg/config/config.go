@@ -0,0 +1,116 @@
+// Package config holds the operator-wide settings loaded at startup.
+package config
+
+import (
+	"os"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// OperatorImageEnv is the environment variable the manager Deployment sets
+// to the image of the operator itself, the default FetcherImage.
+const OperatorImageEnv = "OPERATOR_IMAGE"
+
+// Defaults are applied to every Model that does not set the matching field.
+type Defaults struct {
+	// ImageRepository is the repository of the serving image. The model
+	// version is used as the tag.
+	ImageRepository string `json:"imageRepository,omitempty"`
+
+	// Port the serving container listens on.
+	Port int32 `json:"port,omitempty"`
+
+	// StorageClassName of the volume the model is downloaded to. Empty uses
+	// the cluster default storage class.
+	StorageClassName string `json:"storageClassName,omitempty"`
+
+	// StorageSize of the volume the model is downloaded to.
+	StorageSize *resource.Quantity `json:"storageSize,omitempty"`
+
+	// FetcherImage is the image of the init container downloading and
+	// verifying model artifacts. Unset, it is the operator image read from
+	// OperatorImageEnv, so the fetcher matches the running operator.
+	FetcherImage string `json:"fetcherImage,omitempty"`
+}
+
+// Activator locates the proxy that holds requests for idle models. The
+// Service of a model with an idle timeout is pointed at its endpoints.
+type Activator struct {
+	// Namespace of the activator Service.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Service is the name of the activator Service.
+	Service string `json:"service,omitempty"`
+}
+
+// OperatorConfig is the file format read by Load.
+type OperatorConfig struct {
+	Defaults  Defaults  `json:"defaults,omitempty"`
+	Activator Activator `json:"activator,omitempty"`
+}
+
+// Default returns the configuration used when no file is given.
+func Default() OperatorConfig {
+	size := resource.MustParse("5Gi")
+	return OperatorConfig{
+		Defaults: Defaults{
+			ImageRepository: "plasmashadow/model_serving",
+			Port:            4000,
+			StorageSize:     &size,
+			FetcherImage:    os.Getenv(OperatorImageEnv),
+		},
+		Activator: Activator{
+			Namespace: "model-serving-operator-system",
+			Service:   "model-serving-operator-activator",
+		},
+	}
+}
+
+// Complete fills every unset field of d from the built-in defaults.
+func (d Defaults) Complete() Defaults {
+	builtin := Default().Defaults
+	if d.ImageRepository == "" {
+		d.ImageRepository = builtin.ImageRepository
+	}
+	if d.Port == 0 {
+		d.Port = builtin.Port
+	}
+	if d.StorageSize == nil {
+		d.StorageSize = builtin.StorageSize
+	}
+	if d.FetcherImage == "" {
+		d.FetcherImage = builtin.FetcherImage
+	}
+	return d
+}
+
+// Complete fills every unset field of a from the built-in defaults.
+func (a Activator) Complete() Activator {
+	builtin := Default().Activator
+	if a.Namespace == "" {
+		a.Namespace = builtin.Namespace
+	}
+	if a.Service == "" {
+		a.Service = builtin.Service
+	}
+	return a
+}
+
+// Load reads the operator configuration from a YAML file, typically a
+// mounted ConfigMap. Fields missing from the file keep their defaults.
+func Load(path string) (OperatorConfig, error) {
+	cfg := OperatorConfig{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return cfg, err
+	}
+
+	cfg.Defaults = cfg.Defaults.Complete()
+	cfg.Activator = cfg.Activator.Complete()
+	return cfg, nil
+}
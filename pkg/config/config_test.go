@@ -0,0 +1,43 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetcherImageDefaultsToOperatorImage(t *testing.T) {
+	t.Setenv(OperatorImageEnv, "registry.example.com/model-serving-operator:v1.2.0")
+
+	if image := Default().Defaults.FetcherImage; image != "registry.example.com/model-serving-operator:v1.2.0" {
+		t.Errorf("default fetcher image = %q, want the operator image", image)
+	}
+
+	path := filepath.Join(t.TempDir(), "operator_config.yaml")
+	if err := os.WriteFile(path, []byte("defaults:\n  fetcherImage: \"\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Defaults.FetcherImage != "registry.example.com/model-serving-operator:v1.2.0" {
+		t.Errorf("fetcher image = %q, want the operator image", cfg.Defaults.FetcherImage)
+	}
+}
+
+func TestFetcherImageFromFile(t *testing.T) {
+	t.Setenv(OperatorImageEnv, "registry.example.com/model-serving-operator:v1.2.0")
+
+	path := filepath.Join(t.TempDir(), "operator_config.yaml")
+	if err := os.WriteFile(path, []byte("defaults:\n  fetcherImage: registry.example.com/fetcher:v1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Defaults.FetcherImage != "registry.example.com/fetcher:v1" {
+		t.Errorf("fetcher image = %q, want the configured one", cfg.Defaults.FetcherImage)
+	}
+}
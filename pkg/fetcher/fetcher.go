@@ -0,0 +1,211 @@
+// Package fetcher downloads a model artifact into the volume of a serving
+// pod and verifies it. It runs as the init container of every serving pod
+// and reports the digest it saw back to the operator in its termination
+// message.
+package fetcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kalkyai/model-serving-operator/pkg/storage"
+)
+
+// Reasons reported when fetching fails.
+const (
+	ReasonChecksumMismatch = "ChecksumMismatch"
+	ReasonDownloadFailed   = "DownloadFailed"
+)
+
+// Manifest describes the artifact written next to it, so the serving
+// container and operators can tell what was loaded.
+type Manifest struct {
+	URI       string    `json:"uri"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	FetchedAt time.Time `json:"fetchedAt"`
+}
+
+// Report is the termination message of the fetcher.
+type Report struct {
+	URI     string `json:"uri"`
+	Digest  string `json:"digest,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// ParseReport reads a termination message written by the fetcher.
+func ParseReport(message string) (Report, bool) {
+	report := Report{}
+	if err := json.Unmarshal([]byte(message), &report); err != nil || report.URI == "" {
+		return Report{}, false
+	}
+	return report, true
+}
+
+// ChecksumError is returned when the artifact does not have the expected
+// digest.
+type ChecksumError struct {
+	URI      string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("artifact %s has digest %s, expected %s", e.URI, e.Actual, e.Expected)
+}
+
+// NewReport turns the outcome of Fetch into a termination message.
+func NewReport(uri string, manifest Manifest, err error) Report {
+	var checksum *ChecksumError
+	switch {
+	case errors.As(err, &checksum):
+		return Report{URI: uri, Digest: checksum.Actual, Reason: ReasonChecksumMismatch, Message: err.Error()}
+	case err != nil:
+		return Report{URI: uri, Reason: ReasonDownloadFailed, Message: err.Error()}
+	}
+	return Report{URI: uri, Digest: manifest.Digest}
+}
+
+// Options tell Fetch where the artifact goes.
+type Options struct {
+	// URI identifies the artifact, see storage.URI.
+	URI string
+	// Path is the file the artifact is written to. Other files in its
+	// directory are left over from earlier revisions and are removed.
+	Path string
+	// ManifestPath is where the manifest is written.
+	ManifestPath string
+	// Digest is the expected digest, sha256:<hex>. Empty skips the check.
+	Digest string
+}
+
+// Digest returns the digest of content, sha256:<hex>.
+func Digest(content io.Reader) (string, int64, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, content)
+	if err != nil {
+		return "", 0, err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// Fetch downloads the artifact from backend to opts.Path and writes its
+// manifest. An artifact already fetched from the same URI is kept when its
+// content still matches the manifest and the expected digest. Otherwise it
+// is downloaded again, as the artifact may have been replaced under the
+// same URI, and only the new download is verified.
+func Fetch(ctx context.Context, backend storage.Backend, opts Options) (Manifest, error) {
+	if manifest, ok := cached(opts); ok && (opts.Digest == "" || manifest.Digest == opts.Digest) {
+		return manifest, nil
+	}
+
+	dir := filepath.Dir(opts.Path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Manifest{}, err
+	}
+
+	body, err := backend.Open(ctx)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer body.Close()
+
+	tmp, err := os.CreateTemp(dir, ".download-")
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Manifest{}, fmt.Errorf("downloading %s: %w", opts.URI, err)
+	}
+
+	manifest := Manifest{
+		URI:       opts.URI,
+		Digest:    "sha256:" + hex.EncodeToString(hash.Sum(nil)),
+		Size:      size,
+		FetchedAt: time.Now().UTC(),
+	}
+	if opts.Digest != "" && manifest.Digest != opts.Digest {
+		return manifest, &ChecksumError{URI: opts.URI, Expected: opts.Digest, Actual: manifest.Digest}
+	}
+
+	if err := os.Rename(tmp.Name(), opts.Path); err != nil {
+		return Manifest{}, err
+	}
+	if err := writeManifest(opts.ManifestPath, manifest); err != nil {
+		return Manifest{}, err
+	}
+	return manifest, prune(dir, opts.Path, opts.ManifestPath)
+}
+
+// cached returns the manifest of an artifact fetched earlier from the same
+// URI whose file still has the recorded digest.
+func cached(opts Options) (Manifest, bool) {
+	data, err := os.ReadFile(opts.ManifestPath)
+	if err != nil {
+		return Manifest{}, false
+	}
+	manifest := Manifest{}
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.URI != opts.URI {
+		return Manifest{}, false
+	}
+
+	f, err := os.Open(opts.Path)
+	if err != nil {
+		return Manifest{}, false
+	}
+	defer f.Close()
+	digest, _, err := Digest(f)
+	if err != nil || digest != manifest.Digest {
+		return Manifest{}, false
+	}
+	return manifest, true
+}
+
+func writeManifest(path string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// prune removes the files of dir other than keep.
+func prune(dir string, keep ...string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	kept := map[string]bool{}
+	for _, path := range keep {
+		kept[filepath.Base(path)] = true
+	}
+	for _, entry := range entries {
+		if kept[entry.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
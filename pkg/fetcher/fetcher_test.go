@@ -0,0 +1,140 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	content = "model bytes"
+	// digest of content.
+	digest = "sha256:9cb7487000bc86ac36ce83c4acfabe8878552be99572a6770f65ab1d048a5c48"
+)
+
+// backend serves content and counts the downloads.
+type backend struct {
+	content string
+	opened  int
+}
+
+func (b *backend) Open(ctx context.Context) (io.ReadCloser, error) {
+	b.opened++
+	return io.NopCloser(strings.NewReader(b.content)), nil
+}
+
+func (b *backend) Delete(ctx context.Context) error {
+	return nil
+}
+
+func options(t *testing.T, expected string) Options {
+	dir := t.TempDir()
+	return Options{
+		URI:          "s3://models/iris/model.sav",
+		Path:         filepath.Join(dir, "model", "model.sav"),
+		ManifestPath: filepath.Join(dir, "model.manifest.json"),
+		Digest:       expected,
+	}
+}
+
+func TestFetch(t *testing.T) {
+	opts := options(t, digest)
+	b := &backend{content: content}
+
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	stale := filepath.Join(filepath.Dir(opts.Path), "old.sav")
+	if err := os.WriteFile(stale, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := Fetch(context.Background(), b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Digest != digest || manifest.Size != int64(len(content)) {
+		t.Errorf("manifest = %+v, want digest %s and size %d", manifest, digest, len(content))
+	}
+	if got, _ := os.ReadFile(opts.Path); string(got) != content {
+		t.Errorf("artifact = %q, want %q", got, content)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("artifact of an earlier revision was not removed")
+	}
+
+	// A second run reuses the verified artifact.
+	if _, err := Fetch(context.Background(), b, opts); err != nil {
+		t.Fatal(err)
+	}
+	if b.opened != 1 {
+		t.Errorf("artifact downloaded %d times, want 1", b.opened)
+	}
+
+	// A changed file is downloaded again.
+	if err := os.WriteFile(opts.Path, []byte("tampered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Fetch(context.Background(), b, opts); err != nil {
+		t.Fatal(err)
+	}
+	if b.opened != 2 {
+		t.Errorf("tampered artifact downloaded %d times, want 2", b.opened)
+	}
+}
+
+func TestFetchChecksumMismatch(t *testing.T) {
+	opts := options(t, "sha256:"+strings.Repeat("0", 64))
+
+	_, err := Fetch(context.Background(), &backend{content: content}, opts)
+	var checksum *ChecksumError
+	if !errors.As(err, &checksum) {
+		t.Fatalf("Fetch = %v, want a ChecksumError", err)
+	}
+	if _, err := os.Stat(opts.Path); !os.IsNotExist(err) {
+		t.Errorf("artifact with a wrong checksum was kept")
+	}
+
+	report := NewReport(opts.URI, Manifest{}, err)
+	if report.Reason != ReasonChecksumMismatch || report.Digest != checksum.Actual {
+		t.Errorf("report = %+v, want reason %s with the actual digest", report, ReasonChecksumMismatch)
+	}
+}
+
+func TestFetchNewDigest(t *testing.T) {
+	opts := options(t, "")
+	b := &backend{content: "old model"}
+	if _, err := Fetch(context.Background(), b, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// The artifact was replaced under the same URI and the model now
+	// expects the digest of the new one.
+	b.content = content
+	opts.Digest = digest
+	manifest, err := Fetch(context.Background(), b, opts)
+	if err != nil {
+		t.Fatalf("Fetch = %v, want the cached artifact downloaded again", err)
+	}
+	if b.opened != 2 || manifest.Digest != digest {
+		t.Errorf("downloaded %d times, manifest = %+v", b.opened, manifest)
+	}
+	if got, _ := os.ReadFile(opts.Path); string(got) != content {
+		t.Errorf("artifact = %q, want %q", got, content)
+	}
+}
+
+func TestParseReport(t *testing.T) {
+	message := `{"uri":"s3://models/iris/model.sav","digest":"` + digest + `"}`
+	report, ok := ParseReport(message)
+	if !ok || report.Digest != digest {
+		t.Errorf("ParseReport(%q) = %+v, %v", message, report, ok)
+	}
+	if _, ok := ParseReport("exit status 1"); ok {
+		t.Errorf("ParseReport accepted a message not written by the fetcher")
+	}
+}
@@ -0,0 +1,239 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BatchLabel carries the name of the BatchPrediction on its Job pods. They
+// have no serving label, so model Services never route to them.
+const BatchLabel = "ml.kalkyai.com/batch-prediction"
+
+// Paths in the batch pods. The input fetcher downloads the dataset into
+// InputDir; the predictor writes its shard of the predictions under
+// OutputDir and its BatchReport to BatchReportPath.
+const (
+	InputDir          = "/input/data"
+	InputManifestPath = "/input/data.manifest.json"
+	OutputDir         = "/output"
+	BatchReportPath   = "/dev/termination-log"
+)
+
+// InputFetcherContainer is the name of the init container downloading the
+// dataset, PredictorContainer the name of the container predicting it.
+const (
+	InputFetcherContainer = "fetch-input"
+	PredictorContainer    = "predict"
+)
+
+// completionIndexAnnotation is set by the Job controller on the pods of an
+// indexed Job.
+const completionIndexAnnotation = "batch.kubernetes.io/job-completion-index"
+
+// JobNameLabel is set by the Job controller on the pods of every Job.
+const JobNameLabel = "job-name"
+
+// Batch is a prediction over a dataset with the model of a ModelServing.
+type Batch struct {
+	Name string
+
+	// Input is the JSON of the storage holding the dataset, with the
+	// Secrets in InputSecrets mounted. InputClaim is a volume claim holding
+	// the dataset. InputPath is the file under InputDir it is written to.
+	Input        string
+	InputSecrets []string
+	InputClaim   string
+	InputPath    string
+
+	// OutputClaim is the volume claim mounted at OutputDir, OutputPath the
+	// directory under it the shards write to.
+	OutputClaim string
+	OutputPath  string
+
+	Shards         int32
+	Parallelism    *int32
+	BackoffLimit   *int32
+	ActiveDeadline *int64
+
+	// Command and Args replace those of the serving container when set.
+	Command []string
+	Args    []string
+
+	// Schedule runs the batch as a CronJob, if set.
+	Schedule *BatchSchedule
+}
+
+// BatchSchedule is when a CronJob runs a batch, see the CronJob spec.
+type BatchSchedule struct {
+	Schedule                   string
+	Suspend                    bool
+	ConcurrencyPolicy          batchv1.ConcurrencyPolicy
+	StartingDeadline           *int64
+	SuccessfulJobsHistoryLimit *int32
+	FailedJobsHistoryLimit     *int32
+}
+
+// BatchReport is the termination message of the predictor.
+type BatchReport struct {
+	Rows   int64 `json:"rows"`
+	Failed int64 `json:"failed,omitempty"`
+}
+
+// ParseBatchReport reads a termination message written by the predictor.
+func ParseBatchReport(message string) (BatchReport, bool) {
+	report := BatchReport{}
+	if err := json.Unmarshal([]byte(message), &report); err != nil {
+		return BatchReport{}, false
+	}
+	return report, true
+}
+
+// CompletionIndex is the shard a pod of a batch Job predicts.
+func CompletionIndex(pod *corev1.Pod) (string, bool) {
+	index, ok := pod.Annotations[completionIndexAnnotation]
+	return index, ok
+}
+
+// predictor renders the serving container as a batch predictor: the same
+// image, model and environment, with the environment of the ConfigMap
+// inlined and no port or probes.
+func (m *ModelServing) predictor(ctx context.Context, batch *Batch) corev1.Container {
+	container := m.servingContainer()
+	container.Name = PredictorContainer
+	container.Ports = nil
+	container.ReadinessProbe = nil
+	container.LivenessProbe = nil
+	container.StartupProbe = nil
+	if batch.Command != nil {
+		container.Command = batch.Command
+	}
+	if batch.Args != nil {
+		container.Args = batch.Args
+	}
+
+	// A Job has no ConfigMap of its own, it reads the values directly.
+	data := m.CreateConfigMap(ctx, m.ArtifactPath, m.Columns, m.Endpoint, m.Bucket).Data
+	for i, env := range container.Env {
+		if ref := env.ValueFrom; ref != nil && ref.ConfigMapKeyRef != nil && ref.ConfigMapKeyRef.Name == m.ConfigMapName() {
+			container.Env[i] = corev1.EnvVar{Name: env.Name, Value: data[ref.ConfigMapKeyRef.Key]}
+		}
+	}
+
+	container.Env = append(container.Env,
+		// Defined first, so $(BATCH_RUN) expands in the output path.
+		corev1.EnvVar{Name: "BATCH_RUN", ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: fmt.Sprintf("metadata.labels['%s']", JobNameLabel)},
+		}},
+		corev1.EnvVar{Name: "BATCH_INPUT", Value: batch.InputPath},
+		corev1.EnvVar{Name: "BATCH_OUTPUT", Value: path.Join(OutputDir, batch.OutputPath)},
+		corev1.EnvVar{Name: "BATCH_SHARD", ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: fmt.Sprintf("metadata.annotations['%s']", completionIndexAnnotation)},
+		}},
+		corev1.EnvVar{Name: "BATCH_SHARDS", Value: fmt.Sprint(batch.Shards)},
+		corev1.EnvVar{Name: "BATCH_REPORT", Value: BatchReportPath},
+	)
+	container.VolumeMounts = append(container.VolumeMounts,
+		corev1.VolumeMount{Name: "input", MountPath: path.Dir(InputDir), ReadOnly: true},
+		corev1.VolumeMount{Name: "output", MountPath: OutputDir},
+	)
+	container.TerminationMessagePath = BatchReportPath
+	container.TerminationMessagePolicy = corev1.TerminationMessageReadFile
+	return container
+}
+
+// CreateBatchJob renders the indexed Job predicting batch with the model.
+// Its pods are built like the serving pods: the fetcher downloads the model
+// and the serving container runs the prediction, next to a second fetcher
+// downloading the dataset.
+func (m *ModelServing) CreateBatchJob(ctx context.Context, batch *Batch) *batchv1.Job {
+	labels := map[string]string{BatchLabel: batch.Name}
+
+	fetcher, volumes := m.fetcher()
+	input, inputVolumes := m.fetcherContainer(download{
+		container: InputFetcherContainer,
+		volume:    "input",
+		dir:       path.Dir(InputDir),
+		prefix:    "input-",
+		storage:   batch.Input,
+		output:    batch.InputPath,
+		manifest:  InputManifestPath,
+		secrets:   batch.InputSecrets,
+		claim:     batch.InputClaim,
+	})
+	volumes = append(volumes, inputVolumes...)
+	// The model and the dataset only live as long as the pod.
+	volumes = append(volumes,
+		corev1.Volume{Name: m.dataVolume(), VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		corev1.Volume{Name: "input", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		corev1.Volume{Name: "output", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+			ClaimName: batch.OutputClaim,
+		}}},
+	)
+	fsGroup := int64(65532)
+
+	annotations := map[string]string{
+		VersionAnnotation:  m.Version,
+		LocationAnnotation: m.ModelURL,
+	}
+
+	completionMode := batchv1.IndexedCompletion
+	shards := batch.Shards
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: BatchJobName(batch.Name), Namespace: m.Namespace, Labels: labels},
+		Spec: batchv1.JobSpec{
+			Completions:           &shards,
+			Parallelism:           batch.Parallelism,
+			CompletionMode:        &completionMode,
+			BackoffLimit:          batch.BackoffLimit,
+			ActiveDeadlineSeconds: batch.ActiveDeadline,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels, Annotations: annotations},
+				Spec: corev1.PodSpec{
+					Containers:        []corev1.Container{m.predictor(ctx, batch)},
+					InitContainers:    []corev1.Container{fetcher, input},
+					Volumes:           volumes,
+					RestartPolicy:     corev1.RestartPolicyNever,
+					SecurityContext:   &corev1.PodSecurityContext{FSGroup: &fsGroup},
+					NodeSelector:      m.NodeSelector,
+					Tolerations:       m.Tolerations,
+					Affinity:          m.Affinity,
+					PriorityClassName: m.PriorityClassName,
+				},
+			},
+		},
+	}
+}
+
+// CreateBatchCronJob renders the CronJob running the Job of batch on its
+// schedule.
+func (m *ModelServing) CreateBatchCronJob(ctx context.Context, batch *Batch) *batchv1.CronJob {
+	job := m.CreateBatchJob(ctx, batch)
+	schedule := batch.Schedule
+	suspend := schedule.Suspend
+	return &batchv1.CronJob{
+		ObjectMeta: job.ObjectMeta,
+		Spec: batchv1.CronJobSpec{
+			Schedule:                   schedule.Schedule,
+			Suspend:                    &suspend,
+			ConcurrencyPolicy:          schedule.ConcurrencyPolicy,
+			StartingDeadlineSeconds:    schedule.StartingDeadline,
+			SuccessfulJobsHistoryLimit: schedule.SuccessfulJobsHistoryLimit,
+			FailedJobsHistoryLimit:     schedule.FailedJobsHistoryLimit,
+			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: job.Labels},
+				Spec:       job.Spec,
+			},
+		},
+	}
+}
+
+// BatchJobName is the name of the Job, or CronJob, of a BatchPrediction.
+func BatchJobName(name string) string {
+	return fmt.Sprint("bp-", name)
+}
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+
+	"github.com/kalkyai/model-serving-operator/pkg/runtimes"
+)
+
+func TestCreateBatchJob(t *testing.T) {
+	runtime, _ := runtimes.Lookup(runtimes.SKLearn)
+	m := &ModelServing{
+		Name: "iris", Namespace: "team", Port: 8080, Runtime: runtime,
+		Columns: "a,b", ArtifactPath: ArtifactDir + "/iris.sav",
+		StorageSecrets: []string{"models"},
+	}
+	job := m.CreateBatchJob(context.Background(), &Batch{
+		Name:         "june",
+		InputPath:    InputDir + "/june.csv",
+		InputSecrets: []string{"datasets"},
+		OutputClaim:  "predictions",
+		OutputPath:   "iris/june",
+		Shards:       4,
+	})
+
+	if *job.Spec.Completions != 4 {
+		t.Errorf("completions = %d, want a completion per shard", *job.Spec.Completions)
+	}
+	pod := job.Spec.Template
+	if _, ok := pod.Labels["serving"]; ok {
+		t.Errorf("batch pods carry the serving label: %v", pod.Labels)
+	}
+
+	volumes := map[string]bool{}
+	for _, volume := range pod.Spec.Volumes {
+		if volumes[volume.Name] {
+			t.Errorf("volume %s is defined twice", volume.Name)
+		}
+		volumes[volume.Name] = true
+	}
+	for _, container := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		for _, mount := range container.VolumeMounts {
+			if !volumes[mount.Name] {
+				t.Errorf("%s mounts undefined volume %s", container.Name, mount.Name)
+			}
+		}
+	}
+
+	predictor := pod.Spec.Containers[0]
+	if predictor.ReadinessProbe != nil || len(predictor.Ports) != 0 {
+		t.Errorf("predictor keeps the probes or ports of the serving container")
+	}
+	env := map[string]string{}
+	for _, e := range predictor.Env {
+		if e.ValueFrom != nil && e.ValueFrom.ConfigMapKeyRef != nil {
+			t.Errorf("%s refers to ConfigMap %s", e.Name, e.ValueFrom.ConfigMapKeyRef.Name)
+		}
+		env[e.Name] = e.Value
+	}
+	if env["DATA_COLUMNS"] != "a,b" || env["BATCH_OUTPUT"] != "/output/iris/june" || env["BATCH_SHARDS"] != "4" {
+		t.Errorf("env = %v", env)
+	}
+}
+
+func TestCreateBatchCronJob(t *testing.T) {
+	runtime, _ := runtimes.Lookup(runtimes.SKLearn)
+	m := &ModelServing{Name: "iris", Namespace: "team", Port: 8080, Runtime: runtime}
+	cronJob := m.CreateBatchCronJob(context.Background(), &Batch{
+		Name:       "nightly",
+		OutputPath: "iris/$(BATCH_RUN)",
+		Shards:     1,
+		Schedule:   &BatchSchedule{Schedule: "@daily", Suspend: true, ConcurrencyPolicy: batchv1.ForbidConcurrent},
+	})
+
+	if cronJob.Name != "bp-nightly" || cronJob.Spec.Schedule != "@daily" || !*cronJob.Spec.Suspend {
+		t.Errorf("cronjob = %s %s suspended %v", cronJob.Name, cronJob.Spec.Schedule, *cronJob.Spec.Suspend)
+	}
+	// Jobs are found through the label, whether a CronJob started them or not.
+	if cronJob.Spec.JobTemplate.Labels[BatchLabel] != "nightly" {
+		t.Errorf("job template labels = %v", cronJob.Spec.JobTemplate.Labels)
+	}
+
+	// Variables only expand to those defined before them.
+	run, output := -1, -1
+	for i, env := range cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Env {
+		switch env.Name {
+		case "BATCH_RUN":
+			run = i
+		case "BATCH_OUTPUT":
+			output = i
+			if env.Value != "/output/iris/$(BATCH_RUN)" {
+				t.Errorf("BATCH_OUTPUT = %s", env.Value)
+			}
+		}
+	}
+	if run < 0 || output < run {
+		t.Errorf("BATCH_RUN is not defined before BATCH_OUTPUT")
+	}
+}
+
+func TestParseBatchReport(t *testing.T) {
+	report, ok := ParseBatchReport(`{"rows":1000,"failed":3}`)
+	if !ok || report.Rows != 1000 || report.Failed != 3 {
+		t.Errorf("report = %+v, %v", report, ok)
+	}
+	if _, ok := ParseBatchReport("Error: out of memory"); ok {
+		t.Errorf("parsed a message that is not a report")
+	}
+}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// HTTPRouteGVK is the Gateway API version HTTPRoutes are written in.
+var HTTPRouteGVK = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1beta1", Kind: "HTTPRoute"}
+
+// Exposure publishes the model Service outside the cluster at Host and
+// Path. A TLSSecretName serves it over https.
+type Exposure struct {
+	Host          string
+	Path          string
+	TLSSecretName string
+	Annotations   map[string]string
+
+	// ClassName is the IngressClass of an Ingress.
+	ClassName string
+	// Gateway* is the parent of an HTTPRoute.
+	GatewayName        string
+	GatewayNamespace   string
+	GatewaySectionName string
+}
+
+// RouteName is the name of the Ingress or HTTPRoute of the model.
+func (m *ModelServing) RouteName() string {
+	return fmt.Sprint("ms-", m.Name)
+}
+
+// routeAnnotations copies the annotations of the exposure, which the
+// reconciler adds its own to.
+func (m *ModelServing) routeAnnotations() map[string]string {
+	annotations := map[string]string{}
+	for key, value := range m.Exposure.Annotations {
+		annotations[key] = value
+	}
+	return annotations
+}
+
+func (m *ModelServing) exposurePath() string {
+	if m.Exposure.Path == "" {
+		return "/"
+	}
+	return m.Exposure.Path
+}
+
+// ExternalURL is the address of the model outside the cluster.
+func (m *ModelServing) ExternalURL() string {
+	scheme := "http"
+	if m.Exposure.TLSSecretName != "" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, m.Exposure.Host, strings.TrimSuffix(m.exposurePath(), "/"))
+}
+
+func (m *ModelServing) CreateIngress(ctx context.Context) *networkingv1.Ingress {
+	pathType := networkingv1.PathTypePrefix
+
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: m.RouteName(), Namespace: m.Namespace, Annotations: m.routeAnnotations()},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: m.Exposure.Host,
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{
+						Path:     m.exposurePath(),
+						PathType: &pathType,
+						Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+							Name: fmt.Sprint("ms-", m.Name),
+							Port: networkingv1.ServiceBackendPort{Number: m.Port},
+						}},
+					}},
+				}},
+			}},
+		},
+	}
+	if m.Exposure.ClassName != "" {
+		className := m.Exposure.ClassName
+		ingress.Spec.IngressClassName = &className
+	}
+	if m.Exposure.TLSSecretName != "" {
+		ingress.Spec.TLS = []networkingv1.IngressTLS{{Hosts: []string{m.Exposure.Host}, SecretName: m.Exposure.TLSSecretName}}
+	}
+	return ingress
+}
+
+// CreateHTTPRoute renders the HTTPRoute of the model. The Gateway API types
+// are not a dependency of the operator, so it is unstructured.
+func (m *ModelServing) CreateHTTPRoute(ctx context.Context) *unstructured.Unstructured {
+	parent := map[string]interface{}{"name": m.Exposure.GatewayName}
+	if m.Exposure.GatewayNamespace != "" {
+		parent["namespace"] = m.Exposure.GatewayNamespace
+	}
+	if m.Exposure.GatewaySectionName != "" {
+		parent["sectionName"] = m.Exposure.GatewaySectionName
+	}
+
+	route := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"parentRefs": []interface{}{parent},
+			"hostnames":  []interface{}{m.Exposure.Host},
+			"rules": []interface{}{map[string]interface{}{
+				"matches": []interface{}{map[string]interface{}{
+					"path": map[string]interface{}{"type": "PathPrefix", "value": m.exposurePath()},
+				}},
+				"backendRefs": []interface{}{map[string]interface{}{
+					"name": fmt.Sprint("ms-", m.Name),
+					"port": int64(m.Port),
+				}},
+			}},
+		},
+	}}
+	route.SetGroupVersionKind(HTTPRouteGVK)
+	route.SetName(m.RouteName())
+	route.SetNamespace(m.Namespace)
+	route.SetAnnotations(m.routeAnnotations())
+	return route
+}
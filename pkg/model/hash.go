@@ -0,0 +1,25 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+
+	"k8s.io/apimachinery/pkg/util/rand"
+)
+
+// HashAnnotation records the hash of the desired state an object was last
+// rendered from. The API server defaults fields we never set, so comparing
+// hashes is how we notice that the Model itself changed.
+const HashAnnotation = "ml.kalkyai.com/spec-hash"
+
+// Hash returns a short stable hash of the JSON encoding of obj.
+func Hash(obj interface{}) string {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	h := fnv.New32a()
+	h.Write(data)
+	return rand.SafeEncodeString(fmt.Sprint(h.Sum32()))
+}
@@ -3,16 +3,62 @@ package model
 import (
 	"context"
 	"fmt"
+	"path"
+	"strings"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utils "k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/kalkyai/model-serving-operator/pkg/runtimes"
+)
+
+// TrackLabel tells the pods of a canary apart from the stable ones. Both
+// carry the serving label, so the service balances over them.
+const TrackLabel = "ml.kalkyai.com/track"
+
+// CanaryTrack is the track of a revision under a canary rollout.
+const CanaryTrack = "canary"
+
+// Pod template annotations recording the revision the pods serve. A new
+// location changes the template, so the pods restart and reload the model.
+const (
+	VersionAnnotation  = "ml.kalkyai.com/version"
+	LocationAnnotation = "ml.kalkyai.com/location"
+)
+
+// ProtocolAnnotation records the inference protocol of the serving runtime
+// on the pods.
+const ProtocolAnnotation = "ml.kalkyai.com/protocol"
+
+// Paths in the serving pods. The fetcher init container downloads the
+// artifact into ArtifactDir on the model volume and describes it in
+// ManifestPath; the serving container loads it from there.
+const (
+	ArtifactDir  = "/data/model"
+	ManifestPath = "/data/model.manifest.json"
+	// ArtifactMountPath is where a volume claim holding the artifact is
+	// mounted in the fetcher.
+	ArtifactMountPath = "/mnt/models"
+	// StorageSecretsDir holds the Secrets of the storage, one directory
+	// per Secret.
+	StorageSecretsDir = "/var/run/secrets/model-storage"
 )
 
-var storageClassName string = "do-block-storage"
+// FetcherContainer is the name of the init container downloading the
+// artifact.
+const FetcherContainer = "fetch-model"
+
+// Default keys looked up in a credentials Secret.
+const (
+	DefaultAccessKeyKey = "access_key"
+	DefaultSecretKeyKey = "secret_key"
+)
 
 type ModelServing struct {
 	Name      string
@@ -25,113 +71,437 @@ type ModelServing struct {
 	SecretKey string
 	Endpoint  string
 	Bucket    string
+
+	// ImageRepository of the serving image, tagged with Version. It is
+	// used when the runtime has no image of its own.
+	ImageRepository string
+	// Runtime is the built-in serving stack of the model framework.
+	Runtime runtimes.Runtime
+	// Template replaces the serving container of Runtime when the model
+	// is served by a ServingRuntime. Protocol is the protocol it speaks.
+	Template *corev1.Container
+	Protocol string
+	// Port the serving container listens on.
+	Port int32
+
+	// StorageSize and StorageClassName of the volume the model is
+	// downloaded to. An empty class uses the cluster default.
+	StorageSize      resource.Quantity
+	StorageClassName string
+	// RetainVolumes keeps the volumes when the StatefulSet is deleted.
+	RetainVolumes bool
+
+	// StorageURI identifies the artifact across storage backends, e.g.
+	// gs://bucket/object. ArtifactPath is the file under ArtifactDir the
+	// serving container loads.
+	StorageURI   string
+	ArtifactPath string
+
+	// FetcherImage runs the init container downloading the artifact.
+	// Storage is the JSON of the storage it reads, with the Secrets in
+	// StorageSecrets mounted. ArtifactClaim is a volume claim holding the
+	// artifact, mounted at ArtifactMountPath.
+	FetcherImage   string
+	Storage        string
+	StorageSecrets []string
+	ArtifactClaim  string
+	// ArtifactDigest is the expected digest, sha256:<hex>, if any.
+	ArtifactDigest string
+
+	// Track is empty for the stable pods and CanaryTrack for a canary,
+	// which gets its own StatefulSet and ConfigMap.
+	Track string
+
+	// ViaActivator leaves the Service without a selector, its Endpoints
+	// point at the activator instead of the serving pods.
+	ViaActivator bool
+
+	// Resources replace the resources of the serving container when set.
+	Resources *corev1.ResourceRequirements
+	// Scheduling of the serving pods. Without TopologySpreadConstraints,
+	// SpreadZones spreads the pods across zones.
+	NodeSelector              map[string]string
+	Tolerations               []corev1.Toleration
+	Affinity                  *corev1.Affinity
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint
+	PriorityClassName         string
+	SpreadZones               bool
+
+	// Exposure publishes the model outside the cluster, if set.
+	Exposure *Exposure
+	// Monitoring scrapes and alerts on the serving pods, if set.
+	Monitoring *Monitoring
+
+	// StartupBudget is how long the serving container may take to load
+	// the model before its startup probe fails. Zero leaves the container
+	// without a default startup probe.
+	StartupBudget time.Duration
+}
+
+// WorkloadName is the name of the StatefulSet, suffixed with the track.
+func (m *ModelServing) WorkloadName() string {
+	if m.Track == "" {
+		return m.Name
+	}
+	return fmt.Sprint(m.Name, "-", m.Track)
+}
+
+// ConfigMapName is the name of the ConfigMap the serving pods read.
+func (m *ModelServing) ConfigMapName() string {
+	return fmt.Sprint("cf-", m.WorkloadName())
+}
+
+// selectorLabels select the pods of one track.
+func (m *ModelServing) selectorLabels() map[string]string {
+	labels := map[string]string{"serving": m.Name}
+	if m.Track != "" {
+		labels[TrackLabel] = m.Track
+	}
+	return labels
 }
 
 func (m *ModelServing) CreateService(ctx context.Context) *corev1.Service {
 	labels := map[string]string{"serving": m.Name}
+	if m.ViaActivator {
+		labels = nil
+	}
 
 	service := &corev1.Service{
 		TypeMeta:   metav1.TypeMeta{},
 		ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprint("ms-", m.Name), Namespace: m.Namespace},
-		Spec:       corev1.ServiceSpec{Selector: labels, Ports: []corev1.ServicePort{{Port: 4000, TargetPort: utils.FromInt(4000), Name: "http-serving"}}},
+		Spec:       corev1.ServiceSpec{Selector: labels, Ports: []corev1.ServicePort{{Port: m.Port, TargetPort: utils.FromInt(int(m.Port)), Name: "http-serving"}}},
 		Status:     corev1.ServiceStatus{},
 	}
 
 	return service
 }
 
+// CreateActivatorEndpoints renders the Endpoints of the model Service when
+// it goes through the activator: the ready addresses of the activator on
+// its port named http.
+func (m *ModelServing) CreateActivatorEndpoints(ctx context.Context, activator *corev1.Endpoints) *corev1.Endpoints {
+	subsets := []corev1.EndpointSubset{}
+	for _, subset := range activator.Subsets {
+		for _, port := range subset.Ports {
+			if port.Name != "http" || len(subset.Addresses) == 0 {
+				continue
+			}
+			addresses := []corev1.EndpointAddress{}
+			for _, address := range subset.Addresses {
+				// The activator pods live in another namespace.
+				addresses = append(addresses, corev1.EndpointAddress{IP: address.IP, NodeName: address.NodeName})
+			}
+			subsets = append(subsets, corev1.EndpointSubset{
+				Addresses: addresses,
+				Ports:     []corev1.EndpointPort{{Name: "http-serving", Port: port.Port, Protocol: corev1.ProtocolTCP}},
+			})
+		}
+	}
+
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprint("ms-", m.Name), Namespace: m.Namespace},
+		Subsets:    subsets,
+	}
+}
+
+// CreatePodEndpoints renders the Endpoints of the model Service when it
+// goes through the activator but requests are sent to the serving pods
+// directly: the addresses of pods on the serving port.
+func (m *ModelServing) CreatePodEndpoints(ctx context.Context, pods []corev1.Pod) *corev1.Endpoints {
+	addresses := []corev1.EndpointAddress{}
+	for i := range pods {
+		pod := &pods[i]
+		addresses = append(addresses, corev1.EndpointAddress{
+			IP:        pod.Status.PodIP,
+			NodeName:  nodeName(pod),
+			TargetRef: &corev1.ObjectReference{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID},
+		})
+	}
+
+	subsets := []corev1.EndpointSubset{}
+	if len(addresses) > 0 {
+		subsets = append(subsets, corev1.EndpointSubset{
+			Addresses: addresses,
+			Ports:     []corev1.EndpointPort{{Name: "http-serving", Port: m.Port, Protocol: corev1.ProtocolTCP}},
+		})
+	}
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprint("ms-", m.Name), Namespace: m.Namespace},
+		Subsets:    subsets,
+	}
+}
+
+func nodeName(pod *corev1.Pod) *string {
+	if pod.Spec.NodeName == "" {
+		return nil
+	}
+	name := pod.Spec.NodeName
+	return &name
+}
+
+func (m *ModelServing) ServiceURL() string {
+	return fmt.Sprintf("http://ms-%s.%s.svc:%d", m.Name, m.Namespace, m.Port)
+}
+
+// SecretName is the name of the operator-managed credentials Secret.
+func (m *ModelServing) SecretName() string {
+	return fmt.Sprint("cred-", m.Name)
+}
+
+func configMapEnv(name string, configMap string, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &v1.EnvVarSource{
+			ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: configMap},
+				Key:                  key,
+			},
+		},
+	}
+}
+
+// env is the environment of the serving container, read from the cf-
+// ConfigMap. The serving container never sees storage credentials; the
+// fetcher downloads the artifact for it.
+func (m *ModelServing) env() []corev1.EnvVar {
+	configMap := m.ConfigMapName()
+	env := []corev1.EnvVar{
+		{Name: "MODEL_NAME", Value: m.Name},
+		{Name: "MODEL_DIR", Value: ArtifactDir},
+		{Name: "PORT", Value: fmt.Sprint(m.Port)},
+		configMapEnv("MODEL_PATH", configMap, "MODEL_PATH"),
+		configMapEnv("DATA_COLUMNS", configMap, "COLUMNS"),
+		configMapEnv("ENDPOINT", configMap, "endpoint"),
+		configMapEnv("BUCKET", configMap, "bucket"),
+		configMapEnv("STORAGE_URI", configMap, "STORAGE_URI"),
+		configMapEnv("MODEL_MANIFEST", configMap, "MODEL_MANIFEST"),
+	}
+	if name := m.Runtime.ModelPathEnv; name != "" && name != "MODEL_PATH" {
+		env = append(env, configMapEnv(name, configMap, "MODEL_PATH"))
+	}
+	return env
+}
+
+// image is the serving image of the runtime, or ImageRepository tagged with
+// the model version.
+func (m *ModelServing) image() string {
+	if m.Runtime.Image != "" {
+		return m.Runtime.Image
+	}
+	return fmt.Sprint(m.ImageRepository, ":", m.Version)
+}
+
+// servingContainer renders the serving container from the Template, or
+// else the built-in Runtime, and adds the model volume and environment.
+func (m *ModelServing) servingContainer() corev1.Container {
+	container := corev1.Container{
+		Image:           m.image(),
+		ImagePullPolicy: "Always",
+		Args:            append([]string(nil), m.Runtime.Args...),
+	}
+	if m.Template != nil {
+		container = *m.Template.DeepCopy()
+	}
+
+	// Probes of a ServingRuntime container are kept.
+	if container.ReadinessProbe == nil {
+		container.ReadinessProbe = m.probe(m.Runtime.ReadinessPath)
+	}
+	if container.LivenessProbe == nil {
+		container.LivenessProbe = m.probe(m.Runtime.LivenessPath)
+	}
+	if container.StartupProbe == nil && m.StartupBudget > 0 {
+		container.StartupProbe = m.startupProbe()
+	}
+
+	container.Name = "serving"
+	if m.Resources != nil {
+		container.Resources = *m.Resources.DeepCopy()
+	}
+	if len(container.Ports) == 0 {
+		container.Ports = []corev1.ContainerPort{{ContainerPort: m.Port}}
+	}
+	container.Ports[0].Name = "serving"
+	container.Env = append(container.Env, m.env()...)
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: m.dataVolume(), MountPath: "/data"})
+	return container
+}
+
+// probe checks path of the serving port, or is nil without a path.
+func (m *ModelServing) probe(path string) *corev1.Probe {
+	return &corev1.Probe{ProbeHandler: m.ProbeHandler(path)}
+}
+
+// ProbeHandler checks path with an HTTP GET on the serving port or, without
+// a path, that the serving port accepts connections. path may contain
+// runtimes.NamePlaceholder.
+func (m *ModelServing) ProbeHandler(path string) corev1.ProbeHandler {
+	if path == "" {
+		return corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: utils.FromInt(int(m.Port))}}
+	}
+	return corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{
+		Path: strings.ReplaceAll(path, runtimes.NamePlaceholder, m.Name),
+		Port: utils.FromInt(int(m.Port)),
+	}}
+}
+
+// startupPeriod is the period of the default startup probe.
+const startupPeriod = 10 * time.Second
+
+// startupProbe waits StartupBudget for the server to load the model.
+func (m *ModelServing) startupProbe() *corev1.Probe {
+	probe := m.probe(m.Runtime.ReadinessPath)
+	probe.PeriodSeconds = int32(startupPeriod / time.Second)
+	probe.FailureThreshold = int32((m.StartupBudget + startupPeriod - 1) / startupPeriod)
+	return probe
+}
+
+// dataVolume is the volume the artifact is downloaded to, mounted at /data.
+func (m *ModelServing) dataVolume() string {
+	return fmt.Sprint("pvc-", m.Name)
+}
+
+// download is what one fetcher init container writes to output, on volume
+// mounted at dir. prefix names the volumes of its Secrets and claim apart
+// from those of other fetchers in the pod.
+type download struct {
+	container string
+	volume    string
+	dir       string
+	prefix    string
+
+	storage  string
+	digest   string
+	output   string
+	manifest string
+	secrets  []string
+	claim    string
+}
+
+// fetcher renders the init container downloading the artifact into the
+// model volume, with the volumes it needs besides that one.
+func (m *ModelServing) fetcher() (corev1.Container, []corev1.Volume) {
+	return m.fetcherContainer(download{
+		container: FetcherContainer,
+		volume:    m.dataVolume(),
+		dir:       "/data",
+		storage:   m.Storage,
+		digest:    m.ArtifactDigest,
+		output:    m.ArtifactPath,
+		manifest:  ManifestPath,
+		secrets:   m.StorageSecrets,
+		claim:     m.ArtifactClaim,
+	})
+}
+
+func (m *ModelServing) fetcherContainer(d download) (corev1.Container, []corev1.Volume) {
+	mounts := []corev1.VolumeMount{{Name: d.volume, MountPath: d.dir}}
+	volumes := []corev1.Volume{}
+
+	for i, name := range d.secrets {
+		volume := fmt.Sprint(d.prefix, "storage-secret-", i)
+		mounts = append(mounts, corev1.VolumeMount{Name: volume, MountPath: path.Join(StorageSecretsDir, name), ReadOnly: true})
+		volumes = append(volumes, corev1.Volume{
+			Name:         volume,
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: name}},
+		})
+	}
+	if d.claim != "" {
+		volume := d.prefix + "artifact"
+		mounts = append(mounts, corev1.VolumeMount{Name: volume, MountPath: ArtifactMountPath, ReadOnly: true})
+		volumes = append(volumes, corev1.Volume{
+			Name: volume,
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: d.claim,
+				ReadOnly:  true,
+			}},
+		})
+	}
+
+	container := corev1.Container{
+		Name:    d.container,
+		Image:   m.FetcherImage,
+		Command: []string{"/fetcher"},
+		Args: []string{
+			"--storage=" + d.storage,
+			"--digest=" + d.digest,
+			"--output=" + d.output,
+			"--manifest=" + d.manifest,
+			"--secrets-dir=" + StorageSecretsDir,
+			"--mount-path=" + ArtifactMountPath,
+		},
+		VolumeMounts:             mounts,
+		TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+	}
+	return container, volumes
+}
+
+// topologySpreadConstraints are the constraints of the model or, with
+// SpreadZones, a best effort spread of the pods matching labels across zones.
+func (m *ModelServing) topologySpreadConstraints(labels map[string]string) []corev1.TopologySpreadConstraint {
+	if m.TopologySpreadConstraints != nil || !m.SpreadZones {
+		return m.TopologySpreadConstraints
+	}
+	return []corev1.TopologySpreadConstraint{{
+		MaxSkew:           1,
+		TopologyKey:       corev1.LabelTopologyZone,
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+		LabelSelector:     &metav1.LabelSelector{MatchLabels: labels},
+	}}
+}
+
 func (m *ModelServing) CreateDeployment(ctx context.Context, volume *corev1.PersistentVolumeClaim) *appsv1.StatefulSet {
 
-	labels := map[string]string{"serving": m.Name}
+	labels := m.selectorLabels()
+
+	whenDeleted := appsv1.DeletePersistentVolumeClaimRetentionPolicyType
+	if m.RetainVolumes {
+		whenDeleted = appsv1.RetainPersistentVolumeClaimRetentionPolicyType
+	}
+
+	annotations := map[string]string{
+		VersionAnnotation:  m.Version,
+		LocationAnnotation: m.ModelURL,
+	}
+	if m.Protocol != "" {
+		annotations[ProtocolAnnotation] = m.Protocol
+	}
+
+	fetcher, volumes := m.fetcher()
+	// The fetcher runs as a non-root user and writes to the model volume.
+	fsGroup := int64(65532)
 
 	found := &appsv1.StatefulSet{
 		TypeMeta:   metav1.TypeMeta{},
-		ObjectMeta: metav1.ObjectMeta{Name: m.Name, Namespace: m.Namespace},
+		ObjectMeta: metav1.ObjectMeta{Name: m.WorkloadName(), Namespace: m.Namespace},
 		Spec: appsv1.StatefulSetSpec{
 			Replicas: &m.Replicas,
 			Selector: &metav1.LabelSelector{MatchLabels: labels},
 			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      labels,
+					Annotations: annotations,
+				},
 				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{{
-						Image:           fmt.Sprint("plasmashadow/model_serving:", m.Version),
-						ImagePullPolicy: "Always",
-						Name:            "serving",
-						Ports:           []corev1.ContainerPort{{ContainerPort: 4000, Name: "serving"}},
-						Env: []corev1.EnvVar{{
-							Name: "MODEL_PATH",
-							ValueFrom: &v1.EnvVarSource{
-								ConfigMapKeyRef: &v1.ConfigMapKeySelector{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: fmt.Sprint("cf-", m.Name),
-									},
-									Key: "MODEL_PATH",
-								},
-							},
-						}, {
-							Name: "DATA_COLUMNS",
-							ValueFrom: &v1.EnvVarSource{
-								ConfigMapKeyRef: &v1.ConfigMapKeySelector{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: fmt.Sprint("cf-", m.Name),
-									},
-									Key: "COLUMNS",
-								},
-							},
-						}, {
-							Name: "ACCESS_KEY",
-							ValueFrom: &v1.EnvVarSource{
-								ConfigMapKeyRef: &v1.ConfigMapKeySelector{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: fmt.Sprint("cf-", m.Name),
-									},
-									Key: "access_key",
-								},
-							},
-						}, {
-							Name: "SECRET_KEY",
-							ValueFrom: &v1.EnvVarSource{
-								ConfigMapKeyRef: &v1.ConfigMapKeySelector{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: fmt.Sprint("cf-", m.Name),
-									},
-									Key: "secret_key",
-								},
-							},
-						},
-							{
-								Name: "ENDPOINT",
-								ValueFrom: &v1.EnvVarSource{
-									ConfigMapKeyRef: &v1.ConfigMapKeySelector{
-										LocalObjectReference: corev1.LocalObjectReference{
-											Name: fmt.Sprint("cf-", m.Name),
-										},
-										Key: "endpoint",
-									},
-								},
-							},
-							{
-								Name: "BUCKET",
-								ValueFrom: &v1.EnvVarSource{
-									ConfigMapKeyRef: &v1.ConfigMapKeySelector{
-										LocalObjectReference: corev1.LocalObjectReference{
-											Name: fmt.Sprint("cf-", m.Name),
-										},
-										Key: "bucket",
-									},
-								},
-							},
-						},
-						VolumeMounts: []corev1.VolumeMount{{Name: fmt.Sprint("pvc-", m.Name), MountPath: "/data"}}}},
+					Containers:                []corev1.Container{m.servingContainer()},
+					InitContainers:            []corev1.Container{fetcher},
+					Volumes:                   volumes,
+					SecurityContext:           &corev1.PodSecurityContext{FSGroup: &fsGroup},
+					NodeSelector:              m.NodeSelector,
+					Tolerations:               m.Tolerations,
+					Affinity:                  m.Affinity,
+					TopologySpreadConstraints: m.topologySpreadConstraints(labels),
+					PriorityClassName:         m.PriorityClassName,
 				}},
 			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{*volume},
 			ServiceName:          fmt.Sprint("ms-", m.Name),
 			PodManagementPolicy:  "",
 			UpdateStrategy:       appsv1.StatefulSetUpdateStrategy{},
+			// Revisions are kept in the Model status, not as ControllerRevisions.
 			RevisionHistoryLimit: new(int32),
 			MinReadySeconds:      0,
 			PersistentVolumeClaimRetentionPolicy: &appsv1.StatefulSetPersistentVolumeClaimRetentionPolicy{
-				WhenDeleted: "Delete",
+				WhenDeleted: whenDeleted,
 			},
 		},
 		Status: appsv1.StatefulSetStatus{},
@@ -141,35 +511,71 @@ func (m *ModelServing) CreateDeployment(ctx context.Context, volume *corev1.Pers
 }
 
 func (m *ModelServing) CreateVolume(ctx context.Context) *corev1.PersistentVolumeClaim {
+	var storageClassName *string
+	if m.StorageClassName != "" {
+		storageClassName = &m.StorageClassName
+	}
+
 	return &corev1.PersistentVolumeClaim{
-		ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprint("pvc-", m.Name)},
+		ObjectMeta: metav1.ObjectMeta{Name: m.dataVolume()},
 		Spec: corev1.PersistentVolumeClaimSpec{
 			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
 			Resources: corev1.ResourceRequirements{
 				Limits:   map[v1.ResourceName]resource.Quantity{},
-				Requests: map[v1.ResourceName]resource.Quantity{"storage": resource.MustParse("5Gi")},
+				Requests: map[v1.ResourceName]resource.Quantity{"storage": m.StorageSize},
 			},
-			StorageClassName: &storageClassName,
+			StorageClassName: storageClassName,
 		},
 	}
 }
 
-func (m *ModelServing) CreateConfigMap(ctx context.Context, modelPath string, columns string, accessKey string, secretKey string, endpoint string, bucket string) *corev1.ConfigMap {
+func (m *ModelServing) CreateConfigMap(ctx context.Context, modelPath string, columns string, endpoint string, bucket string) *corev1.ConfigMap {
 
 	found := &corev1.ConfigMap{
 		TypeMeta:   metav1.TypeMeta{},
-		ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprint("cf-", m.Name), Namespace: m.Namespace},
+		ObjectMeta: metav1.ObjectMeta{Name: m.ConfigMapName(), Namespace: m.Namespace},
 		Immutable:  new(bool),
 		Data: map[string]string{
-			"MODEL_PATH": modelPath,
-			"COLUMNS":    columns,
-			"access_key": accessKey,
-			"secret_key": secretKey,
-			"endpoint":   endpoint,
-			"bucket":     bucket,
+			"MODEL_PATH":     modelPath,
+			"COLUMNS":        columns,
+			"endpoint":       endpoint,
+			"bucket":         bucket,
+			"STORAGE_URI":    m.StorageURI,
+			"MODEL_MANIFEST": ManifestPath,
 		},
 		BinaryData: map[string][]byte{},
 	}
 
 	return found
 }
+
+// CreateAutoscaler renders a HorizontalPodAutoscaler scaling the stable
+// StatefulSet between minReplicas and maxReplicas to meet metrics.
+func (m *ModelServing) CreateAutoscaler(ctx context.Context, minReplicas *int32, maxReplicas int32, metrics []autoscalingv2.MetricSpec) *autoscalingv2.HorizontalPodAutoscaler {
+	return &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: m.Name, Namespace: m.Namespace},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "StatefulSet",
+				Name:       m.Name,
+			},
+			MinReplicas: minReplicas,
+			MaxReplicas: maxReplicas,
+			Metrics:     metrics,
+		},
+	}
+}
+
+// CreateSecret renders the operator-managed Secret holding credentials that
+// were given inline on the Model.
+func (m *ModelServing) CreateSecret(ctx context.Context) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: m.SecretName(), Namespace: m.Namespace},
+		Type:       corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			DefaultAccessKeyKey: []byte(m.AccessKey),
+			DefaultSecretKeyKey: []byte(m.SecretKey),
+		},
+	}
+}
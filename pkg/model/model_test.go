@@ -0,0 +1,89 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/kalkyai/model-serving-operator/pkg/runtimes"
+)
+
+func envValue(container corev1.Container, name string) (corev1.EnvVar, bool) {
+	for _, env := range container.Env {
+		if env.Name == name {
+			return env, true
+		}
+	}
+	return corev1.EnvVar{}, false
+}
+
+func TestServingContainerArgs(t *testing.T) {
+	tests := []struct {
+		framework string
+		image     string
+		args      []string
+	}{
+		{framework: runtimes.SKLearn, image: "plasmashadow/model_serving:0.6"},
+		{
+			framework: runtimes.XGBoost,
+			image:     "kserve/xgbserver:v0.9.0",
+			args:      []string{"--model_name=$(MODEL_NAME)", "--model_dir=$(MODEL_DIR)", "--http_port=$(PORT)"},
+		},
+		{
+			framework: runtimes.ONNX,
+			image:     "mcr.microsoft.com/onnxruntime/server:v1.0.0",
+			args:      []string{"--model_path=$(MODEL_PATH)", "--http_port=$(PORT)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.framework, func(t *testing.T) {
+			runtime, _ := runtimes.Lookup(tt.framework)
+			port := runtime.Port
+			if port == 0 {
+				port = 4000
+			}
+			m := &ModelServing{
+				Name: "iris", Namespace: "team", Version: "0.6", Port: port,
+				ImageRepository: "plasmashadow/model_serving", Runtime: runtime,
+			}
+			container := m.servingContainer()
+
+			if container.Image != tt.image {
+				t.Errorf("image = %s, want %s", container.Image, tt.image)
+			}
+			if len(container.Args) != len(tt.args) {
+				t.Fatalf("args = %v, want %v", container.Args, tt.args)
+			}
+			for i := range tt.args {
+				if container.Args[i] != tt.args[i] {
+					t.Errorf("args = %v, want %v", container.Args, tt.args)
+				}
+			}
+
+			// Every variable the args refer to is set on the container.
+			for _, name := range []string{"MODEL_NAME", "MODEL_DIR", "MODEL_PATH", "PORT"} {
+				if _, ok := envValue(container, name); !ok {
+					t.Errorf("%s is not set", name)
+				}
+			}
+			if env, _ := envValue(container, "PORT"); env.Value != fmt.Sprint(port) {
+				t.Errorf("PORT = %s, want %d", env.Value, port)
+			}
+			if container.Ports[0].ContainerPort != port {
+				t.Errorf("container port = %d, want %d", container.Ports[0].ContainerPort, port)
+			}
+		})
+	}
+}
+
+func TestServingContainerArgsNotShared(t *testing.T) {
+	runtime, _ := runtimes.Lookup(runtimes.XGBoost)
+	m := &ModelServing{Name: "iris", Port: 8080, Runtime: runtime}
+	container := m.servingContainer()
+	container.Args[0] = "--model_name=changed"
+
+	if again, _ := runtimes.Lookup(runtimes.XGBoost); again.Args[0] != "--model_name=$(MODEL_NAME)" {
+		t.Errorf("rendering a container changed the registry: %v", again.Args)
+	}
+}
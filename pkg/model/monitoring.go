@@ -0,0 +1,189 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utils "k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Prometheus Operator versions the monitoring objects are written in.
+var (
+	ServiceMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "ServiceMonitor"}
+	PrometheusRuleGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "PrometheusRule"}
+)
+
+// MetricsLabel marks the metrics Service of a model, its value is the model
+// name.
+const MetricsLabel = "ml.kalkyai.com/metrics"
+
+// Monitoring scrapes the serving pods at Port and Path. Labels are added to
+// the ServiceMonitor and PrometheusRule.
+type Monitoring struct {
+	// Port zero is the serving port.
+	Port int32
+	Path string
+	// Interval between scrapes, zero for the Prometheus default.
+	Interval time.Duration
+	Labels   map[string]string
+
+	// Alerts are the thresholds of the default alerts, nil for none.
+	Alerts *Alerts
+}
+
+// Alerts fire once a threshold has been crossed for For.
+type Alerts struct {
+	For time.Duration
+	// MaxErrorRatio is the share of 5xx responses, between 0 and 1.
+	MaxErrorRatio float64
+	// MaxLatency is the 99th percentile of request durations.
+	MaxLatency time.Duration
+	// RequestsMetric counts requests by status in a code label.
+	// LatencyMetric is a histogram of request durations in seconds.
+	RequestsMetric string
+	LatencyMetric  string
+	// AlwaysUp also fires ModelDown when no serving pod is scraped at
+	// all, for models that are not expected to scale to zero.
+	AlwaysUp bool
+}
+
+// MonitorName is the name of the ServiceMonitor and PrometheusRule.
+func (m *ModelServing) MonitorName() string {
+	return fmt.Sprint("ms-", m.Name)
+}
+
+// MetricsServiceName is the name of the Service scraped for metrics.
+func (m *ModelServing) MetricsServiceName() string {
+	return fmt.Sprint("ms-", m.Name, "-metrics")
+}
+
+func (m *ModelServing) monitorLabels() map[string]string {
+	labels := map[string]string{}
+	for key, value := range m.Monitoring.Labels {
+		labels[key] = value
+	}
+	return labels
+}
+
+// CreateMetricsService renders the headless Service listing every serving
+// pod, ready or not, for Prometheus. The model Service cannot be used as it
+// points at the activator while the model has an idle timeout.
+func (m *ModelServing) CreateMetricsService(ctx context.Context) *corev1.Service {
+	port := m.Monitoring.Port
+	if port == 0 {
+		port = m.Port
+	}
+
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      m.MetricsServiceName(),
+			Namespace: m.Namespace,
+			Labels:    map[string]string{MetricsLabel: m.Name},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:                corev1.ClusterIPNone,
+			Selector:                 map[string]string{"serving": m.Name},
+			PublishNotReadyAddresses: true,
+			Ports: []corev1.ServicePort{{
+				Name:       "metrics",
+				Port:       port,
+				TargetPort: utils.FromInt(int(port)),
+			}},
+		},
+	}
+}
+
+// CreateServiceMonitor renders the ServiceMonitor scraping the metrics
+// Service. The Prometheus Operator types are not a dependency of the
+// operator, so it is unstructured.
+func (m *ModelServing) CreateServiceMonitor(ctx context.Context) *unstructured.Unstructured {
+	endpoint := map[string]interface{}{"port": "metrics", "path": m.Monitoring.Path}
+	if m.Monitoring.Interval > 0 {
+		endpoint["interval"] = promDuration(m.Monitoring.Interval)
+	}
+
+	monitor := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"selector": map[string]interface{}{
+				"matchLabels": map[string]interface{}{MetricsLabel: m.Name},
+			},
+			"endpoints": []interface{}{endpoint},
+		},
+	}}
+	monitor.SetGroupVersionKind(ServiceMonitorGVK)
+	monitor.SetName(m.MonitorName())
+	monitor.SetNamespace(m.Namespace)
+	monitor.SetLabels(m.monitorLabels())
+	return monitor
+}
+
+// CreatePrometheusRule renders the ModelDown, ModelHighErrorRate and
+// ModelHighLatency alerts of the model.
+func (m *ModelServing) CreatePrometheusRule(ctx context.Context) *unstructured.Unstructured {
+	alerts := m.Monitoring.Alerts
+	selector := fmt.Sprintf(`namespace=%q,service=%q`, m.Namespace, m.MetricsServiceName())
+	window := "5m"
+
+	down := fmt.Sprintf(`sum(up{%s}) == 0`, selector)
+	if alerts.AlwaysUp {
+		down = fmt.Sprintf(`%s or absent(up{%s})`, down, selector)
+	}
+	errorRate := fmt.Sprintf(`sum(rate(%[1]s{%[2]s,code=~"5.."}[%[3]s])) / sum(rate(%[1]s{%[2]s}[%[3]s])) > %[4]s`,
+		alerts.RequestsMetric, selector, window, formatFloat(alerts.MaxErrorRatio))
+	latency := fmt.Sprintf(`histogram_quantile(0.99, sum by (le) (rate(%s_bucket{%s}[%s]))) > %s`,
+		alerts.LatencyMetric, selector, window, formatFloat(alerts.MaxLatency.Seconds()))
+
+	qualified := fmt.Sprint(m.Namespace, "/", m.Name)
+	rule := func(alert, expr, severity, summary string) interface{} {
+		return map[string]interface{}{
+			"alert": alert,
+			"expr":  expr,
+			"for":   promDuration(alerts.For),
+			"labels": map[string]interface{}{
+				"severity": severity,
+				"model":    m.Name,
+			},
+			"annotations": map[string]interface{}{"summary": summary},
+		}
+	}
+
+	prometheusRule := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"groups": []interface{}{map[string]interface{}{
+				"name": m.MonitorName(),
+				"rules": []interface{}{
+					rule("ModelDown", down, "critical",
+						fmt.Sprintf("No serving pod of model %s answers metrics scrapes.", qualified)),
+					rule("ModelHighErrorRate", errorRate, "warning",
+						fmt.Sprintf("Model %s answers more than %s%% of requests with an error.", qualified, formatFloat(alerts.MaxErrorRatio*100))),
+					rule("ModelHighLatency", latency, "warning",
+						fmt.Sprintf("The 99th percentile latency of model %s is above %s.", qualified, alerts.MaxLatency)),
+				},
+			}},
+		},
+	}}
+	prometheusRule.SetGroupVersionKind(PrometheusRuleGVK)
+	prometheusRule.SetName(m.MonitorName())
+	prometheusRule.SetNamespace(m.Namespace)
+	prometheusRule.SetLabels(m.monitorLabels())
+	return prometheusRule
+}
+
+// promDuration formats d the way Prometheus parses durations, which has no
+// fractional units.
+func promDuration(d time.Duration) string {
+	if d%time.Second == 0 {
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	return fmt.Sprintf("%dms", d/time.Millisecond)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
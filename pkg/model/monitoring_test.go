@@ -0,0 +1,59 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestCreatePrometheusRule(t *testing.T) {
+	m := &ModelServing{Name: "iris", Namespace: "team", Port: 4000, Monitoring: &Monitoring{
+		Path: "/metrics",
+		Alerts: &Alerts{
+			For:            90 * time.Second,
+			MaxErrorRatio:  0.005,
+			MaxLatency:     250 * time.Millisecond,
+			RequestsMetric: "http_requests_total",
+			LatencyMetric:  "http_request_duration_seconds",
+		},
+	}}
+
+	rules, _, err := unstructured.NestedSlice(m.CreatePrometheusRule(context.Background()).Object, "spec", "groups")
+	if err != nil || len(rules) != 1 {
+		t.Fatalf("groups = %v, %v", rules, err)
+	}
+	got := map[string]string{}
+	for _, rule := range rules[0].(map[string]interface{})["rules"].([]interface{}) {
+		rule := rule.(map[string]interface{})
+		got[rule["alert"].(string)] = rule["expr"].(string)
+		if rule["for"] != "90s" {
+			t.Errorf("%s for = %v", rule["alert"], rule["for"])
+		}
+	}
+
+	want := map[string]string{
+		"ModelDown": `sum(up{namespace="team",service="ms-iris-metrics"}) == 0`,
+		"ModelHighErrorRate": `sum(rate(http_requests_total{namespace="team",service="ms-iris-metrics",code=~"5.."}[5m]))` +
+			` / sum(rate(http_requests_total{namespace="team",service="ms-iris-metrics"}[5m])) > 0.005`,
+		"ModelHighLatency": `histogram_quantile(0.99, sum by (le) (rate(http_request_duration_seconds_bucket{namespace="team",service="ms-iris-metrics"}[5m]))) > 0.25`,
+	}
+	for alert, expr := range want {
+		if got[alert] != expr {
+			t.Errorf("%s expr = %s, want %s", alert, got[alert], expr)
+		}
+	}
+}
+
+func TestCreateMetricsService(t *testing.T) {
+	m := &ModelServing{Name: "iris", Namespace: "team", Port: 4000, Monitoring: &Monitoring{}}
+	if port := m.CreateMetricsService(context.Background()).Spec.Ports[0].Port; port != 4000 {
+		t.Errorf("port = %d, want the serving port", port)
+	}
+
+	m.Monitoring.Port = 9090
+	if port := m.CreateMetricsService(context.Background()).Spec.Ports[0].Port; port != 9090 {
+		t.Errorf("port = %d, want 9090", port)
+	}
+}
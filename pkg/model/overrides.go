@@ -0,0 +1,41 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// MergePodTemplate merges overrides, a partial pod template, onto template
+// with strategic merge patch semantics: containers, volumes and env are
+// merged by name, maps key by key. The merged template must keep the labels
+// in selector.
+func MergePodTemplate(template *corev1.PodTemplateSpec, overrides []byte, selector map[string]string) error {
+	original, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	merged, err := strategicpatch.StrategicMergePatch(original, overrides, corev1.PodTemplateSpec{})
+	if err != nil {
+		return err
+	}
+
+	result := corev1.PodTemplateSpec{}
+	decoder := json.NewDecoder(bytes.NewReader(merged))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&result); err != nil {
+		return err
+	}
+
+	for key, value := range selector {
+		if result.Labels[key] != value {
+			return fmt.Errorf("label %s selects the serving pods and cannot be overridden", key)
+		}
+	}
+
+	*template = result
+	return nil
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func template() *corev1.PodTemplateSpec {
+	return &corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"serving": "iris"}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:  "serving",
+			Image: "plasmashadow/model_serving:0.6",
+			Env:   []corev1.EnvVar{{Name: "MODEL_NAME", Value: "iris"}},
+		}}},
+	}
+}
+
+func TestMergePodTemplate(t *testing.T) {
+	tmpl := template()
+	overrides := `{
+		"metadata": {"annotations": {"sidecar.istio.io/inject": "true"}},
+		"spec": {
+			"containers": [
+				{"name": "serving", "env": [{"name": "LOG_LEVEL", "value": "debug"}]},
+				{"name": "proxy", "image": "envoyproxy/envoy:v1.22"}
+			]
+		}
+	}`
+	if err := MergePodTemplate(tmpl, []byte(overrides), map[string]string{"serving": "iris"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if tmpl.Annotations["sidecar.istio.io/inject"] != "true" {
+		t.Errorf("annotations = %v", tmpl.Annotations)
+	}
+	if len(tmpl.Spec.Containers) != 2 {
+		t.Fatalf("got %d containers, want the serving container and the sidecar", len(tmpl.Spec.Containers))
+	}
+	serving := tmpl.Spec.Containers[0]
+	if serving.Image != "plasmashadow/model_serving:0.6" || len(serving.Env) != 2 {
+		t.Errorf("serving container = %+v, want its image kept and env merged", serving)
+	}
+}
+
+func TestMergePodTemplateConflicts(t *testing.T) {
+	tests := map[string]string{
+		"selector label": `{"metadata": {"labels": {"serving": "other"}}}`,
+		"unknown field":  `{"spec": {"containerz": []}}`,
+		"wrong type":     `{"spec": {"containers": {"name": "serving"}}}`,
+	}
+	for name, overrides := range tests {
+		tmpl := template()
+		if err := MergePodTemplate(tmpl, []byte(overrides), map[string]string{"serving": "iris"}); err == nil {
+			t.Errorf("%s: merged without error", name)
+		}
+		if !strings.HasPrefix(tmpl.Spec.Containers[0].Image, "plasmashadow/") || tmpl.Labels["serving"] != "iris" {
+			t.Errorf("%s: template changed on error", name)
+		}
+	}
+}
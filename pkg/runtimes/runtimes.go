@@ -0,0 +1,104 @@
+// Package runtimes is the registry of the serving stacks used for each model
+// framework.
+package runtimes
+
+import (
+	"sort"
+)
+
+// Frameworks a Model can be written in.
+const (
+	SKLearn  = "sklearn"
+	XGBoost  = "xgboost"
+	LightGBM = "lightgbm"
+	ONNX     = "onnx"
+	PMML     = "pmml"
+	// Custom serves the model with the image repository given on the
+	// Model, configured like the sklearn image.
+	Custom = "custom"
+)
+
+// NamePlaceholder is replaced with the model name in health endpoints.
+const NamePlaceholder = "{name}"
+
+// Runtime describes how to serve the models of one framework. Args may refer
+// to the MODEL_NAME, MODEL_DIR, MODEL_PATH and PORT variables of the serving
+// container as $(VAR).
+type Runtime struct {
+	// Image is the serving image, pinned to a release so every replica runs
+	// the same server. Empty means the image repository of the Model tagged
+	// with its version, the convention of the sklearn image.
+	Image string
+	Args  []string
+	// Port the server listens on. Zero uses the operator default port.
+	Port int32
+	// ModelPathEnv is the variable the server reads the model path from.
+	ModelPathEnv string
+	// ReadinessPath and LivenessPath are the HTTP health endpoints. Empty
+	// when the server has none, the serving port is checked over TCP
+	// instead. They may contain NamePlaceholder. ReadinessPath also
+	// answers the startup probe.
+	ReadinessPath string
+	LivenessPath  string
+}
+
+// kserveArgs configure the KServe model servers.
+var kserveArgs = []string{
+	"--model_name=$(MODEL_NAME)",
+	"--model_dir=$(MODEL_DIR)",
+	"--http_port=$(PORT)",
+}
+
+var registry = map[string]Runtime{
+	SKLearn: {ModelPathEnv: "MODEL_PATH"},
+	XGBoost: {
+		Image:         "kserve/xgbserver:v0.9.0",
+		Args:          kserveArgs,
+		Port:          8080,
+		ModelPathEnv:  "MODEL_PATH",
+		ReadinessPath: "/v1/models/" + NamePlaceholder,
+		LivenessPath:  "/",
+	},
+	LightGBM: {
+		Image:         "kserve/lgbserver:v0.9.0",
+		Args:          kserveArgs,
+		Port:          8080,
+		ModelPathEnv:  "MODEL_PATH",
+		ReadinessPath: "/v1/models/" + NamePlaceholder,
+		LivenessPath:  "/",
+	},
+	ONNX: {
+		Image:        "mcr.microsoft.com/onnxruntime/server:v1.0.0",
+		Args:         []string{"--model_path=$(MODEL_PATH)", "--http_port=$(PORT)"},
+		Port:         8001,
+		ModelPathEnv: "MODEL_PATH",
+	},
+	PMML: {
+		Image:         "kserve/pmmlserver:v0.9.0",
+		Args:          kserveArgs,
+		Port:          8080,
+		ModelPathEnv:  "MODEL_PATH",
+		ReadinessPath: "/v1/models/" + NamePlaceholder,
+		LivenessPath:  "/",
+	},
+	Custom: {ModelPathEnv: "MODEL_PATH"},
+}
+
+// Lookup returns the runtime of framework. An empty framework is sklearn.
+func Lookup(framework string) (Runtime, bool) {
+	if framework == "" {
+		framework = SKLearn
+	}
+	runtime, ok := registry[framework]
+	return runtime, ok
+}
+
+// Frameworks lists the frameworks in the registry.
+func Frameworks() []string {
+	frameworks := make([]string, 0, len(registry))
+	for framework := range registry {
+		frameworks = append(frameworks, framework)
+	}
+	sort.Strings(frameworks)
+	return frameworks
+}
@@ -0,0 +1,61 @@
+package runtimes
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		framework string
+		image     string
+		port      int32
+		ok        bool
+	}{
+		{framework: "", image: "", port: 0, ok: true},
+		{framework: SKLearn, image: "", port: 0, ok: true},
+		{framework: XGBoost, image: "kserve/xgbserver:v0.9.0", port: 8080, ok: true},
+		{framework: ONNX, image: "mcr.microsoft.com/onnxruntime/server:v1.0.0", port: 8001, ok: true},
+		{framework: "tensorflow", ok: false},
+	}
+	for _, tt := range tests {
+		runtime, ok := Lookup(tt.framework)
+		if ok != tt.ok {
+			t.Errorf("Lookup(%q) found = %t, want %t", tt.framework, ok, tt.ok)
+			continue
+		}
+		if runtime.Image != tt.image || runtime.Port != tt.port {
+			t.Errorf("Lookup(%q) = %s on %d, want %s on %d", tt.framework, runtime.Image, runtime.Port, tt.image, tt.port)
+		}
+	}
+}
+
+func TestFrameworks(t *testing.T) {
+	frameworks := Frameworks()
+	if !sort.StringsAreSorted(frameworks) {
+		t.Errorf("frameworks are not sorted: %v", frameworks)
+	}
+	if len(frameworks) != len(registry) {
+		t.Errorf("frameworks = %v, want every registered framework", frameworks)
+	}
+	for _, framework := range frameworks {
+		if _, ok := Lookup(framework); !ok {
+			t.Errorf("%s is listed but not registered", framework)
+		}
+	}
+}
+
+func TestImagesArePinned(t *testing.T) {
+	for framework, runtime := range registry {
+		if runtime.Image == "" {
+			continue
+		}
+		if i := strings.LastIndex(runtime.Image, ":"); i < 0 || runtime.Image[i+1:] == "latest" {
+			t.Errorf("the %s image %s is not pinned to a release", framework, runtime.Image)
+		}
+		if runtime.ModelPathEnv == "" {
+			t.Errorf("the %s runtime reads no model path", framework)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Package s3 is a minimal client for S3 compatible object stores such as
+// AWS S3 and DigitalOcean Spaces. Requests use path-style addressing and are
+// signed with AWS Signature Version 4.
+package s3
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultRegion is used when Client.Region is empty. S3 compatible stores
+// that are not region aware accept it.
+const DefaultRegion = "us-east-1"
+
+// emptyPayloadHash is the SHA-256 of an empty body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Client talks to a single S3 endpoint. Requests are anonymous when
+// AccessKey is empty.
+type Client struct {
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+
+	HTTPClient *http.Client
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+// Error is returned for responses outside the 2xx range.
+type Error struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("s3: unexpected status %d: %s", e.StatusCode, e.Body)
+}
+
+// IsNotFound reports whether err is an S3 404 response.
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// DeleteObject removes key from bucket. Deleting a missing key succeeds,
+// as it does on S3.
+func (c *Client) DeleteObject(ctx context.Context, bucket string, key string) error {
+	resp, err := c.Do(ctx, http.MethodDelete, bucket, key, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// GetObject returns the content of key in bucket. The caller must close it.
+func (c *Client) GetObject(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	resp, err := c.Do(ctx, http.MethodGet, bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Do sends a signed request for key in bucket and returns the response if
+// its status is 2xx. The caller must close the body.
+func (c *Client) Do(ctx context.Context, method string, bucket string, key string, header http.Header) (*http.Response, error) {
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/" + bucket + "/" + strings.TrimPrefix(key, "/")
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	c.sign(req)
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &Error{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return resp, nil
+}
+
+// sign adds the Signature Version 4 headers to a request without a body.
+func (c *Client) sign(req *http.Request) {
+	if c.AccessKey == "" {
+		return
+	}
+
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	t := now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	region := c.Region
+	if region == "" {
+		region = DefaultRegion
+	}
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := []string{}
+	for _, k := range keys {
+		vs := values[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode escapes s the way Signature Version 4 expects, which differs
+// from url.QueryEscape in how spaces and '~' are treated.
+func uriEncode(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(url.QueryEscape(s), "+", "%20"), "%7E", "~")
+}
+
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
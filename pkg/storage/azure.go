@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+// azureAPIVersion is the Blob service version requests are made against.
+const azureAPIVersion = "2021-08-06"
+
+type azureBlobBackend struct {
+	client *http.Client
+	url    string
+	// sasToken is the query string of a shared access signature.
+	sasToken string
+}
+
+func newAzureBlob(ctx context.Context, spec *mlv1alpha1.AzureBlobStorage, secrets Secrets, opts Options) (*azureBlobBackend, error) {
+	endpoint := spec.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", spec.AccountName)
+	}
+
+	b := &azureBlobBackend{
+		client: opts.httpClient(),
+		url: fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(endpoint, "/"),
+			url.PathEscape(spec.Container), escapePath(strings.TrimPrefix(spec.Blob, "/"))),
+	}
+
+	if ref := spec.SASTokenSecretRef; ref != nil {
+		token, err := secretValue(ctx, secrets, ref.Name, ref.Key)
+		if err != nil {
+			return nil, err
+		}
+		b.sasToken = strings.TrimPrefix(strings.TrimSpace(token), "?")
+	}
+
+	return b, nil
+}
+
+func (b *azureBlobBackend) request(ctx context.Context, method string) (*http.Request, error) {
+	u := b.url
+	if b.sasToken != "" {
+		u += "?" + b.sasToken
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	return req, nil
+}
+
+func (b *azureBlobBackend) Open(ctx context.Context) (io.ReadCloser, error) {
+	req, err := b.request(ctx, http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := do(b.client, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (b *azureBlobBackend) Delete(ctx context.Context) error {
+	req, err := b.request(ctx, http.MethodDelete)
+	if err != nil {
+		return err
+	}
+	resp, err := do(b.client, req)
+	if IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// escapePath escapes each segment of a slash separated path.
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+const (
+	gcsEndpoint = "https://storage.googleapis.com"
+	gcsTokenURL = "https://oauth2.googleapis.com/token"
+	gcsScope    = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+// serviceAccountKey holds the fields of a Google service account JSON key
+// needed to mint access tokens.
+type serviceAccountKey struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+}
+
+type gcsBackend struct {
+	client   *http.Client
+	endpoint string
+	bucket   string
+	object   string
+}
+
+func newGCS(ctx context.Context, spec *mlv1alpha1.GCSStorage, secrets Secrets, opts Options) (*gcsBackend, error) {
+	client := opts.httpClient()
+
+	if ref := spec.ServiceAccountKeySecretRef; ref != nil {
+		value, err := secretValue(ctx, secrets, ref.Name, ref.Key)
+		if err != nil {
+			return nil, err
+		}
+		key := serviceAccountKey{}
+		if err := json.Unmarshal([]byte(value), &key); err != nil {
+			return nil, fmt.Errorf("storage: invalid service account key in secret %s: %w", ref.Name, err)
+		}
+		if key.TokenURI == "" {
+			key.TokenURI = gcsTokenURL
+		}
+
+		conf := &jwt.Config{
+			Email:        key.ClientEmail,
+			PrivateKey:   []byte(key.PrivateKey),
+			PrivateKeyID: key.PrivateKeyID,
+			Scopes:       []string{gcsScope},
+			TokenURL:     key.TokenURI,
+		}
+		// Tokens are fetched with the same HTTP client, for the lifetime
+		// of the backend rather than of ctx.
+		client = conf.Client(context.WithValue(context.Background(), oauth2.HTTPClient, client))
+	}
+
+	return &gcsBackend{client: client, endpoint: gcsEndpoint, bucket: spec.Bucket, object: spec.Object}, nil
+}
+
+func (b *gcsBackend) objectURL(prefix string) string {
+	return fmt.Sprintf("%s%s/storage/v1/b/%s/o/%s", b.endpoint, prefix, url.PathEscape(b.bucket), url.PathEscape(b.object))
+}
+
+func (b *gcsBackend) Open(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.objectURL("/download")+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := do(b.client, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (b *gcsBackend) Delete(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, b.objectURL(""), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := do(b.client, req)
+	if IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
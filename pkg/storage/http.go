@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+type httpBackend struct {
+	client        *http.Client
+	url           string
+	authorization string
+}
+
+func newHTTP(ctx context.Context, spec *mlv1alpha1.HTTPStorage, secrets Secrets, opts Options) (*httpBackend, error) {
+	b := &httpBackend{client: opts.httpClient(), url: spec.URL}
+
+	if ref := spec.AuthorizationSecretRef; ref != nil {
+		value, err := secretValue(ctx, secrets, ref.Name, ref.Key)
+		if err != nil {
+			return nil, err
+		}
+		b.authorization = value
+	}
+
+	return b, nil
+}
+
+func (b *httpBackend) Open(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if b.authorization != "" {
+		req.Header.Set("Authorization", b.authorization)
+	}
+	resp, err := do(b.client, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete is not supported, the model belongs to whoever runs the server.
+func (b *httpBackend) Delete(ctx context.Context) error {
+	return ErrNotSupported
+}
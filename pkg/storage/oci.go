@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+// Manifest media types accepted from the registry.
+var ociManifestTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+// dockerConfig is the content of a kubernetes.io/dockerconfigjson Secret.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Auth     string `json:"auth"`
+	} `json:"auths"`
+}
+
+type ociBackend struct {
+	client     *http.Client
+	base       string
+	repository string
+	reference  string
+
+	username string
+	password string
+	// token is the bearer token handed out by the registry.
+	token string
+}
+
+// parseReference splits registry/repository:tag or registry/repository@digest.
+func parseReference(ref string) (string, string, string, error) {
+	registry, rest, ok := strings.Cut(ref, "/")
+	if !ok || rest == "" {
+		return "", "", "", fmt.Errorf("storage: reference %q has no registry", ref)
+	}
+	if repository, digest, ok := strings.Cut(rest, "@"); ok {
+		return registry, repository, digest, nil
+	}
+	if i := strings.LastIndex(rest, ":"); i > 0 {
+		return registry, rest[:i], rest[i+1:], nil
+	}
+	return registry, rest, "latest", nil
+}
+
+func newOCI(ctx context.Context, spec *mlv1alpha1.OCIStorage, secrets Secrets, opts Options) (*ociBackend, error) {
+	registry, repository, reference, err := parseReference(spec.Reference)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := "https"
+	if spec.PlainHTTP {
+		scheme = "http"
+	}
+	b := &ociBackend{
+		client:     opts.httpClient(),
+		base:       fmt.Sprintf("%s://%s/v2/%s", scheme, registry, repository),
+		repository: repository,
+		reference:  reference,
+	}
+
+	if ref := spec.PullSecretRef; ref != nil {
+		value, err := secretValue(ctx, secrets, ref.Name, corev1.DockerConfigJsonKey)
+		if err != nil {
+			return nil, err
+		}
+		config := dockerConfig{}
+		if err := json.Unmarshal([]byte(value), &config); err != nil {
+			return nil, fmt.Errorf("storage: invalid docker config in secret %s: %w", ref.Name, err)
+		}
+		if auth, ok := config.Auths[registry]; ok {
+			b.username, b.password = auth.Username, auth.Password
+			if auth.Auth != "" {
+				decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+				if err != nil {
+					return nil, fmt.Errorf("storage: invalid auth for %s in secret %s: %w", registry, ref.Name, err)
+				}
+				b.username, b.password, _ = strings.Cut(string(decoded), ":")
+			}
+		}
+	}
+
+	return b, nil
+}
+
+// Open resolves the manifest and returns the content of its only layer.
+func (b *ociBackend) Open(ctx context.Context) (io.ReadCloser, error) {
+	resp, err := b.get(ctx, b.base+"/manifests/"+b.reference, strings.Join(ociManifestTypes, ", "))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	manifest := ociManifest{}
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("storage: invalid manifest for %s:%s: %w", b.repository, b.reference, err)
+	}
+	if len(manifest.Layers) != 1 {
+		return nil, fmt.Errorf("storage: artifact %s:%s has %d layers, expected the model as its only layer",
+			b.repository, b.reference, len(manifest.Layers))
+	}
+
+	resp, err = b.get(ctx, b.base+"/blobs/"+manifest.Layers[0].Digest, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete is not supported, artifacts in a registry are usually shared.
+func (b *ociBackend) Delete(ctx context.Context) error {
+	return ErrNotSupported
+}
+
+// get sends a GET to the registry and answers its authentication challenge.
+func (b *ociBackend) get(ctx context.Context, u string, accept string) (*http.Response, error) {
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		b.authorize(req)
+		return req, nil
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return check(req, resp)
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if err := b.login(ctx, challenge); err != nil {
+		return nil, err
+	}
+	req, err = newRequest()
+	if err != nil {
+		return nil, err
+	}
+	return do(b.client, req)
+}
+
+func (b *ociBackend) authorize(req *http.Request) {
+	switch {
+	case b.token != "":
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	case b.username != "":
+		req.SetBasicAuth(b.username, b.password)
+	}
+}
+
+// login fetches a bearer token as asked by a WWW-Authenticate challenge.
+// Basic challenges are answered by sending the credentials directly.
+func (b *ociBackend) login(ctx context.Context, challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		if b.username == "" {
+			return &Error{URL: b.base, StatusCode: http.StatusUnauthorized, Body: "registry requires credentials"}
+		}
+		return nil
+	}
+
+	values := parseChallenge(params)
+	realm, err := url.Parse(values["realm"])
+	if err != nil || values["realm"] == "" {
+		return fmt.Errorf("storage: invalid authentication challenge %q", challenge)
+	}
+	query := realm.Query()
+	if service := values["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := values["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", b.repository)
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if b.username != "" {
+		req.SetBasicAuth(b.username, b.password)
+	}
+	resp, err := do(b.client, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("storage: invalid token response: %w", err)
+	}
+	b.token = token.Token
+	if b.token == "" {
+		b.token = token.AccessToken
+	}
+	return nil
+}
+
+// parseChallenge parses the key="value" pairs of a WWW-Authenticate header.
+func parseChallenge(params string) map[string]string {
+	values := map[string]string{}
+	for params != "" {
+		var pair string
+		// Values are quoted and may contain commas.
+		key, rest, ok := strings.Cut(params, "=")
+		if !ok {
+			break
+		}
+		key = strings.TrimSpace(key)
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				break
+			}
+			pair, params = rest[1:end+1], strings.TrimPrefix(strings.TrimSpace(rest[end+2:]), ",")
+		} else {
+			pair, params, _ = strings.Cut(rest, ",")
+		}
+		values[key] = pair
+		params = strings.TrimSpace(params)
+	}
+	return values
+}
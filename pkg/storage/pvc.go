@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+// DefaultMountPath is where the claim of a PVC backend is mounted when
+// Options.MountPath is empty.
+const DefaultMountPath = "/mnt/models"
+
+type pvcBackend struct {
+	path string
+}
+
+func newPVC(spec *mlv1alpha1.PVCStorage, opts Options) *pvcBackend {
+	mountPath := opts.MountPath
+	if mountPath == "" {
+		mountPath = DefaultMountPath
+	}
+	// Joining a cleaned rooted path keeps the model inside the volume.
+	return &pvcBackend{path: filepath.Join(mountPath, filepath.Clean("/"+spec.Path))}
+}
+
+func (b *pvcBackend) Open(ctx context.Context) (io.ReadCloser, error) {
+	return os.Open(b.path)
+}
+
+// Delete is not supported, the claim is not managed by the operator and is
+// only ever mounted read only.
+func (b *pvcBackend) Delete(ctx context.Context) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+	"github.com/kalkyai/model-serving-operator/pkg/s3"
+)
+
+// Default keys looked up in an S3 credentials Secret.
+const (
+	DefaultAccessKeyKey = "access_key"
+	DefaultSecretKeyKey = "secret_key"
+)
+
+type s3Backend struct {
+	client *s3.Client
+	bucket string
+	key    string
+}
+
+func newS3(ctx context.Context, spec *mlv1alpha1.S3Storage, secrets Secrets, opts Options) (*s3Backend, error) {
+	client := &s3.Client{
+		Endpoint:   spec.Endpoint,
+		Region:     spec.Region,
+		HTTPClient: opts.HTTPClient,
+	}
+
+	if ref := spec.CredentialsSecretRef; ref != nil {
+		accessKeyKey, secretKeyKey := ref.AccessKeyKey, ref.SecretKeyKey
+		if accessKeyKey == "" {
+			accessKeyKey = DefaultAccessKeyKey
+		}
+		if secretKeyKey == "" {
+			secretKeyKey = DefaultSecretKeyKey
+		}
+
+		data, err := secrets(ctx, ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		client.AccessKey = string(data[accessKeyKey])
+		client.SecretKey = string(data[secretKeyKey])
+	}
+
+	return &s3Backend{client: client, bucket: spec.Bucket, key: spec.Key}, nil
+}
+
+func (b *s3Backend) Open(ctx context.Context) (io.ReadCloser, error) {
+	body, err := b.client.GetObject(ctx, b.bucket, b.key)
+	return body, b.wrap(err)
+}
+
+func (b *s3Backend) Delete(ctx context.Context) error {
+	return b.wrap(b.client.DeleteObject(ctx, b.bucket, b.key))
+}
+
+// wrap turns S3 errors into storage errors so IsNotFound works on them.
+func (b *s3Backend) wrap(err error) error {
+	var e *s3.Error
+	if errors.As(err, &e) {
+		return &Error{URL: "s3://" + b.bucket + "/" + b.key, StatusCode: e.StatusCode, Body: e.Body}
+	}
+	return err
+}
@@ -0,0 +1,180 @@
+// Package storage reads model artifacts from the places a Model can point
+// at: S3 compatible object stores, Google Cloud Storage, Azure Blob Storage,
+// web servers, volume claims and OCI registries.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+// ErrNotSupported is returned by backends that cannot perform an operation,
+// such as deleting a model served by a web server.
+var ErrNotSupported = errors.New("storage: operation not supported by the backend")
+
+// Backend is one model artifact in a store.
+type Backend interface {
+	// Open returns the content of the artifact. The caller must close it.
+	Open(ctx context.Context) (io.ReadCloser, error)
+
+	// Delete removes the artifact. Deleting a missing artifact succeeds.
+	Delete(ctx context.Context) error
+}
+
+// Secrets returns the data of a Secret in the namespace of the model.
+type Secrets func(ctx context.Context, name string) (map[string][]byte, error)
+
+// Options tune how backends talk to their store.
+type Options struct {
+	// HTTPClient is used by the backends talking HTTP. Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+
+	// MountPath is where the claim of a PVC backend is mounted.
+	MountPath string
+}
+
+func (o Options) httpClient() *http.Client {
+	if o.HTTPClient != nil {
+		return o.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// New returns the backend of spec. Credentials are read through secrets.
+func New(ctx context.Context, spec *mlv1alpha1.ModelStorage, secrets Secrets, opts Options) (Backend, error) {
+	switch {
+	case spec.S3 != nil:
+		return newS3(ctx, spec.S3, secrets, opts)
+	case spec.GCS != nil:
+		return newGCS(ctx, spec.GCS, secrets, opts)
+	case spec.AzureBlob != nil:
+		return newAzureBlob(ctx, spec.AzureBlob, secrets, opts)
+	case spec.HTTP != nil:
+		return newHTTP(ctx, spec.HTTP, secrets, opts)
+	case spec.PVC != nil:
+		return newPVC(spec.PVC, opts), nil
+	case spec.OCI != nil:
+		return newOCI(ctx, spec.OCI, secrets, opts)
+	}
+	return nil, errors.New("storage: no backend set")
+}
+
+// URI identifies the artifact of spec across backends, e.g. s3://bucket/key
+// or gs://bucket/object.
+func URI(spec *mlv1alpha1.ModelStorage) string {
+	switch {
+	case spec.S3 != nil:
+		return fmt.Sprintf("s3://%s/%s", spec.S3.Bucket, strings.TrimPrefix(spec.S3.Key, "/"))
+	case spec.GCS != nil:
+		return fmt.Sprintf("gs://%s/%s", spec.GCS.Bucket, strings.TrimPrefix(spec.GCS.Object, "/"))
+	case spec.AzureBlob != nil:
+		return fmt.Sprintf("azblob://%s/%s/%s", spec.AzureBlob.AccountName, spec.AzureBlob.Container, strings.TrimPrefix(spec.AzureBlob.Blob, "/"))
+	case spec.HTTP != nil:
+		return spec.HTTP.URL
+	case spec.PVC != nil:
+		return fmt.Sprintf("pvc://%s/%s", spec.PVC.ClaimName, strings.TrimPrefix(spec.PVC.Path, "/"))
+	case spec.OCI != nil:
+		return "oci://" + spec.OCI.Reference
+	}
+	return ""
+}
+
+// Relocate returns a copy of spec pointing at location, a URI returned by
+// URI or, for S3, a bare key. Endpoints and credentials are kept, so the
+// location must be in the same backend as spec.
+func Relocate(spec *mlv1alpha1.ModelStorage, location string) (*mlv1alpha1.ModelStorage, error) {
+	out := spec.DeepCopy()
+
+	scheme, rest, ok := strings.Cut(location, "://")
+	if !ok {
+		if out.S3 == nil {
+			return nil, fmt.Errorf("storage: %q is not a URI", location)
+		}
+		out.S3.Key = location
+		return out, nil
+	}
+	first, path, _ := strings.Cut(rest, "/")
+
+	switch {
+	case scheme == "s3" && out.S3 != nil:
+		out.S3.Bucket, out.S3.Key = first, path
+	case scheme == "gs" && out.GCS != nil:
+		out.GCS.Bucket, out.GCS.Object = first, path
+	case scheme == "azblob" && out.AzureBlob != nil:
+		container, blob, _ := strings.Cut(path, "/")
+		out.AzureBlob.AccountName, out.AzureBlob.Container, out.AzureBlob.Blob = first, container, blob
+	case (scheme == "http" || scheme == "https") && out.HTTP != nil:
+		out.HTTP.URL = location
+	case scheme == "pvc" && out.PVC != nil:
+		out.PVC.ClaimName, out.PVC.Path = first, path
+	case scheme == "oci" && out.OCI != nil:
+		out.OCI.Reference = rest
+	default:
+		return nil, fmt.Errorf("storage: %q is not in the backend of %q", location, URI(spec))
+	}
+	return out, nil
+}
+
+// secretValue returns key of the named Secret.
+func secretValue(ctx context.Context, secrets Secrets, name string, key string) (string, error) {
+	data, err := secrets(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("storage: secret %s has no key %s", name, key)
+	}
+	return string(value), nil
+}
+
+// Error is returned for HTTP responses outside the 2xx range.
+type Error struct {
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("storage: %s: unexpected status %d: %s", e.URL, e.StatusCode, e.Body)
+}
+
+// IsNotFound reports whether err means the artifact does not exist.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// do sends req and returns the response if its status is 2xx.
+func do(client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return check(req, resp)
+}
+
+// check closes resp and returns an Error if its status is not 2xx.
+func check(req *http.Request, resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &Error{URL: redact(req.URL), StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return resp, nil
+}
+
+// redact drops the query of u, which may carry a signature.
+func redact(u *url.URL) string {
+	c := *u
+	c.RawQuery = ""
+	return c.String()
+}
@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	mlv1alpha1 "github.com/kalkyai/model-serving-operator/api/v1alpha1"
+)
+
+const model = "model bytes"
+
+// secrets serves the given Secrets by name.
+func secrets(data map[string]map[string][]byte) Secrets {
+	return func(ctx context.Context, name string) (map[string][]byte, error) {
+		secret, ok := data[name]
+		if !ok {
+			return nil, fmt.Errorf("secret %s not found", name)
+		}
+		return secret, nil
+	}
+}
+
+func read(t *testing.T, b Backend) string {
+	t.Helper()
+	body, err := b.Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	content, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestS3(t *testing.T) {
+	objects := map[string]string{"/models/iris/model.sav": model}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=access/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			content, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			io.WriteString(w, content)
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	spec := &mlv1alpha1.ModelStorage{S3: &mlv1alpha1.S3Storage{
+		Endpoint:             server.URL,
+		Bucket:               "models",
+		Key:                  "iris/model.sav",
+		CredentialsSecretRef: &mlv1alpha1.CredentialsSecretRef{Name: "credentials"},
+	}}
+	b, err := New(context.Background(), spec, secrets(map[string]map[string][]byte{
+		"credentials": {"access_key": []byte("access"), "secret_key": []byte("secret")},
+	}), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := read(t, b); got != model {
+		t.Errorf("Open = %q, want %q", got, model)
+	}
+	if err := b.Delete(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Open(context.Background()); !IsNotFound(err) {
+		t.Errorf("Open after Delete = %v, want a not found error", err)
+	}
+}
+
+func TestGCS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"token","token_type":"Bearer","expires_in":3600}`)
+	})
+	mux.HandleFunc("/download/storage/v1/b/models/o/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.URL.Query().Get("alt") != "media" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.EscapedPath() != "/download/storage/v1/b/models/o/iris%2Fmodel.sav" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, model)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	serviceAccount, _ := json.Marshal(serviceAccountKey{
+		ClientEmail: "operator@project.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:    server.URL + "/token",
+	})
+	spec := &mlv1alpha1.ModelStorage{GCS: &mlv1alpha1.GCSStorage{
+		Bucket: "models",
+		Object: "iris/model.sav",
+		ServiceAccountKeySecretRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "gcs"},
+			Key:                  "key.json",
+		},
+	}}
+	b, err := newGCS(context.Background(), spec.GCS, secrets(map[string]map[string][]byte{
+		"gcs": {"key.json": serviceAccount},
+	}), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.endpoint = server.URL
+
+	if got := read(t, b); got != model {
+		t.Errorf("Open = %q, want %q", got, model)
+	}
+}
+
+func TestAzureBlob(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sig") != "signature" || r.Header.Get("x-ms-version") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/models/iris/model.sav" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, model)
+	}))
+	defer server.Close()
+
+	spec := &mlv1alpha1.ModelStorage{AzureBlob: &mlv1alpha1.AzureBlobStorage{
+		AccountName: "account",
+		Container:   "models",
+		Blob:        "iris/model.sav",
+		Endpoint:    server.URL,
+		SASTokenSecretRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "azure"},
+			Key:                  "sas",
+		},
+	}}
+	b, err := New(context.Background(), spec, secrets(map[string]map[string][]byte{
+		"azure": {"sas": []byte("?sv=2021-08-06&sig=signature")},
+	}), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := read(t, b); got != model {
+		t.Errorf("Open = %q, want %q", got, model)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, model)
+	}))
+	defer server.Close()
+
+	spec := &mlv1alpha1.ModelStorage{HTTP: &mlv1alpha1.HTTPStorage{
+		URL: server.URL + "/iris/model.sav",
+		AuthorizationSecretRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "http"},
+			Key:                  "authorization",
+		},
+	}}
+	b, err := New(context.Background(), spec, secrets(map[string]map[string][]byte{
+		"http": {"authorization": []byte("Bearer secret")},
+	}), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := read(t, b); got != model {
+		t.Errorf("Open = %q, want %q", got, model)
+	}
+	if err := b.Delete(context.Background()); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Delete = %v, want ErrNotSupported", err)
+	}
+}
+
+func TestPVC(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "iris"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "iris", "model.sav"), []byte(model), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	spec := &mlv1alpha1.ModelStorage{PVC: &mlv1alpha1.PVCStorage{ClaimName: "models", Path: "../iris/model.sav"}}
+	b, err := New(context.Background(), spec, nil, Options{MountPath: filepath.Join(dir, "iris")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The path cannot leave the mount.
+	if _, err := b.Open(context.Background()); !os.IsNotExist(err) {
+		t.Errorf("Open outside the mount = %v, want a not exist error", err)
+	}
+
+	spec.PVC.Path = "iris/model.sav"
+	b, err = New(context.Background(), spec, nil, Options{MountPath: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, b); got != model {
+		t.Errorf("Open = %q, want %q", got, model)
+	}
+}
+
+func TestOCI(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			user, password, _ := r.BasicAuth()
+			if user != "user" || password != "password" || r.URL.Query().Get("scope") != "repository:models/iris:pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			io.WriteString(w, `{"token":"token"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:models/iris:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/models/iris/manifests/1.0":
+			w.Header().Set("Content-Type", ociManifestTypes[0])
+			fmt.Fprintf(w, `{"schemaVersion":2,"layers":[{"mediaType":"application/octet-stream","digest":%q,"size":%d}]}`, digest, len(model))
+		case "/v2/models/iris/blobs/" + digest:
+			io.WriteString(w, model)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+	dockerConfig := fmt.Sprintf(`{"auths":{%q:{"username":"user","password":"password"}}}`, registry)
+	spec := &mlv1alpha1.ModelStorage{OCI: &mlv1alpha1.OCIStorage{
+		Reference:     registry + "/models/iris:1.0",
+		PullSecretRef: &corev1.LocalObjectReference{Name: "registry"},
+		PlainHTTP:     true,
+	}}
+	b, err := New(context.Background(), spec, secrets(map[string]map[string][]byte{
+		"registry": {corev1.DockerConfigJsonKey: []byte(dockerConfig)},
+	}), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := read(t, b); got != model {
+		t.Errorf("Open = %q, want %q", got, model)
+	}
+}
+
+func TestRelocate(t *testing.T) {
+	spec := &mlv1alpha1.ModelStorage{S3: &mlv1alpha1.S3Storage{
+		Endpoint: "https://sgp1.digitaloceanspaces.com",
+		Bucket:   "models",
+		Key:      "iris/0.7.sav",
+	}}
+
+	for location, want := range map[string]string{
+		"iris/0.6.sav":             "s3://models/iris/0.6.sav",
+		"s3://archive/iris.sav":    "s3://archive/iris.sav",
+		"gs://models/iris/0.6.sav": "",
+	} {
+		out, err := Relocate(spec, location)
+		if want == "" {
+			if err == nil {
+				t.Errorf("Relocate(%q) succeeded, want an error for another backend", location)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := URI(out); got != want {
+			t.Errorf("Relocate(%q) = %q, want %q", location, got, want)
+		}
+		if out.S3.Endpoint != spec.S3.Endpoint {
+			t.Errorf("Relocate(%q) dropped the endpoint", location)
+		}
+	}
+}